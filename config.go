@@ -1,6 +1,8 @@
 package rawsock
 
 import (
+	"time"
+
 	"github.com/lysShub/rawsock/helper/ipstack"
 )
 
@@ -9,7 +11,25 @@ type Config struct {
 	SetGRO   bool
 	IPStack  *ipstack.Configs
 
+	// keep nic GRO, split recved super-packet in userspace, only AF_PACKET
+	// backend support
+	UserGRO bool
+
 	DivertPriorty int16
+
+	// neighbor (gateway) resolve by ARP/NDP, timeout of once resolve and retry times
+	ARPTimeout time.Duration
+	ARPRetry   int
+
+	// AF_PACKET backend offload outbound tcp/udp checksum by PACKET_VNET_HDR,
+	// only take effect with ipstack.ReCalcChecksum and not loopback nic
+	ChecksumOffload bool
+
+	// use PACKET_MMAP ring for AF_PACKET backend, zero ring size use default
+	PacketMMAP    bool
+	RingBlockSize int
+	RingBlockNum  int
+	RingFrameSize int
 }
 
 type Option func(*Config)
@@ -21,6 +41,11 @@ func Options(opts ...Option) *Config {
 		IPStack:  ipstack.Options(),
 
 		DivertPriorty: 0,
+
+		ARPTimeout: time.Second * 3,
+		ARPRetry:   0,
+
+		ChecksumOffload: false,
 	}
 	for _, opt := range opts {
 		opt(cfg)
@@ -35,17 +60,70 @@ func UsedPort() Option {
 	}
 }
 
-// Checksum set recv/send tansport packet checksum calcuate mode
-// todo: replace by TX checksum offload
+// Checksum set recv/send tansport packet checksum calcuate mode, AF_PACKET
+// backend offload ReCalcChecksum to nic if supported, see ChecksumOffload.
 func Checksum(opts ...ipstack.Option) Option {
+	return IPStack(opts...)
+}
+
+// IPStack set ipstack options, such as TTL, DSCP, DontFragment, FlowLabel and
+// IPv4Options of built ip header, merged with previous set options. raw socket
+// backend's outbound ip header is built by kernel, not affected.
+func IPStack(opts ...ipstack.Option) Option {
 	return func(c *Config) {
+		if c.IPStack != nil {
+			opts = append([]ipstack.Option{c.IPStack.Unmarshal()}, opts...)
+		}
 		c.IPStack = ipstack.Options(opts...)
 	}
 }
 
-// SetGRO is set gro to off, deafult true
+// ChecksumOffload set whether AF_PACKET backend offload outbound tcp/udp
+// checksum to nic by PACKET_VNET_HDR, fallback to software checksum if not
+// supported, default false. virtual nic such as veth, bridge and tap deliver
+// the partial checksum packet to local peer without fill it, so only enable
+// it if the packet is sent to wire by physical nic.
+func ChecksumOffload(enable bool) Option {
+	return func(c *Config) {
+		c.ChecksumOffload = enable
+	}
+}
+
+// SetGRO is set gro to off, deafult true. the original nic settings is
+// restored after all Conn closed, or by Cleanup before process exit.
 func SetGRO(set bool) Option {
 	return func(c *Config) {
 		c.SetGRO = set
 	}
 }
+
+// UserGRO not disable nic GRO/TSO, AF_PACKET backend recv coalesced super-packet
+// by PACKET_VNET_HDR, and split it to wire-sized segments in userspace, the
+// segment's checksum is re-calculated. SetGRO is ignored if set, raw socket
+// backend not support, still disable nic GRO by SetGRO.
+func UserGRO() Option {
+	return func(c *Config) {
+		c.UserGRO = true
+	}
+}
+
+// PacketMMAP use PACKET_MMAP ring for AF_PACKET backend, recv by TPACKET_V3
+// block-based ring, send by TPACKET_V2 ring. blockSize must be multiple of
+// page size, frameSize limit max size of sent packet, zero use default.
+func PacketMMAP(blockSize, blockNum, frameSize int) Option {
+	return func(c *Config) {
+		c.PacketMMAP = true
+		c.RingBlockSize = blockSize
+		c.RingBlockNum = blockNum
+		c.RingFrameSize = frameSize
+	}
+}
+
+// ARP set timeout and retry times of gateway resolve for AF_PACKET backend,
+// also used by NDP for ipv6, default 3s and not retry.
+func ARP(timeout time.Duration, retry int) Option {
+	return func(c *Config) {
+		c.ARPTimeout = timeout
+		c.ARPRetry = retry
+	}
+}
@@ -0,0 +1,105 @@
+//go:build linux
+// +build linux
+
+package helper
+
+import (
+	"sync"
+
+	"github.com/lysShub/netkit/errorx"
+	"github.com/lysShub/netkit/packet"
+	"github.com/pkg/errors"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// Batch read/write multiple packets by one recvmmsg/sendmmsg syscall
+type Batch struct {
+	ip   *IPConn
+	conn interface {
+		ReadBatch(ms []ipv4.Message, flags int) (int, error)
+		WriteBatch(ms []ipv4.Message, flags int) (int, error)
+	}
+
+	// ipv6 ancillary data buffer of ReadBatch
+	oob   []byte
+	oobMu sync.Mutex
+}
+
+func NewBatch(conn *IPConn) *Batch {
+	if conn.IPv4() {
+		return &Batch{ip: conn, conn: ipv4.NewPacketConn(conn.IPConn)}
+	}
+	return &Batch{ip: conn, conn: conn.ipv6}
+}
+
+// ReadBatch read ip packets, return the number of packets read, every packet's
+// ip header will be stripped, same as CheckIP.
+func (b *Batch) ReadBatch(pkts []*packet.Packet) (n int, err error) {
+	if len(pkts) == 0 {
+		return 0, nil
+	}
+
+	if !b.ip.IPv4() {
+		b.oobMu.Lock()
+		defer b.oobMu.Unlock()
+		if len(b.oob) < len(pkts)*oobSize6 {
+			b.oob = make([]byte, len(pkts)*oobSize6)
+		}
+	}
+
+	var ms = make([]ipv4.Message, len(pkts))
+	for i, pkt := range pkts {
+		if b.ip.IPv4() {
+			ms[i].Buffers = [][]byte{pkt.Bytes()}
+		} else {
+			if pkt.Data() < header.IPv6MinimumSize {
+				return 0, errorx.ShortBuff(header.IPv6MinimumSize, pkt.Data())
+			}
+			ms[i].Buffers = [][]byte{pkt.Bytes()[header.IPv6MinimumSize:]}
+			ms[i].OOB = b.oob[i*oobSize6 : (i+1)*oobSize6]
+		}
+	}
+	var flags int
+	if !b.ip.IPv4() {
+		flags = unix.MSG_TRUNC // see IPConn.recv
+	}
+	n, err = b.conn.ReadBatch(ms, flags)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	for i := 0; i < n; i++ {
+		if b.ip.IPv4() {
+			pkts[i].SetData(ms[i].N)
+		} else {
+			m, err := b.ip.rebuild6(pkts[i].Bytes(), ms[i].N, ms[i].OOB[:ms[i].NN], ms[i].Addr)
+			if err != nil {
+				return i, err
+			}
+			pkts[i].SetData(m)
+		}
+
+		hdrLen, err := CheckIP(pkts[i].Bytes())
+		if err != nil {
+			return i, err
+		}
+		pkts[i].SetHead(pkts[i].Head() + hdrLen)
+	}
+	return n, nil
+}
+
+// WriteBatch write packets, return the number of packets written.
+func (b *Batch) WriteBatch(pkts []*packet.Packet) (n int, err error) {
+	if len(pkts) == 0 {
+		return 0, nil
+	}
+
+	var ms = make([]ipv4.Message, len(pkts))
+	for i, pkt := range pkts {
+		ms[i].Buffers = [][]byte{pkt.Bytes()}
+	}
+	n, err = b.conn.WriteBatch(ms, 0)
+	return n, errors.WithStack(err)
+}
@@ -6,7 +6,6 @@ package bind
 import (
 	"net"
 	"net/netip"
-	"os/exec"
 	"sync"
 	"unsafe"
 
@@ -133,36 +132,23 @@ func BindLocal(proto tcpip.TransportProtocolNumber, laddr netip.AddrPort, usedPo
 	return fd, laddr, nil
 }
 
-// todo: 不需要这个cache, server直接在listen时就设置，accpet到conn时init传个flag
-var ethOffloadCache = struct {
-	sync.RWMutex
-	GRO map[netip.Addr]bool
-}{
-	GRO: map[netip.Addr]bool{},
-}
+// get route table is expensive call, cache nic name of local and remote address
+var ifaceCache sync.Map // ifaceKey:string
 
-// todo: 还有 generic-segmentation-offload, large-receive-offload
-func SetGRO(local, remote netip.Addr, gro bool) error {
-	// get route table is expensive call, cache it.
-	if !remote.IsPrivate() {
-		ethOffloadCache.RLock()
-		old, has := ethOffloadCache.GRO[local]
-		ethOffloadCache.RUnlock()
+type ifaceKey struct{ local, remote netip.Addr }
 
-		if has && old == gro {
-			return nil
-		} else {
-			defer func() {
-				ethOffloadCache.Lock()
-				ethOffloadCache.GRO[local] = gro
-				ethOffloadCache.Unlock()
-			}()
+// SetGRO set gro and rx-gro-hw of the nic that route local to remote, the
+// original settings is restored when all references of the nic closed.
+func SetGRO(local, remote netip.Addr, gro bool) (*OffloadRef, error) {
+	if !remote.IsPrivate() {
+		if name, has := ifaceCache.Load(ifaceKey{local, remote}); has {
+			return setGRO(name.(string), gro)
 		}
 	}
 
 	table, err := route.GetTable()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var ifIdx uint32
@@ -182,24 +168,55 @@ func SetGRO(local, remote netip.Addr, gro bool) error {
 		}
 	}
 	if ifIdx == 0 {
-		return errors.Errorf("invalid local address %s", local.String())
+		// route table only support ipv4
+		ifIdx = ifaceByAddr(local)
+	}
+	if ifIdx == 0 {
+		return nil, errors.Errorf("invalid local address %s", local.String())
 	}
 
 	name, err := netcall.IoctlGifname(int(ifIdx))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if err := netcall.IoctlGRO(name, gro); err != nil {
-		return err
+	if !remote.IsPrivate() {
+		ifaceCache.Store(ifaceKey{local, remote}, name)
 	}
+	return setGRO(name, gro)
+}
 
-	// todo: support rx-gro-hw
-	// ethtool --offload eth0 rx-gro-hw off
-	cmd := exec.Command("ethtool", "--offload", name, "rx-gro-hw", "off")
-	out, err := cmd.CombinedOutput()
-	if err != nil || len(out) > 0 {
-		return errors.Errorf(`exec "%s", error: %s, message: %s`, cmd.String(), err, string(out))
+func setGRO(name string, gro bool) (*OffloadRef, error) {
+	var features = GRO
+	// rx-gro-hw only supported by some nics, and always fixed on others
+	if _, fixed, err := featureState(name, GROHW); err == nil && !fixed {
+		features |= GROHW
 	}
+	return SetOffload(name, features, gro)
+}
 
-	return nil
+// SetTSO set nic tcp-segmentation-offload and generic-segmentation-offload,
+// the original settings is restored when all references of the nic closed.
+func SetTSO(name string, tso bool) (*OffloadRef, error) {
+	return SetOffload(name, TSO|GSO, tso)
+}
+
+func ifaceByAddr(addr netip.Addr) uint32 {
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return 0
+	}
+	for _, i := range ifs {
+		addrs, err := i.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if a, ok := a.(*net.IPNet); ok {
+				if ip, ok := netip.AddrFromSlice(a.IP); ok && ip.Unmap() == addr.Unmap() {
+					return uint32(i.Index)
+				}
+			}
+		}
+	}
+	return 0
 }
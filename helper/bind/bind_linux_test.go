@@ -90,16 +90,11 @@ func Test_ListenLocal(t *testing.T) {
 func Test_SetGRO(t *testing.T) {
 
 	t.Run("base", func(t *testing.T) {
-		err := bind.SetGRO(test.LocIP(), netip.AddrFrom4([4]byte{8, 8, 8, 8}), false)
+		ref, err := bind.SetGRO(test.LocIP(), netip.AddrFrom4([4]byte{8, 8, 8, 8}), false)
 		require.NoError(t, err)
+		defer ref.Close()
 
 		// todo: valid it
-
-		// bind.SetGRO(test.LocIP(), netip.AddrFrom4([4]byte{8, 8, 8, 8}), true)
 	})
 
 }
-
-func Test_SetGRO_Cache(t *testing.T) {
-	t.Skip("todo")
-}
@@ -73,3 +73,6 @@ func BindLocal(proto tcpip.TransportProtocolNumber, laddr netip.AddrPort, usedPo
 	}
 	return fd, laddr, nil
 }
+
+// RestoreOffload nic offload settings is not changed on windows.
+func RestoreOffload() error { return nil }
@@ -0,0 +1,233 @@
+//go:build linux
+// +build linux
+
+package bind
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// FixedOffloadError offload feature of nic is fixed, can't be changed
+type FixedOffloadError struct {
+	Name    string
+	Offload Offload
+}
+
+func (e FixedOffloadError) Error() string {
+	return fmt.Sprintf("%s of %s is fixed", e.Offload, e.Name)
+}
+
+// NotSupportOffloadError offload feature not supported by nic or kernel
+type NotSupportOffloadError struct {
+	Name    string
+	Offload Offload
+}
+
+func (e NotSupportOffloadError) Error() string {
+	return fmt.Sprintf("%s of %s not support", e.Offload, e.Name)
+}
+
+// netdev feature names of offload, the first is used to get state, TSO
+// include all tcp segmentation features same as ETHTOOL_STSO.
+var offloadFeatures = map[Offload][]string{
+	GRO:   {"rx-gro"},
+	GSO:   {"tx-generic-segmentation"},
+	TSO:   {"tx-tcp-segmentation", "tx-tcp-ecn-segmentation", "tx-tcp-mangleid-segmentation", "tx-tcp6-segmentation"},
+	LRO:   {"rx-lro"},
+	GROHW: {"rx-gro-hw"},
+}
+
+const (
+	ethSSFeatures = 4  // ETH_SS_FEATURES
+	ethGStringLen = 32 // ETH_GSTRING_LEN
+)
+
+// features netdev feature name to bit index, it's same for all nics
+var features = struct {
+	sync.Mutex
+	idx map[string]int
+}{}
+
+func featureIndex(name, feature string) (int, bool, error) {
+	features.Lock()
+	defer features.Unlock()
+	if features.idx == nil {
+		idx, err := featureNames(name)
+		if err != nil {
+			return 0, false, err
+		}
+		features.idx = idx
+	}
+	i, has := features.idx[feature]
+	return i, has, nil
+}
+
+// featureNames get ETH_SS_FEATURES string set by ETHTOOL_GSTRINGS
+func featureNames(name string) (map[string]int, error) {
+	// struct ethtool_sset_info {cmd, reserved, sset_mask, data[1]}
+	var info = make([]byte, 24)
+	binary.NativeEndian.PutUint32(info[0:], unix.ETHTOOL_GSSET_INFO)
+	binary.NativeEndian.PutUint64(info[8:], 1<<ethSSFeatures)
+	if err := ethtool(name, info); err != nil {
+		return nil, err
+	} else if binary.NativeEndian.Uint64(info[8:]) == 0 {
+		return nil, errors.Errorf("%s not support feature strings", name)
+	}
+	n := int(binary.NativeEndian.Uint32(info[16:]))
+
+	// struct ethtool_gstrings {cmd, string_set, len, data[]}
+	var b = make([]byte, 12+n*ethGStringLen)
+	binary.NativeEndian.PutUint32(b[0:], unix.ETHTOOL_GSTRINGS)
+	binary.NativeEndian.PutUint32(b[4:], ethSSFeatures)
+	binary.NativeEndian.PutUint32(b[8:], uint32(n))
+	if err := ethtool(name, b); err != nil {
+		return nil, err
+	}
+
+	var idx = make(map[string]int, n)
+	for i := 0; i < n; i++ {
+		s := b[12+i*ethGStringLen : 12+(i+1)*ethGStringLen]
+		if j := bytes.IndexByte(s, 0); j >= 0 {
+			s = s[:j]
+		}
+		idx[string(s)] = i
+	}
+	return idx, nil
+}
+
+// getFeatures get features blocks by ETHTOOL_GFEATURES, every block is
+// struct ethtool_get_features_block {available, requested, active, never_changed}
+func getFeatures(name string) ([][4]uint32, error) {
+	const blocks = 8
+	var b = make([]byte, 8+blocks*16)
+	binary.NativeEndian.PutUint32(b[0:], unix.ETHTOOL_GFEATURES)
+	binary.NativeEndian.PutUint32(b[4:], blocks)
+	if err := ethtool(name, b); err != nil {
+		return nil, err
+	}
+
+	// kernel set size as ETHTOOL_DEV_FEATURE_WORDS, ETHTOOL_SFEATURES require same size
+	size := binary.NativeEndian.Uint32(b[4:])
+	if size > blocks {
+		return nil, errors.Errorf("not support %d features blocks", size)
+	}
+	var fs = make([][4]uint32, size)
+	for i := range fs {
+		for j := range fs[i] {
+			fs[i][j] = binary.NativeEndian.Uint32(b[8+i*16+j*4:])
+		}
+	}
+	return fs, nil
+}
+
+// featureState get offload feature state, fixed means can't be changed
+func featureState(name string, f Offload) (active, fixed bool, err error) {
+	names := offloadFeatures[f]
+	if len(names) == 0 {
+		return false, false, errors.Errorf("invalid offload %d", f)
+	}
+	i, has, err := featureIndex(name, names[0])
+	if errors.Is(err, unix.EOPNOTSUPP) {
+		return false, false, errors.WithStack(NotSupportOffloadError{Name: name, Offload: f})
+	} else if err != nil {
+		return false, false, errors.WithMessagef(err, "get %s of %s", f, name)
+	} else if !has {
+		return false, false, errors.WithStack(NotSupportOffloadError{Name: name, Offload: f})
+	}
+	fs, err := getFeatures(name)
+	if err != nil {
+		return false, false, errors.WithMessagef(err, "get %s of %s", f, name)
+	} else if i/32 >= len(fs) {
+		return false, false, errors.WithStack(NotSupportOffloadError{Name: name, Offload: f})
+	}
+
+	bit := uint32(1) << (i % 32)
+	block := fs[i/32]
+	active = block[2]&bit != 0
+	fixed = block[0]&bit == 0 || block[3]&bit != 0
+	return active, fixed, nil
+}
+
+// setFeature set offload feature by ETHTOOL_SFEATURES
+func setFeature(name string, f Offload, enable bool) error {
+	if _, fixed, err := featureState(name, f); err != nil {
+		return err
+	} else if fixed {
+		return errors.WithStack(FixedOffloadError{Name: name, Offload: f})
+	}
+	fs, err := getFeatures(name)
+	if err != nil {
+		return errors.WithMessagef(err, "set %s of %s", f, name)
+	}
+
+	// struct ethtool_sfeatures {cmd, size, features[]{valid, requested}}
+	var b = make([]byte, 8+len(fs)*8)
+	binary.NativeEndian.PutUint32(b[0:], unix.ETHTOOL_SFEATURES)
+	binary.NativeEndian.PutUint32(b[4:], uint32(len(fs)))
+	for _, e := range offloadFeatures[f] {
+		i, has, err := featureIndex(name, e)
+		if err != nil {
+			return err
+		} else if !has || i/32 >= len(fs) {
+			continue
+		}
+		bit := uint32(1) << (i % 32)
+		if block := fs[i/32]; block[0]&bit == 0 || block[3]&bit != 0 {
+			continue // fixed
+		}
+
+		off := 8 + i/32*8
+		valid := binary.NativeEndian.Uint32(b[off:]) | bit
+		requested := binary.NativeEndian.Uint32(b[off+4:]) &^ bit
+		if enable {
+			requested |= bit
+		}
+		binary.NativeEndian.PutUint32(b[off:], valid)
+		binary.NativeEndian.PutUint32(b[off+4:], requested)
+	}
+
+	if err := ethtool(name, b); err != nil {
+		return errors.WithMessagef(err, "set %s of %s", f, name)
+	}
+	return nil
+}
+
+// ifreqData struct ifreq with ifr_data, padding to the size of ifr_ifru union
+type ifreqData struct {
+	name [unix.IFNAMSIZ]byte
+	data uintptr
+	_    [24 - unsafe.Sizeof(uintptr(0))]byte
+}
+
+// ethtool SIOCETHTOOL ioctl, data is ethtool command struct, it's pinned
+// during the ioctl because ifr_data is referenced by uintptr.
+func ethtool(name string, data []byte) error {
+	if len(name) >= unix.IFNAMSIZ {
+		return errors.Errorf("invalid nic name %s", name)
+	}
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer unix.Close(fd)
+
+	var pin runtime.Pinner
+	defer pin.Unpin()
+	pin.Pin(&data[0])
+
+	var req = &ifreqData{data: uintptr(unsafe.Pointer(&data[0]))}
+	copy(req.name[:], name)
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.SIOCETHTOOL, uintptr(unsafe.Pointer(req)))
+	if errno != 0 {
+		return errors.WithStack(errno)
+	}
+	return nil
+}
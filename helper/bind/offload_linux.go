@@ -0,0 +1,150 @@
+//go:build linux
+// +build linux
+
+package bind
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Offload nic offload features
+type Offload uint8
+
+const (
+	GRO   Offload = 1 << iota // generic-receive-offload
+	GSO                       // generic-segmentation-offload
+	TSO                       // tcp-segmentation-offload
+	LRO                       // large-receive-offload
+	GROHW                     // rx-gro-hw
+)
+
+func (o Offload) String() string {
+	var names []string
+	for _, e := range o.split() {
+		switch e {
+		case GRO:
+			names = append(names, "generic-receive-offload")
+		case GSO:
+			names = append(names, "generic-segmentation-offload")
+		case TSO:
+			names = append(names, "tcp-segmentation-offload")
+		case LRO:
+			names = append(names, "large-receive-offload")
+		case GROHW:
+			names = append(names, "rx-gro-hw")
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+func (o Offload) split() (fs []Offload) {
+	for f := GRO; f <= GROHW; f <<= 1 {
+		if o&f != 0 {
+			fs = append(fs, f)
+		}
+	}
+	return fs
+}
+
+// OffloadRef reference of nic offload settings changed by SetOffload, the
+// original settings is restored when all references of the nic are closed.
+type OffloadRef struct {
+	name   string
+	nic    *nicOffload
+	closed atomic.Bool
+}
+
+// offloads original offload settings of nics that changed by process
+var offloads = struct {
+	sync.Mutex
+	nics map[string]*nicOffload
+}{nics: map[string]*nicOffload{}}
+
+type nicOffload struct {
+	refs int
+	orig map[Offload]bool
+}
+
+// SetOffload set offload features of nic, record original settings before
+// change, the returned reference should be closed when not used. return
+// FixedOffloadError or NotSupportOffloadError if feature can't be changed.
+func SetOffload(name string, features Offload, enable bool) (*OffloadRef, error) {
+	offloads.Lock()
+	defer offloads.Unlock()
+
+	nic := offloads.nics[name]
+	if nic == nil {
+		nic = &nicOffload{orig: map[Offload]bool{}}
+	}
+	for _, f := range features.split() {
+		old, err := GetOffload(name, f)
+		if err == nil && old != enable {
+			if err = setFeature(name, f, enable); err == nil {
+				if _, has := nic.orig[f]; !has {
+					nic.orig[f] = old
+				}
+			}
+		}
+		if err != nil {
+			if nic.refs == 0 {
+				restoreOffload(name, nic)
+			}
+			return nil, err
+		}
+	}
+
+	nic.refs++
+	offloads.nics[name] = nic
+	return &OffloadRef{name: name, nic: nic}, nil
+}
+
+// Close release the reference, restore original settings if it's the last
+// reference of the nic, nil reference is valid.
+func (r *OffloadRef) Close() error {
+	if r == nil || !r.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	offloads.Lock()
+	defer offloads.Unlock()
+
+	if offloads.nics[r.name] != r.nic {
+		return nil // restored by RestoreOffload
+	} else if r.nic.refs--; r.nic.refs > 0 {
+		return nil
+	}
+	delete(offloads.nics, r.name)
+	return restoreOffload(r.name, r.nic)
+}
+
+// RestoreOffload restore offload settings of all nics changed by SetOffload,
+// regardless of references. called by rawsock.Cleanup before process exit,
+// because not closed Conn will not restore settings.
+func RestoreOffload() (err error) {
+	offloads.Lock()
+	defer offloads.Unlock()
+
+	for name, nic := range offloads.nics {
+		if e := restoreOffload(name, nic); err == nil {
+			err = e
+		}
+		delete(offloads.nics, name)
+	}
+	return err
+}
+
+func restoreOffload(name string, nic *nicOffload) (err error) {
+	for f, old := range nic.orig {
+		if e := setFeature(name, f, old); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// GetOffload get offload feature state of nic
+func GetOffload(name string, f Offload) (bool, error) {
+	active, _, err := featureState(name, f)
+	return active, err
+}
@@ -0,0 +1,86 @@
+//go:build linux
+// +build linux
+
+package bind_test
+
+import (
+	"testing"
+
+	"github.com/lysShub/rawsock"
+	"github.com/lysShub/rawsock/helper/bind"
+	"github.com/lysShub/rawsock/test"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SetOffload(t *testing.T) {
+	name := test.CreateVeth(t, "rawsock-off", nil, nil).Name
+
+	orig, err := bind.GetOffload(name, bind.TSO)
+	require.NoError(t, err)
+
+	t.Run("refs", func(t *testing.T) {
+		ref1, err := bind.SetOffload(name, bind.TSO|bind.GSO, !orig)
+		require.NoError(t, err)
+		ref2, err := bind.SetTSO(name, !orig)
+		require.NoError(t, err)
+
+		cur, err := bind.GetOffload(name, bind.TSO)
+		require.NoError(t, err)
+		require.Equal(t, !orig, cur)
+
+		// restore after the last reference closed
+		require.NoError(t, ref1.Close())
+		require.NoError(t, ref1.Close())
+		cur, err = bind.GetOffload(name, bind.TSO)
+		require.NoError(t, err)
+		require.Equal(t, !orig, cur)
+
+		require.NoError(t, ref2.Close())
+		cur, err = bind.GetOffload(name, bind.TSO)
+		require.NoError(t, err)
+		require.Equal(t, orig, cur)
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		ref, err := bind.SetTSO(name, !orig)
+		require.NoError(t, err)
+
+		require.NoError(t, rawsock.Cleanup())
+		cur, err := bind.GetOffload(name, bind.TSO)
+		require.NoError(t, err)
+		require.Equal(t, orig, cur)
+		require.NoError(t, ref.Close())
+	})
+
+	t.Run("fixed", func(t *testing.T) {
+		// veth not support lro
+		ref, err := bind.SetOffload(name, bind.LRO, true)
+		var e bind.FixedOffloadError
+		require.True(t, errors.As(err, &e), err)
+		require.Equal(t, bind.LRO, e.Offload)
+		require.Nil(t, ref)
+	})
+
+	t.Run("set-gro", func(t *testing.T) {
+		orig, err := bind.GetOffload(name, bind.GRO)
+		require.NoError(t, err)
+
+		ref, err := bind.SetOffload(name, bind.GRO, !orig)
+		require.NoError(t, err)
+		cur, err := bind.GetOffload(name, bind.GRO)
+		require.NoError(t, err)
+		require.Equal(t, !orig, cur)
+
+		require.NoError(t, ref.Close())
+		cur, err = bind.GetOffload(name, bind.GRO)
+		require.NoError(t, err)
+		require.Equal(t, orig, cur)
+	})
+
+	t.Run("invalid-nic", func(t *testing.T) {
+		ref, err := bind.SetTSO("rawsock-not-exist", false)
+		require.Error(t, err)
+		require.Nil(t, ref)
+	})
+}
@@ -3,15 +3,25 @@ package bpf
 import (
 	"encoding/binary"
 	"net/netip"
+	"slices"
 
+	"github.com/pkg/errors"
 	"golang.org/x/net/bpf"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 )
 
-// todo: general bpf
+// snap length of listener filters, Accept only parse headers, so only copy
+// headers to socket. ip header take the max length.
+const (
+	SnapTCP  = header.IPv4MaximumHeaderSize + header.TCPHeaderMaximumSize
+	SnapUDP  = header.IPv4MaximumHeaderSize + header.UDPMinimumSize
+	SnapICMP = header.IPv4MaximumHeaderSize + header.ICMPv4MinimumSize
+)
 
-func FilterDstPortAndTCPSyn(port uint16) []bpf.Instruction {
+// FilterDstPortAndTCPSyn filter tcp SYN with destination port, return at most
+// snap bytes of matched packet.
+func FilterDstPortAndTCPSyn(port uint16, snap uint32) []bpf.Instruction {
 	var ins = iphdrLen()
 
 	const syn = uint32(header.TCPFlagSyn)
@@ -27,7 +37,7 @@ func FilterDstPortAndTCPSyn(port uint16) []bpf.Instruction {
 		bpf.JumpIf{Cond: bpf.JumpEqual, Val: syn, SkipTrue: 1},
 		bpf.RetConstant{Val: 0},
 
-		bpf.RetConstant{Val: 0xffff},
+		bpf.RetConstant{Val: snap},
 	}...)
 
 	return ins
@@ -43,12 +53,14 @@ func FilterPorts(srcPort, dstPort uint16) []bpf.Instruction {
 	return ins
 }
 
-func FilterDstPort(port uint16) []bpf.Instruction {
+// FilterDstPort filter destination port, return at most snap bytes of
+// matched packet.
+func FilterDstPort(port uint16, snap uint32) []bpf.Instruction {
 	var ins = iphdrLen()
 
 	ins = append(ins, filterDstPort(port)...)
 	ins = append(ins,
-		bpf.RetConstant{Val: 0xffff},
+		bpf.RetConstant{Val: snap},
 	)
 	return ins
 }
@@ -80,12 +92,11 @@ func FilterEndpoint(proto tcpip.TransportProtocolNumber, src, dst netip.AddrPort
 		ins = append(ins,
 			bpf.JumpIf{Cond: bpf.JumpEqual, Val: 6, SkipTrue: 1},
 			bpf.RetConstant{Val: 0},
-			// store ip header length to reg X
-			bpf.LoadConstant{Dst: bpf.RegX, Val: 40},
 		)
+		// store ip header length to reg X, upper layer protocol to A
+		ins = append(ins, ipv6HdrLen()...)
 		if proto != 0 {
 			ins = append(ins,
-				bpf.LoadAbsolute{Off: header.IPv6NextHeaderOffset, Size: 1},
 				bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(proto), SkipTrue: 1},
 				bpf.RetConstant{Val: 0},
 			)
@@ -107,6 +118,44 @@ func FilterEndpoint(proto tcpip.TransportProtocolNumber, src, dst netip.AddrPort
 	return ins
 }
 
+// FilterICMPEchoRequest filter icmp echo request, zero ident means
+// any echo identifier, return at most snap bytes of matched packet.
+func FilterICMPEchoRequest(ident uint16, snap uint32) []bpf.Instruction {
+	var ins = iphdrLen()
+
+	ins = append(ins,
+		// type
+		bpf.LoadIndirect{Off: 0, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(header.ICMPv4Echo), SkipTrue: 1},
+		bpf.RetConstant{Val: 0},
+	)
+	if ident != 0 {
+		ins = append(ins, filterICMPIdent(ident)...)
+	}
+	ins = append(ins,
+		bpf.RetConstant{Val: snap},
+	)
+	return ins
+}
+
+// FilterICMPIdent filter icmp echo and echo reply with echo identifier
+func FilterICMPIdent(ident uint16) []bpf.Instruction {
+	var ins = iphdrLen()
+
+	ins = append(ins,
+		// type
+		bpf.LoadIndirect{Off: 0, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(header.ICMPv4Echo), SkipTrue: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(header.ICMPv4EchoReply), SkipTrue: 1},
+		bpf.RetConstant{Val: 0},
+	)
+	ins = append(ins, filterICMPIdent(ident)...)
+	ins = append(ins,
+		bpf.RetConstant{Val: 0xffff},
+	)
+	return ins
+}
+
 func filterAddrs(src, dst netip.Addr) (ins []bpf.Instruction) {
 	if src.Is4() && dst.Is4() {
 		srcInt := binary.BigEndian.Uint32(src.AsSlice())
@@ -197,19 +246,229 @@ func filterDstPort(port uint16) []bpf.Instruction {
 	}
 }
 
+// filterICMPIdent filter icmp echo identifier, require regX stored iphdr length.
+func filterICMPIdent(ident uint16) []bpf.Instruction {
+	return []bpf.Instruction{
+		bpf.LoadIndirect{Off: 4, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(ident), SkipTrue: 1},
+		bpf.RetConstant{Val: 0},
+	}
+}
+
+// FilterShard convert listener filter to only pass flows of shard idx in n
+// shards. only support filter that build by iphdrLen and end with RetConstant,
+// such as FilterDstPortAndTCPSyn.
+//
+// packet with ip header is hashed by addresses and ports, but after converted
+// by Transport (ipv6 raw socket recved packet without ipv6 header) it's only
+// hashed by ports.
+func FilterShard(ins []bpf.Instruction, n, idx uint32) ([]bpf.Instruction, error) {
+	if len(ins) == 0 {
+		return nil, errors.New("not support filter, require end with RetConstant")
+	} else if _, ok := ins[len(ins)-1].(bpf.RetConstant); !ok {
+		return nil, errors.New("not support filter, require end with RetConstant")
+	}
+	if n <= 1 {
+		return ins, nil
+	} else if idx >= n {
+		return []bpf.Instruction{bpf.RetConstant{Val: 0}}, nil
+	}
+
+	var v4 = []bpf.Instruction{
+		bpf.LoadAbsolute{Off: 12, Size: 4},
+		bpf.TAX{},
+		bpf.LoadAbsolute{Off: 16, Size: 4},
+		bpf.ALUOpX{Op: bpf.ALUOpXor},
+	}
+	var v6 = []bpf.Instruction{
+		bpf.LoadAbsolute{Off: 8, Size: 4},
+	}
+	for off := uint32(12); off < 40; off += 4 {
+		v6 = append(v6,
+			bpf.TAX{},
+			bpf.LoadAbsolute{Off: off, Size: 4},
+			bpf.ALUOpX{Op: bpf.ALUOpXor},
+		)
+	}
+	v4 = append(v4, bpf.Jump{Skip: uint32(len(v6))})
+
+	var shard = []bpf.Instruction{
+		// store transport header offset to M[0]
+		bpf.StoreScratch{Src: bpf.RegX, N: 0},
+
+		// converted by Transport, A = 0
+		bpf.TXA{},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0, SkipTrue: uint8(3 + len(v4) + len(v6))},
+
+		// A = xor of addresses
+		bpf.LoadAbsolute{Off: 0, Size: 1},
+		bpf.ALUOpConstant{Op: bpf.ALUOpShiftRight, Val: 4},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 4, SkipFalse: uint8(len(v4))},
+	}
+	shard = append(shard, v4...)
+	shard = append(shard, v6...)
+	shard = append(shard,
+		// A = A ^ ports
+		bpf.StoreScratch{Src: bpf.RegA, N: 1},
+		bpf.LoadScratch{Dst: bpf.RegX, N: 0},
+		bpf.LoadIndirect{Off: 0, Size: 4},
+		bpf.LoadScratch{Dst: bpf.RegX, N: 1},
+		bpf.ALUOpX{Op: bpf.ALUOpXor},
+
+		// A = (A ^ A>>16) % n
+		bpf.TAX{},
+		bpf.ALUOpConstant{Op: bpf.ALUOpShiftRight, Val: 16},
+		bpf.ALUOpX{Op: bpf.ALUOpXor},
+		bpf.ALUOpConstant{Op: bpf.ALUOpMod, Val: n},
+
+		bpf.LoadScratch{Dst: bpf.RegX, N: 0},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: idx, SkipTrue: 1},
+		bpf.RetConstant{Val: 0},
+	)
+
+	return append(
+		append(slices.Clone(ins[:len(ins)-1]), shard...),
+		ins[len(ins)-1],
+	), nil
+}
+
+// Transport convert filter to suit packet that start with transport header,
+// such as ipv6 raw socket recved packet, that without ipv6 header. only support
+// filter that build by iphdrLen, such as FilterPorts.
+func Transport(ins []bpf.Instruction) ([]bpf.Instruction, error) {
+	var pre = iphdrLen()
+	if len(ins) < len(pre) || !slices.Equal(ins[:len(pre)], pre) {
+		return nil, errors.New("not support filter, require start with iphdrLen")
+	}
+
+	return append(
+		[]bpf.Instruction{bpf.LoadConstant{Dst: bpf.RegX, Val: 0}},
+		ins[len(pre):]...,
+	), nil
+}
+
+// Ethernet convert filter to suit packet that start with ethernet header, such
+// as SOCK_RAW AF_PACKET socket recved packet. only support filter that only load
+// packet data, such as FilterEndpoint.
+func Ethernet(ins []bpf.Instruction) []bpf.Instruction {
+	const n = header.EthernetMinimumSize
+
+	var dst = make([]bpf.Instruction, 0, len(ins))
+	for _, e := range ins {
+		switch i := e.(type) {
+		case bpf.LoadAbsolute:
+			i.Off += n
+			e = i
+		case bpf.LoadIndirect:
+			i.Off += n
+			e = i
+		case bpf.LoadMemShift:
+			i.Off += n
+			e = i
+		case bpf.RetConstant:
+			// snap length include ethernet header
+			if i.Val != 0 {
+				i.Val += n
+			}
+			e = i
+		}
+		dst = append(dst, e)
+	}
+	return dst
+}
+
 // iphdrLen store ip header length to reg X
 func iphdrLen() []bpf.Instruction {
-	return []bpf.Instruction{
+	var v6 = ipv6HdrLen()
+	return append([]bpf.Instruction{
 		// load ip version to A
 		bpf.LoadAbsolute{Off: 0, Size: 1},
 		bpf.ALUOpConstant{Op: bpf.ALUOpShiftRight, Val: 4},
 
 		// ipv4
-		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: 4, SkipTrue: 1},
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: 4, SkipTrue: 2},
 		bpf.LoadMemShift{Off: 0},
+		bpf.Jump{Skip: uint32(1 + len(v6))},
 
 		// ipv6
-		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: 6, SkipTrue: 1},
-		bpf.LoadConstant{Dst: bpf.RegX, Val: 40},
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: 6, SkipTrue: uint8(len(v6))},
+	}, v6...)
+}
+
+// ipv6ExtHdrs max count of ipv6 extension headers that walked by bpf filter
+const ipv6ExtHdrs = 4
+
+// ipv6HdrLen store ipv6 header length include extension headers to reg X, and
+// upper layer protocol to A. bpf only support forward jump, so the walk is
+// unrolled, packet with more than ipv6ExtHdrs extension headers or fragmented
+// will be dropped. use M[0] as scratch.
+func ipv6HdrLen() []bpf.Instruction {
+	const (
+		hopByHop = uint32(header.IPv6HopByHopOptionsExtHdrIdentifier)
+		routing  = uint32(header.IPv6RoutingExtHdrIdentifier)
+		dstOpts  = uint32(header.IPv6DestinationOptionsExtHdrIdentifier)
+		auth     = 51 // authentication header, length in 4-octet units
+		fragment = uint32(header.IPv6FragmentExtHdrIdentifier)
+	)
+
+	// A is next header, X is offset of it
+	var walk = []bpf.Instruction{
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: hopByHop, SkipTrue: 5},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: routing, SkipTrue: 4},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: dstOpts, SkipTrue: 3},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: auth, SkipTrue: 8},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: fragment, SkipTrue: 13},
+		bpf.Jump{}, // upper layer header, jump to end
+
+		// (len + 1) * 8
+		bpf.LoadIndirect{Off: 0, Size: 1},
+		bpf.StoreScratch{Src: bpf.RegA, N: 0},
+		bpf.LoadIndirect{Off: 1, Size: 1},
+		bpf.ALUOpConstant{Op: bpf.ALUOpAdd, Val: 1},
+		bpf.ALUOpConstant{Op: bpf.ALUOpShiftLeft, Val: 3},
+		bpf.Jump{Skip: 13},
+
+		// authentication header (len + 2) * 4
+		bpf.LoadIndirect{Off: 0, Size: 1},
+		bpf.StoreScratch{Src: bpf.RegA, N: 0},
+		bpf.LoadIndirect{Off: 1, Size: 1},
+		bpf.ALUOpConstant{Op: bpf.ALUOpAdd, Val: 2},
+		bpf.ALUOpConstant{Op: bpf.ALUOpShiftLeft, Val: 2},
+		bpf.Jump{Skip: 7},
+
+		// fragment header, only atomic fragment
+		bpf.LoadIndirect{Off: 2, Size: 2},
+		bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: 0xfff9},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0, SkipTrue: 1},
+		bpf.RetConstant{Val: 0},
+		bpf.LoadIndirect{Off: 0, Size: 1},
+		bpf.StoreScratch{Src: bpf.RegA, N: 0},
+		bpf.LoadConstant{Dst: bpf.RegA, Val: header.IPv6FragmentExtHdrLength},
+
+		// X += extension header length, A = next header
+		bpf.ALUOpX{Op: bpf.ALUOpAdd},
+		bpf.TAX{},
+		bpf.LoadScratch{Dst: bpf.RegA, N: 0},
+	}
+	// too many extension headers
+	var tail = []bpf.Instruction{
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: hopByHop, SkipTrue: 5},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: routing, SkipTrue: 4},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: dstOpts, SkipTrue: 3},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: auth, SkipTrue: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: fragment, SkipTrue: 1},
+		bpf.Jump{Skip: 1},
+		bpf.RetConstant{Val: 0},
+	}
+
+	var ins = []bpf.Instruction{
+		bpf.LoadConstant{Dst: bpf.RegX, Val: header.IPv6FixedHeaderSize},
+		bpf.LoadAbsolute{Off: header.IPv6NextHeaderOffset, Size: 1},
+	}
+	for i := 0; i < ipv6ExtHdrs; i++ {
+		var blk = slices.Clone(walk)
+		blk[5] = bpf.Jump{Skip: uint32((ipv6ExtHdrs-i)*len(walk) - 6 + len(tail))}
+		ins = append(ins, blk...)
 	}
+	return append(ins, tail...)
 }
@@ -12,7 +12,7 @@ import (
 
 func Test_FilterDstPortAndSynFlag(t *testing.T) {
 	var dstPort = 8080
-	var ins = FilterDstPortAndTCPSyn(uint16(dstPort))
+	var ins = FilterDstPortAndTCPSyn(uint16(dstPort), 0xffff)
 
 	vm, err := bpf.NewVM(ins)
 	require.NoError(t, err)
@@ -75,6 +75,7 @@ func Test_iphdrLen(t *testing.T) {
 			})
 			return b[:b.NextHeader()]
 		}(),
+		ipv6Ext(header.TCPProtocolNumber, 0, nil)[:header.IPv6FixedHeaderSize+24],
 	}
 
 	var ins = iphdrLen()
@@ -208,6 +209,28 @@ func Test_FilterEndpoint(t *testing.T) {
 		},
 	}
 
+	for _, frag := range []uint16{0, 1, 8} {
+		var ip = ipv6Ext(header.TCPProtocolNumber, frag, &header.TCPFields{SrcPort: 19986, DstPort: 8080})
+		var ret = 0xffff
+		if frag != 0 {
+			ret = 0 // fragmented
+		}
+		suits = append(suits, struct {
+			name     string
+			src, dst netip.AddrPort
+			proto    tcpip.TransportProtocolNumber
+			ret      int
+			ip       []byte
+		}{
+			name:  "ipv6_ext-tcp",
+			src:   netip.MustParseAddrPort("[::1]:19986"),
+			dst:   netip.MustParseAddrPort("[::2]:8080"),
+			proto: header.TCPProtocolNumber,
+			ret:   ret,
+			ip:    ip,
+		})
+	}
+
 	for _, e := range suits {
 		ins := FilterEndpoint(e.proto, e.src, e.dst)
 		vm, err := bpf.NewVM(ins)
@@ -216,6 +239,262 @@ func Test_FilterEndpoint(t *testing.T) {
 		n, err := vm.Run(e.ip)
 		require.NoError(t, err)
 		require.Equal(t, e.ret, n)
+
+		// packet start with ethernet header
+		vm, err = bpf.NewVM(Ethernet(ins))
+		require.NoError(t, err)
+		n, err = vm.Run(append(make([]byte, header.EthernetMinimumSize), e.ip...))
+		require.NoError(t, err)
+		if e.ret != 0 {
+			require.Equal(t, e.ret+header.EthernetMinimumSize, n, e.name)
+		} else {
+			require.Zero(t, n, e.name)
+		}
 	}
 
 }
+
+// ipv6Ext build ipv6 packet from ::1 to ::2, with hop-by-hop options, destination
+// options and fragment extension headers
+func ipv6Ext(proto tcpip.TransportProtocolNumber, frag uint16, tcp *header.TCPFields) []byte {
+	var exts = []byte{
+		// hop-by-hop options, PadN
+		uint8(header.IPv6DestinationOptionsExtHdrIdentifier), 0, 1, 4, 0, 0, 0, 0,
+		// destination options, PadN
+		uint8(header.IPv6FragmentExtHdrIdentifier), 0, 1, 4, 0, 0, 0, 0,
+		// fragment
+		uint8(proto), 0, uint8(frag >> 8), uint8(frag), 0, 0, 0, 1,
+	}
+
+	var b = make(header.IPv6, header.IPv6FixedHeaderSize+len(exts)+header.TCPMinimumSize)
+	b.Encode(&header.IPv6Fields{
+		PayloadLength:     uint16(len(b) - header.IPv6FixedHeaderSize),
+		TransportProtocol: tcpip.TransportProtocolNumber(header.IPv6HopByHopOptionsExtHdrIdentifier),
+		SrcAddr:           tcpip.AddrFrom16([16]byte{15: 1}),
+		DstAddr:           tcpip.AddrFrom16([16]byte{15: 2}),
+	})
+	copy(b[header.IPv6FixedHeaderSize:], exts)
+	if tcp != nil {
+		header.TCP(b[header.IPv6FixedHeaderSize+len(exts):]).Encode(tcp)
+	}
+	return b
+}
+
+func Test_FilterICMP(t *testing.T) {
+	var icmp = func(typ header.ICMPv4Type, ident uint16) []byte {
+		var b = make(header.IPv4, header.IPv4MinimumSize+header.ICMPv4MinimumSize)
+		b.Encode(&header.IPv4Fields{
+			TotalLength: uint16(len(b)),
+			Protocol:    uint8(header.ICMPv4ProtocolNumber),
+			SrcAddr:     tcpip.AddrFromSlice([]byte{1, 2, 3, 4}),
+			DstAddr:     tcpip.AddrFromSlice([]byte{64, 255, 232, 1}),
+		})
+		hdr := header.ICMPv4(b.Payload())
+		hdr.SetType(typ)
+		hdr.SetIdent(ident)
+		return b
+	}
+
+	var suits = []struct {
+		name string
+		ins  []bpf.Instruction
+		ret  int
+		ip   []byte
+	}{
+		{
+			name: "request-any",
+			ins:  FilterICMPEchoRequest(0, 0xffff),
+			ret:  0xffff,
+			ip:   icmp(header.ICMPv4Echo, 1234),
+		},
+		{
+			name: "request-ident",
+			ins:  FilterICMPEchoRequest(1234, 0xffff),
+			ret:  0xffff,
+			ip:   icmp(header.ICMPv4Echo, 1234),
+		},
+		{
+			name: "request-snap",
+			ins:  FilterICMPEchoRequest(1234, SnapICMP),
+			ret:  SnapICMP,
+			ip:   icmp(header.ICMPv4Echo, 1234),
+		},
+		{
+			name: "request-ident-miss",
+			ins:  FilterICMPEchoRequest(1234, 0xffff),
+			ret:  0,
+			ip:   icmp(header.ICMPv4Echo, 4321),
+		},
+		{
+			name: "request-reply-miss",
+			ins:  FilterICMPEchoRequest(0, 0xffff),
+			ret:  0,
+			ip:   icmp(header.ICMPv4EchoReply, 1234),
+		},
+		{
+			name: "ident-request",
+			ins:  FilterICMPIdent(1234),
+			ret:  0xffff,
+			ip:   icmp(header.ICMPv4Echo, 1234),
+		},
+		{
+			name: "ident-reply",
+			ins:  FilterICMPIdent(1234),
+			ret:  0xffff,
+			ip:   icmp(header.ICMPv4EchoReply, 1234),
+		},
+		{
+			name: "ident-miss",
+			ins:  FilterICMPIdent(1234),
+			ret:  0,
+			ip:   icmp(header.ICMPv4EchoReply, 4321),
+		},
+		{
+			name: "ident-unreachable-miss",
+			ins:  FilterICMPIdent(1234),
+			ret:  0,
+			ip:   icmp(header.ICMPv4DstUnreachable, 1234),
+		},
+	}
+
+	for _, e := range suits {
+		vm, err := bpf.NewVM(e.ins)
+		require.NoError(t, err, e.name)
+
+		n, err := vm.Run(e.ip)
+		require.NoError(t, err, e.name)
+		require.Equal(t, e.ret, n, e.name)
+	}
+}
+
+func Test_Transport(t *testing.T) {
+	var transport = func(ins []bpf.Instruction) []bpf.Instruction {
+		ins, err := Transport(ins)
+		require.NoError(t, err)
+		return ins
+	}
+	var tcp = func(src, dst uint16, flags header.TCPFlags) []byte {
+		var b = make(header.TCP, header.TCPMinimumSize)
+		b.Encode(&header.TCPFields{
+			SrcPort:    src,
+			DstPort:    dst,
+			DataOffset: header.TCPMinimumSize,
+			Flags:      flags,
+		})
+		return b
+	}
+
+	var suits = []struct {
+		name string
+		ins  []bpf.Instruction
+		ret  int
+		tcp  []byte
+	}{
+		{
+			name: "ports",
+			ins:  transport(FilterPorts(1234, 8080)),
+			ret:  0xffff,
+			tcp:  tcp(1234, 8080, header.TCPFlagAck),
+		},
+		{
+			name: "ports-mismatch",
+			ins:  transport(FilterPorts(1234, 8080)),
+			ret:  0,
+			tcp:  tcp(8080, 1234, header.TCPFlagAck),
+		},
+		{
+			name: "syn",
+			ins:  transport(FilterDstPortAndTCPSyn(8080, 0xffff)),
+			ret:  0xffff,
+			tcp:  tcp(1234, 8080, header.TCPFlagSyn),
+		},
+		{
+			name: "syn-snap",
+			ins:  transport(FilterDstPortAndTCPSyn(8080, SnapTCP)),
+			ret:  SnapTCP,
+			tcp:  tcp(1234, 8080, header.TCPFlagSyn),
+		},
+		{
+			name: "not-syn",
+			ins:  transport(FilterDstPortAndTCPSyn(8080, 0xffff)),
+			ret:  0,
+			tcp:  tcp(1234, 8080, header.TCPFlagAck),
+		},
+	}
+
+	for _, e := range suits {
+		t.Run(e.name, func(t *testing.T) {
+			vm, err := bpf.NewVM(e.ins)
+			require.NoError(t, err)
+
+			n, err := vm.Run(e.tcp)
+			require.NoError(t, err)
+			require.Equal(t, e.ret, n)
+		})
+	}
+
+	_, err := Transport(FilterEndpoint(header.TCPProtocolNumber, netip.AddrPort{}, netip.AddrPort{}))
+	require.Error(t, err)
+}
+
+func Test_FilterShard(t *testing.T) {
+	const n = 4
+	var ips [][]byte
+	for i := 0; i < 64; i++ {
+		ips = append(ips,
+			buildTCP4(
+				netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 0, byte(i), 1}), uint16(1024+i*7)),
+				netip.MustParseAddrPort("192.168.1.5:8080"), header.TCPFlagSyn, i%2 == 0, "",
+			),
+			buildUDP6(
+				netip.AddrPortFrom(netip.AddrFrom16([16]byte{0: 0xfd, 15: byte(i)}), uint16(1024+i*7)),
+				netip.MustParseAddrPort("[fd00::2]:8080"), "",
+			),
+		)
+	}
+
+	for _, transport := range []bool{false, true} {
+		var hits [n]int
+		for _, ip := range ips {
+			if transport && header.IPVersion(ip) == 6 {
+				ip = ip[header.IPv6MinimumSize:]
+			} else if transport {
+				continue
+			}
+
+			var matched int
+			for i := uint32(0); i < n; i++ {
+				ins, err := FilterShard(FilterDstPort(8080, 0xffff), n, i)
+				require.NoError(t, err)
+				if transport {
+					ins, err = Transport(ins)
+					require.NoError(t, err)
+				}
+				vm, err := bpf.NewVM(ins)
+				require.NoError(t, err)
+
+				ret, err := vm.Run(ip)
+				require.NoError(t, err)
+				if ret == 0xffff {
+					matched++
+					hits[i]++
+				}
+			}
+			require.Equal(t, 1, matched)
+		}
+		for i := range hits {
+			require.Greater(t, hits[i], 0, "transport %v, shard %d", transport, i)
+		}
+	}
+
+	ins, err := FilterShard(FilterDstPort(8080, 0xffff), n, 0)
+	require.NoError(t, err)
+	vm, err := bpf.NewVM(ins)
+	require.NoError(t, err)
+	ret, err := vm.Run(buildUDP6(netip.MustParseAddrPort("[fd00::1]:1234"), netip.MustParseAddrPort("[fd00::2]:80"), ""))
+	require.NoError(t, err)
+	require.Zero(t, ret)
+
+	_, err = FilterShard(FilterDstPort(8080, 0xffff)[:1], n, 0)
+	require.Error(t, err)
+}
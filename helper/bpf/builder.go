@@ -0,0 +1,435 @@
+package bpf
+
+import (
+	"encoding/binary"
+	"math"
+	"net/netip"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/bpf"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// Expr is a predicate of packet that start with ip header, compose it by
+// And/Or/Not, and compile to bpf program by Compile.
+//
+// transport layer predicate assume ipv6 packet without extension header, and
+// packet will be dropped if the predicate load out of packet bounds, even it's
+// in Not.
+type Expr interface {
+	gen(c *compiler, t, f label)
+}
+
+// Compile compile expr to bpf program, return 0xffff if packet matched.
+func Compile(e Expr) ([]bpf.Instruction, error) {
+	return compile(e, LinkIP, 0xffff)
+}
+
+// Link is link layer type of packet recved by socket
+type Link uint8
+
+const (
+	// LinkIP packet start with ip header, such as raw ip socket and AF_PACKET
+	// SOCK_DGRAM socket
+	LinkIP Link = iota
+
+	// LinkEthernet packet start with ethernet header, such as AF_PACKET SOCK_RAW
+	// socket
+	LinkEthernet
+)
+
+func (l Link) hdrLen() uint32 {
+	if l == LinkEthernet {
+		return header.EthernetMinimumSize
+	}
+	return 0
+}
+
+// compile compile expr, matched packet will be truncated to snaplen bytes.
+func compile(e Expr, link Link, snaplen uint32) ([]bpf.Instruction, error) {
+	if e == nil {
+		return nil, errors.New("nil bpf expr")
+	}
+	var c = &compiler{base: link.hdrLen()}
+	var accept, reject = c.label(), c.label()
+
+	if link == LinkEthernet {
+		// ether type
+		ip, next := c.label(), c.label()
+		c.emit(bpf.LoadAbsolute{Off: 12, Size: 2})
+		c.jump(bpf.JumpEqual, uint32(header.IPv4ProtocolNumber), ip, next)
+		c.place(next)
+		c.jump(bpf.JumpEqual, uint32(header.IPv6ProtocolNumber), ip, reject)
+		c.place(ip)
+	}
+
+	// store transport header offset to reg X and M[0]
+	c.emit(
+		bpf.LoadAbsolute{Off: c.base, Size: 1},
+		bpf.ALUOpConstant{Op: bpf.ALUOpShiftRight, Val: 4},
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: 4, SkipTrue: 1},
+		bpf.LoadMemShift{Off: c.base},
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: 6, SkipTrue: 1},
+		bpf.LoadConstant{Dst: bpf.RegX, Val: header.IPv6MinimumSize},
+	)
+	if c.base > 0 {
+		c.emit(
+			bpf.TXA{},
+			bpf.ALUOpConstant{Op: bpf.ALUOpAdd, Val: c.base},
+			bpf.TAX{},
+		)
+	}
+	c.emit(bpf.StoreScratch{Src: bpf.RegX, N: 0})
+
+	e.gen(c, accept, reject)
+	c.place(accept)
+	c.emit(bpf.RetConstant{Val: snaplen})
+	c.place(reject)
+	c.emit(bpf.RetConstant{Val: 0})
+
+	ins := c.assemble()
+	if len(ins) > maxInstructions {
+		return nil, errors.Errorf("bpf program too long, %d instructions", len(ins))
+	}
+	return ins, nil
+}
+
+// BPF_MAXINSNS
+const maxInstructions = 4096
+
+type label int
+
+type compiler struct {
+	base uint32
+
+	nodes  []node
+	labels []int // label position in nodes
+}
+
+type node struct {
+	ins bpf.Instruction
+
+	// jump to label, if ins is nil
+	cond bpf.JumpTest
+	val  uint32
+	t, f label
+	ja   bool // jump always to t
+}
+
+func (c *compiler) emit(ins ...bpf.Instruction) {
+	for _, e := range ins {
+		c.nodes = append(c.nodes, node{ins: e})
+	}
+}
+
+func (c *compiler) label() label {
+	c.labels = append(c.labels, -1)
+	return label(len(c.labels) - 1)
+}
+
+func (c *compiler) place(l label) { c.labels[l] = len(c.nodes) }
+
+func (c *compiler) jump(cond bpf.JumpTest, val uint32, t, f label) {
+	c.nodes = append(c.nodes, node{cond: cond, val: val, t: t, f: f})
+}
+
+func (c *compiler) ja(t label) {
+	c.nodes = append(c.nodes, node{ja: true, t: t})
+}
+
+// assemble resolve label to jump offset, conditional jump offset is limited
+// to 255, insert unconditional jump for the far target.
+func (c *compiler) assemble() []bpf.Instruction {
+	for c.trampoline() {
+	}
+
+	var ins = make([]bpf.Instruction, 0, len(c.nodes))
+	for i, n := range c.nodes {
+		switch {
+		case n.ins != nil:
+			ins = append(ins, n.ins)
+		case n.ja:
+			ins = append(ins, bpf.Jump{Skip: uint32(c.labels[n.t] - i - 1)})
+		default:
+			ins = append(ins, bpf.JumpIf{
+				Cond:      n.cond,
+				Val:       n.val,
+				SkipTrue:  uint8(c.labels[n.t] - i - 1),
+				SkipFalse: uint8(c.labels[n.f] - i - 1),
+			})
+		}
+	}
+	return ins
+}
+
+// trampoline insert unconditional jump after the first conditional jump that
+// target is too far, return false if not found.
+func (c *compiler) trampoline() bool {
+	for i, n := range c.nodes {
+		if n.ins != nil || n.ja {
+			continue
+		}
+		farT := c.labels[n.t]-i-1 > math.MaxUint8
+		farF := c.labels[n.f]-i-1 > math.MaxUint8
+		if !farT && !farF {
+			continue
+		}
+
+		var tramp []node
+		if farT {
+			tramp = append(tramp, node{ja: true, t: n.t})
+		}
+		if farF {
+			tramp = append(tramp, node{ja: true, t: n.f})
+		}
+		for l, pos := range c.labels {
+			if pos > i {
+				c.labels[l] = pos + len(tramp)
+			}
+		}
+		c.nodes = append(c.nodes[:i+1], append(tramp, c.nodes[i+1:]...)...)
+		if farT {
+			c.nodes[i].t = c.label()
+			c.labels[c.nodes[i].t] = i + 1
+		}
+		if farF {
+			c.nodes[i].f = c.label()
+			c.labels[c.nodes[i].f] = i + len(tramp)
+		}
+		return true
+	}
+	return false
+}
+
+type and []Expr
+
+// And match if all exprs matched, empty And always matched
+func And(es ...Expr) Expr { return and(es) }
+
+func (a and) gen(c *compiler, t, f label) {
+	if len(a) == 0 {
+		c.ja(t)
+		return
+	}
+	for _, e := range a[:len(a)-1] {
+		next := c.label()
+		e.gen(c, next, f)
+		c.place(next)
+	}
+	a[len(a)-1].gen(c, t, f)
+}
+
+type or []Expr
+
+// Or match if any expr matched, empty Or never matched
+func Or(es ...Expr) Expr { return or(es) }
+
+func (o or) gen(c *compiler, t, f label) {
+	if len(o) == 0 {
+		c.ja(f)
+		return
+	}
+	for _, e := range o[:len(o)-1] {
+		next := c.label()
+		e.gen(c, t, next)
+		c.place(next)
+	}
+	o[len(o)-1].gen(c, t, f)
+}
+
+type not struct{ Expr }
+
+// Not match if expr not matched
+func Not(e Expr) Expr { return not{e} }
+
+func (n not) gen(c *compiler, t, f label) { n.Expr.gen(c, f, t) }
+
+// cmp compare loaded value with val
+type cmp struct {
+	abs  bool   // load relative to ip header, otherwise relative to transport header
+	off  uint32 // load offset
+	size int    // load size
+	mask uint32 // zero means not mask
+
+	cond bpf.JumpTest
+	val  uint32
+}
+
+func (p cmp) gen(c *compiler, t, f label) {
+	if p.abs {
+		c.emit(bpf.LoadAbsolute{Off: c.base + p.off, Size: p.size})
+	} else {
+		c.emit(bpf.LoadIndirect{Off: p.off, Size: p.size})
+	}
+	if p.mask != 0 {
+		c.emit(bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: p.mask})
+	}
+	c.jump(p.cond, p.val, t, f)
+}
+
+type version uint8
+
+// IPVersion match ip version, 4 or 6
+func IPVersion(v uint8) Expr { return version(v) }
+
+func (v version) gen(c *compiler, t, f label) {
+	c.emit(
+		bpf.LoadAbsolute{Off: c.base, Size: 1},
+		bpf.ALUOpConstant{Op: bpf.ALUOpShiftRight, Val: 4},
+	)
+	c.jump(bpf.JumpEqual, uint32(v), t, f)
+}
+
+// Proto match transport protocol
+func Proto(proto tcpip.TransportProtocolNumber) Expr {
+	return Or(
+		And(IPVersion(4), cmp{abs: true, off: 9, size: 1, cond: bpf.JumpEqual, val: uint32(proto)}),
+		And(IPVersion(6), cmp{abs: true, off: header.IPv6NextHeaderOffset, size: 1, cond: bpf.JumpEqual, val: uint32(proto)}),
+	)
+}
+
+// SrcNet match source address in prefix
+func SrcNet(prefix netip.Prefix) Expr { return netExpr(prefix, true) }
+
+// DstNet match destination address in prefix
+func DstNet(prefix netip.Prefix) Expr { return netExpr(prefix, false) }
+
+// SrcHost match source address
+func SrcHost(addr netip.Addr) Expr { return SrcNet(netip.PrefixFrom(addr, addr.BitLen())) }
+
+// DstHost match destination address
+func DstHost(addr netip.Addr) Expr { return DstNet(netip.PrefixFrom(addr, addr.BitLen())) }
+
+func netExpr(prefix netip.Prefix, src bool) Expr {
+	prefix = prefix.Masked()
+	if !prefix.IsValid() {
+		return Or() // never matched
+	}
+
+	var exprs []Expr
+	var off uint32
+	if prefix.Addr().Is4() {
+		exprs, off = append(exprs, IPVersion(4)), 16
+		if src {
+			off = 12
+		}
+	} else {
+		exprs, off = append(exprs, IPVersion(6)), 24
+		if src {
+			off = 8
+		}
+	}
+
+	addr := prefix.Addr().AsSlice()
+	for i := 0; i < len(addr)/4; i++ {
+		bits := min(max(prefix.Bits()-i*32, 0), 32)
+		if bits == 0 {
+			break
+		}
+		mask := ^uint32(0) << (32 - bits)
+		if bits == 32 {
+			mask = 0
+		}
+		exprs = append(exprs, cmp{
+			abs: true, off: off + uint32(i*4), size: 4, mask: mask,
+			cond: bpf.JumpEqual, val: binary.BigEndian.Uint32(addr[i*4:]),
+		})
+	}
+	return And(exprs...)
+}
+
+// SrcPort match tcp/udp source port
+func SrcPort(port uint16) Expr { return SrcPortRange(port, port) }
+
+// DstPort match tcp/udp destination port
+func DstPort(port uint16) Expr { return DstPortRange(port, port) }
+
+// SrcPortRange match tcp/udp source port in [start, end]
+func SrcPortRange(start, end uint16) Expr { return portRange(0, start, end) }
+
+// DstPortRange match tcp/udp destination port in [start, end]
+func DstPortRange(start, end uint16) Expr { return portRange(2, start, end) }
+
+func portRange(off uint32, start, end uint16) Expr {
+	if start == end {
+		return cmp{off: off, size: 2, cond: bpf.JumpEqual, val: uint32(start)}
+	} else if start > end {
+		return Or()
+	}
+	return And(
+		cmp{off: off, size: 2, cond: bpf.JumpGreaterOrEqual, val: uint32(start)},
+		cmp{off: off, size: 2, cond: bpf.JumpLessOrEqual, val: uint32(end)},
+	)
+}
+
+// TCPFlags match tcp flags, that flags&mask == set
+func TCPFlags(mask, set header.TCPFlags) Expr {
+	return cmp{
+		off: header.TCPFlagsOffset, size: 1, mask: uint32(mask),
+		cond: bpf.JumpEqual, val: uint32(set & mask),
+	}
+}
+
+// Payload match tcp/udp payload bytes at offset
+func Payload(off uint32, val []byte) Expr {
+	return Or(
+		And(Proto(header.TCPProtocolNumber), tcpPayload{off: off, val: val}),
+		And(Proto(header.UDPProtocolNumber), bytesExpr(header.UDPMinimumSize+off, val)),
+	)
+}
+
+// bytesExpr match bytes at offset of transport header
+func bytesExpr(off uint32, val []byte) Expr {
+	var exprs = and{}
+	for len(val) > 0 {
+		var n = 4
+		for n > len(val) {
+			n /= 2
+		}
+		var v uint32
+		for _, b := range val[:n] {
+			v = v<<8 | uint32(b)
+		}
+		exprs = append(exprs, cmp{off: off, size: n, cond: bpf.JumpEqual, val: v})
+		off, val = off+uint32(n), val[n:]
+	}
+	return exprs
+}
+
+type tcpPayload struct {
+	off uint32
+	val []byte
+}
+
+func (p tcpPayload) gen(c *compiler, t, f label) {
+	exprs := bytesExpr(p.off, p.val).(and)
+	if len(exprs) == 0 {
+		c.ja(t)
+		return
+	}
+
+	for i, e := range exprs {
+		e := e.(cmp)
+		c.emit(
+			// X = transport header offset + tcp header length
+			bpf.LoadIndirect{Off: 12, Size: 1},
+			bpf.ALUOpConstant{Op: bpf.ALUOpShiftRight, Val: 2},
+			bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: 0x3c},
+			bpf.ALUOpX{Op: bpf.ALUOpAdd},
+			bpf.TAX{},
+
+			bpf.LoadIndirect{Off: e.off, Size: e.size},
+
+			// restore transport header offset
+			bpf.LoadScratch{Dst: bpf.RegX, N: 0},
+		)
+		if i == len(exprs)-1 {
+			c.jump(e.cond, e.val, t, f)
+		} else {
+			next := c.label()
+			c.jump(e.cond, e.val, next, f)
+			c.place(next)
+		}
+	}
+}
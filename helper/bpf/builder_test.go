@@ -0,0 +1,167 @@
+package bpf
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/bpf"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func buildTCP4(src, dst netip.AddrPort, flags header.TCPFlags, opts bool, payload string) []byte {
+	var fields = &header.IPv4Fields{
+		TTL:      64,
+		Protocol: uint8(header.TCPProtocolNumber),
+		SrcAddr:  tcpip.AddrFrom4(src.Addr().As4()),
+		DstAddr:  tcpip.AddrFrom4(dst.Addr().As4()),
+	}
+	if opts {
+		fields.Options = header.IPv4OptionsSerializer{&header.IPv4SerializableRouterAlertOption{}}
+	}
+	var b = make(header.IPv4, 128)
+	b.Encode(fields)
+	hdrLen := int(b.HeaderLength())
+
+	// tcp header with 12 bytes options
+	tcp := header.TCP(b[hdrLen:])
+	tcp.Encode(&header.TCPFields{
+		SrcPort:    src.Port(),
+		DstPort:    dst.Port(),
+		DataOffset: header.TCPMinimumSize + 12,
+		Flags:      flags,
+	})
+	n := hdrLen + header.TCPMinimumSize + 12 + copy(b[hdrLen+header.TCPMinimumSize+12:], payload)
+	b.SetTotalLength(uint16(n))
+	return b[:n]
+}
+
+func buildUDP6(src, dst netip.AddrPort, payload string) []byte {
+	var b = make(header.IPv6, header.IPv6MinimumSize+header.UDPMinimumSize+len(payload))
+	b.Encode(&header.IPv6Fields{
+		PayloadLength:     uint16(header.UDPMinimumSize + len(payload)),
+		TransportProtocol: header.UDPProtocolNumber,
+		HopLimit:          64,
+		SrcAddr:           tcpip.AddrFrom16(src.Addr().As16()),
+		DstAddr:           tcpip.AddrFrom16(dst.Addr().As16()),
+	})
+	udp := header.UDP(b[header.IPv6MinimumSize:])
+	udp.Encode(&header.UDPFields{
+		SrcPort: src.Port(),
+		DstPort: dst.Port(),
+		Length:  uint16(len(udp)),
+	})
+	copy(udp.Payload(), payload)
+	return b
+}
+
+func Test_Compile(t *testing.T) {
+	var (
+		tcp4 = buildTCP4(
+			netip.MustParseAddrPort("10.1.2.3:1234"), netip.MustParseAddrPort("192.168.1.5:443"),
+			header.TCPFlagSyn, false, "GET /",
+		)
+		tcp4opt = buildTCP4(
+			netip.MustParseAddrPort("10.1.2.3:1234"), netip.MustParseAddrPort("192.168.1.5:443"),
+			header.TCPFlagSyn|header.TCPFlagAck, true, "GET /",
+		)
+		udp6 = buildUDP6(
+			netip.MustParseAddrPort("[fd00::1:1]:53"), netip.MustParseAddrPort("[fd00::2]:5353"),
+			"hello",
+		)
+	)
+
+	var suits = []struct {
+		name string
+		expr Expr
+		// expect matched of tcp4, tcp4opt, udp6
+		match [3]bool
+	}{
+		{"version4", IPVersion(4), [3]bool{true, true, false}},
+		{"version6", IPVersion(6), [3]bool{false, false, true}},
+		{"tcp", Proto(header.TCPProtocolNumber), [3]bool{true, true, false}},
+		{"udp", Proto(header.UDPProtocolNumber), [3]bool{false, false, true}},
+		{"src-net", SrcNet(netip.MustParsePrefix("10.0.0.0/8")), [3]bool{true, true, false}},
+		{"src-net-miss", SrcNet(netip.MustParsePrefix("10.1.3.0/24")), [3]bool{false, false, false}},
+		{"src-net-any", SrcNet(netip.MustParsePrefix("0.0.0.0/0")), [3]bool{true, true, false}},
+		{"dst-host", DstHost(netip.MustParseAddr("192.168.1.5")), [3]bool{true, true, false}},
+		{"src-net6", SrcNet(netip.MustParsePrefix("fd00::1:0/112")), [3]bool{false, false, true}},
+		{"src-net6-miss", SrcNet(netip.MustParsePrefix("fd00::2:0/112")), [3]bool{false, false, false}},
+		{"dst-net6", DstNet(netip.MustParsePrefix("fd00::/8")), [3]bool{false, false, true}},
+		{"dst-host6", DstHost(netip.MustParseAddr("fd00::2")), [3]bool{false, false, true}},
+		{"dst-port", DstPort(443), [3]bool{true, true, false}},
+		{"src-port", SrcPort(53), [3]bool{false, false, true}},
+		{"dst-port-range", DstPortRange(400, 5353), [3]bool{true, true, true}},
+		{"dst-port-range-miss", DstPortRange(444, 5352), [3]bool{false, false, false}},
+		{"src-port-range", SrcPortRange(1000, 2000), [3]bool{true, true, false}},
+		{"syn", TCPFlags(header.TCPFlagSyn, header.TCPFlagSyn), [3]bool{true, true, false}},
+		{"syn-only", TCPFlags(header.TCPFlagSyn|header.TCPFlagAck, header.TCPFlagSyn), [3]bool{true, false, false}},
+		{"payload", Payload(0, []byte("GET ")), [3]bool{true, true, false}},
+		{"payload-off", Payload(1, []byte("ello")), [3]bool{false, false, true}},
+		{"payload-odd", Payload(2, []byte("T /")), [3]bool{true, true, false}},
+		{"payload-miss", Payload(0, []byte("POST")), [3]bool{false, false, false}},
+		{
+			"and",
+			And(Proto(header.TCPProtocolNumber), SrcNet(netip.MustParsePrefix("10.0.0.0/8")), DstPort(443)),
+			[3]bool{true, true, false},
+		},
+		{
+			"or",
+			Or(DstPort(80), DstHost(netip.MustParseAddr("fd00::2"))),
+			[3]bool{false, false, true},
+		},
+		{
+			"not",
+			And(Proto(header.TCPProtocolNumber), Not(TCPFlags(header.TCPFlagAck, header.TCPFlagAck))),
+			[3]bool{true, false, false},
+		},
+		{"empty-and", And(), [3]bool{true, true, true}},
+		{"empty-or", Or(), [3]bool{false, false, false}},
+		{"not-empty-or", Not(Or()), [3]bool{true, true, true}},
+	}
+
+	for _, e := range suits {
+		ins, err := Compile(e.expr)
+		require.NoError(t, err, e.name)
+		vm, err := bpf.NewVM(ins)
+		require.NoError(t, err, e.name)
+
+		for i, ip := range [][]byte{tcp4, tcp4opt, udp6} {
+			n, err := vm.Run(ip)
+			require.NoError(t, err)
+			require.Equal(t, e.match[i], n == 0xffff, "%s %d", e.name, i)
+		}
+	}
+}
+
+func Test_Compile_FarJump(t *testing.T) {
+	var (
+		ip    = buildTCP4(netip.MustParseAddrPort("10.1.2.3:1234"), netip.MustParseAddrPort("192.168.1.5:443"), 0, false, "")
+		ports []Expr
+	)
+	for i := 0; i < 300; i++ {
+		ports = append(ports, DstPort(uint16(10000+i)))
+	}
+
+	for _, e := range []struct {
+		expr  Expr
+		match bool
+	}{
+		{Or(ports...), false},
+		{Or(append(ports, DstPort(443))...), true},
+		{And(Not(Or(ports...)), SrcPort(1234)), true},
+		{And(Or(ports...), SrcPort(1234)), false},
+		{Or(And(Not(Or(ports...)), SrcPort(1)), SrcPort(1234)), true},
+	} {
+		ins, err := Compile(e.expr)
+		require.NoError(t, err)
+		require.Greater(t, len(ins), 0xff)
+
+		vm, err := bpf.NewVM(ins)
+		require.NoError(t, err)
+		n, err := vm.Run(ip)
+		require.NoError(t, err)
+		require.Equal(t, e.match, n == 0xffff)
+	}
+}
@@ -0,0 +1,403 @@
+package bpf
+
+import (
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/bpf"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// CompileFilter compile tcpdump-style filter expression to bpf program, return
+// 0xffff if packet matched. see Parse for supported syntax.
+func CompileFilter(filter string, link Link) ([]bpf.Instruction, error) {
+	e, err := Parse(filter)
+	if err != nil {
+		return nil, err
+	}
+	return compile(e, link, 0xffff)
+}
+
+// Parse parse a subset of pcap-filter syntax to Expr, empty filter match all
+// ip packets. support:
+//
+//	ip, ip6, tcp, udp, icmp, icmp6
+//	[ip|ip6] [src|dst|src or dst|src and dst] host ADDR
+//	[ip|ip6] [src|dst|src or dst|src and dst] net PREFIX
+//	[tcp|udp] [src|dst|src or dst|src and dst] port PORT
+//	[tcp|udp] [src|dst|src or dst|src and dst] portrange START-END
+//	[ip|ip6] proto tcp|udp|icmp|icmp6|NUM
+//	tcp[tcpflags] & FLAGS =|==|!= FLAGS, FLAGS such as (tcp-syn|tcp-ack) or number
+//	and, &&, or, ||, not, !, ( )
+//
+// same as pcap-filter, `and` and `or` have same precedence and left associative,
+// omitted qualifiers reuse previous primitive's, e.g. `port 80 or 443`.
+func Parse(filter string) (Expr, error) {
+	toks := lex(filter)
+	if len(toks) == 0 {
+		return And(), nil
+	}
+
+	var p = &parser{toks: toks}
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	} else if !p.eof() {
+		return nil, p.errorf("unexpect %q", p.peek())
+	}
+	return e, nil
+}
+
+func lex(s string) (toks []string) {
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(s[i:], "&&") || strings.HasPrefix(s[i:], "||") ||
+			strings.HasPrefix(s[i:], "==") || strings.HasPrefix(s[i:], "!="):
+			toks = append(toks, s[i:i+2])
+			i += 2
+		case strings.IndexByte("()[]&|=!", c) >= 0:
+			toks = append(toks, s[i:i+1])
+			i++
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\n\r()[]&|=!", rune(s[j])) {
+				j++
+			}
+			toks = append(toks, s[i:j])
+			i = j
+		}
+	}
+	return toks
+}
+
+type parser struct {
+	toks []string
+	idx  int
+
+	// qualifiers of previous primitive
+	proto, dir, typ string
+}
+
+func (p *parser) eof() bool { return p.idx >= len(p.toks) }
+
+func (p *parser) peek() string {
+	if p.eof() {
+		return ""
+	}
+	return p.toks[p.idx]
+}
+
+func (p *parser) peekN(n int) string {
+	if p.idx+n >= len(p.toks) {
+		return ""
+	}
+	return p.toks[p.idx+n]
+}
+
+func (p *parser) next() string {
+	tok := p.peek()
+	p.idx++
+	return tok
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return errors.Errorf("bpf filter: "+format, args...)
+}
+
+func (p *parser) expect(tok string) error {
+	if got := p.next(); got != tok {
+		return p.errorf("expect %q, got %q", tok, got)
+	}
+	return nil
+}
+
+// expr := unary { (and|or) unary }
+func (p *parser) expr() (Expr, error) {
+	e, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek() {
+		case "and", "&&":
+			p.next()
+			r, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			e = And(e, r)
+		case "or", "||":
+			p.next()
+			r, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			e = Or(e, r)
+		default:
+			return e, nil
+		}
+	}
+}
+
+// unary := (not|!) unary | ( expr ) | primitive
+func (p *parser) unary() (Expr, error) {
+	switch p.peek() {
+	case "not", "!":
+		p.next()
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return Not(e), nil
+	case "(":
+		p.next()
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	case "":
+		return nil, p.errorf("unexpect end of filter")
+	default:
+		return p.primitive()
+	}
+}
+
+func isProto(tok string) bool {
+	switch tok {
+	case "ip", "ip6", "tcp", "udp", "icmp", "icmp6":
+		return true
+	}
+	return false
+}
+
+func isDir(tok string) bool {
+	return tok == "src" || tok == "dst"
+}
+
+func isType(tok string) bool {
+	switch tok {
+	case "host", "net", "port", "portrange", "proto":
+		return true
+	}
+	return false
+}
+
+// primitive := [proto] [dir] [type] value | proto | tcp[tcpflags] & FLAGS op FLAGS
+func (p *parser) primitive() (Expr, error) {
+	var proto, dir, typ string
+
+	if isProto(p.peek()) {
+		proto = p.next()
+		if proto == "tcp" && p.peek() == "[" {
+			return p.tcpflags()
+		}
+	}
+	if isDir(p.peek()) {
+		dir = p.next()
+		if op := p.peek(); (op == "or" || op == "and") && isDir(p.peekN(1)) && p.peekN(1) != dir {
+			dir = dir + " " + p.next() + " " + p.next()
+		}
+	}
+	if isType(p.peek()) {
+		typ = p.next()
+	}
+
+	if proto != "" && dir == "" && typ == "" {
+		p.proto, p.dir, p.typ = proto, "", ""
+		return protoExpr(proto), nil
+	} else if proto == "" && dir == "" && typ == "" {
+		// omitted qualifiers reuse previous, default as host
+		if proto, dir, typ = p.proto, p.dir, p.typ; typ == "" {
+			typ = "host"
+		}
+	} else if typ == "" {
+		typ = "host" // default type
+	}
+	p.proto, p.dir, p.typ = proto, dir, typ
+
+	val := p.next()
+	if val == "" {
+		return nil, p.errorf("%s require value", typ)
+	}
+
+	var e Expr
+	switch typ {
+	case "host", "net":
+		var prefix netip.Prefix
+		if addr, err := netip.ParseAddr(val); err == nil {
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		} else if prefix, err = netip.ParsePrefix(val); err != nil {
+			return nil, p.errorf("invalid %s %q", typ, val)
+		}
+		if (proto == "ip" && !prefix.Addr().Is4()) || (proto == "ip6" && !prefix.Addr().Is6()) {
+			return nil, p.errorf("%s not match %s", val, proto)
+		}
+		e = withDir(dir, SrcNet(prefix), DstNet(prefix))
+		if proto == "ip" || proto == "ip6" {
+			return e, nil
+		}
+	case "port", "portrange":
+		var start, end uint16
+		var err error
+		if typ == "port" {
+			start, err = parsePort(val)
+			end = start
+		} else if s, e, ok := strings.Cut(val, "-"); !ok {
+			err = errors.New("require START-END")
+		} else if start, err = parsePort(s); err == nil {
+			end, err = parsePort(e)
+		}
+		if err != nil {
+			return nil, p.errorf("invalid %s %q: %s", typ, val, err)
+		}
+		e = withDir(dir, SrcPortRange(start, end), DstPortRange(start, end))
+		if proto == "tcp" || proto == "udp" {
+			return And(protoExpr(proto), e), nil
+		}
+		e = And(Or(Proto(header.TCPProtocolNumber), Proto(header.UDPProtocolNumber)), e)
+	case "proto":
+		if dir != "" {
+			return nil, p.errorf("proto not support direction %q", dir)
+		}
+		val = strings.TrimPrefix(val, "\\")
+		if isProto(val) && val != "ip" && val != "ip6" {
+			e = protoExpr(val)
+		} else if n, err := strconv.ParseUint(val, 0, 8); err == nil {
+			e = Proto(tcpip.TransportProtocolNumber(n))
+		} else {
+			return nil, p.errorf("invalid proto %q", val)
+		}
+	}
+
+	if proto != "" {
+		e = And(protoExpr(proto), e)
+	}
+	return e, nil
+}
+
+func withDir(dir string, src, dst Expr) Expr {
+	switch dir {
+	case "src":
+		return src
+	case "dst":
+		return dst
+	case "src and dst", "dst and src":
+		return And(src, dst)
+	default:
+		return Or(src, dst)
+	}
+}
+
+func protoExpr(proto string) Expr {
+	switch proto {
+	case "ip":
+		return IPVersion(4)
+	case "ip6":
+		return IPVersion(6)
+	case "tcp":
+		return Proto(header.TCPProtocolNumber)
+	case "udp":
+		return Proto(header.UDPProtocolNumber)
+	case "icmp":
+		return And(IPVersion(4), Proto(header.ICMPv4ProtocolNumber))
+	case "icmp6":
+		return And(IPVersion(6), Proto(header.ICMPv6ProtocolNumber))
+	default:
+		panic(proto)
+	}
+}
+
+func parsePort(s string) (uint16, error) {
+	n, err := strconv.ParseUint(s, 10, 16)
+	return uint16(n), err
+}
+
+// tcpflags := [ tcpflags|13 ] & FLAGS op FLAGS
+func (p *parser) tcpflags() (Expr, error) {
+	p.next() // [
+	if idx := p.next(); idx != "tcpflags" && idx != "13" {
+		return nil, p.errorf("only support tcp[tcpflags], got tcp[%s]", idx)
+	}
+	if err := p.expect("]"); err != nil {
+		return nil, err
+	}
+	if err := p.expect("&"); err != nil {
+		return nil, err
+	}
+	mask, err := p.flags()
+	if err != nil {
+		return nil, err
+	}
+	op := p.next()
+	val, err := p.flags()
+	if err != nil {
+		return nil, err
+	}
+	p.proto, p.dir, p.typ = "", "", ""
+
+	var e Expr
+	if val&^mask != 0 {
+		e = Or() // never matched
+	} else {
+		e = TCPFlags(mask, val)
+	}
+	switch op {
+	case "=", "==":
+	case "!=":
+		e = Not(e)
+	default:
+		return nil, p.errorf("unsupported operator %q", op)
+	}
+	return And(Proto(header.TCPProtocolNumber), e), nil
+}
+
+// flags := term { | term }, term := ( flags ) | tcp-xxx | NUM
+func (p *parser) flags() (header.TCPFlags, error) {
+	var f header.TCPFlags
+	for {
+		switch tok := p.next(); tok {
+		case "(":
+			v, err := p.flags()
+			if err != nil {
+				return 0, err
+			}
+			if err := p.expect(")"); err != nil {
+				return 0, err
+			}
+			f |= v
+		case "tcp-fin":
+			f |= header.TCPFlagFin
+		case "tcp-syn":
+			f |= header.TCPFlagSyn
+		case "tcp-rst":
+			f |= header.TCPFlagRst
+		case "tcp-push":
+			f |= header.TCPFlagPsh
+		case "tcp-ack":
+			f |= header.TCPFlagAck
+		case "tcp-urg":
+			f |= header.TCPFlagUrg
+		case "tcp-ece":
+			f |= header.TCPFlagEce
+		case "tcp-cwr":
+			f |= header.TCPFlagCwr
+		default:
+			n, err := strconv.ParseUint(tok, 0, 8)
+			if err != nil {
+				return 0, p.errorf("invalid tcp flags %q", tok)
+			}
+			f |= header.TCPFlags(n)
+		}
+
+		if p.peek() != "|" {
+			return f, nil
+		}
+		p.next()
+	}
+}
@@ -0,0 +1,130 @@
+package bpf
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/bpf"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func Test_CompileFilter(t *testing.T) {
+	var (
+		tcp4 = buildTCP4(
+			netip.MustParseAddrPort("10.1.2.3:1234"), netip.MustParseAddrPort("192.168.1.5:443"),
+			header.TCPFlagSyn, false, "GET /",
+		)
+		tcp4opt = buildTCP4(
+			netip.MustParseAddrPort("10.1.2.3:1234"), netip.MustParseAddrPort("192.168.1.5:443"),
+			header.TCPFlagSyn|header.TCPFlagAck, true, "GET /",
+		)
+		udp6 = buildUDP6(
+			netip.MustParseAddrPort("[fd00::1:1]:53"), netip.MustParseAddrPort("[fd00::2]:5353"),
+			"hello",
+		)
+	)
+
+	var suits = []struct {
+		filter string
+		// expect matched of tcp4, tcp4opt, udp6
+		match [3]bool
+	}{
+		{"", [3]bool{true, true, true}},
+		{"ip", [3]bool{true, true, false}},
+		{"ip6", [3]bool{false, false, true}},
+		{"tcp", [3]bool{true, true, false}},
+		{"udp", [3]bool{false, false, true}},
+		{"icmp or icmp6", [3]bool{false, false, false}},
+		{"tcp and src net 10.0.0.0/8 and dst port 443", [3]bool{true, true, false}},
+		{"tcp && src net 10.1.3.0/24", [3]bool{false, false, false}},
+		{"host 192.168.1.5", [3]bool{true, true, false}},
+		{"src host 192.168.1.5", [3]bool{false, false, false}},
+		{"src or dst host 10.1.2.3", [3]bool{true, true, false}},
+		{"src and dst net 0.0.0.0/0", [3]bool{true, true, false}},
+		{"ip6 dst net fd00::/8", [3]bool{false, false, true}},
+		{"fd00::2", [3]bool{false, false, true}},
+		{"port 53", [3]bool{false, false, true}},
+		{"udp port 443", [3]bool{false, false, false}},
+		{"dst port 80 or 443", [3]bool{true, true, false}},
+		{"dst port 80 or 5353", [3]bool{false, false, true}},
+		{"tcp portrange 1000-2000", [3]bool{true, true, false}},
+		{"proto udp", [3]bool{false, false, true}},
+		{"ip proto \\6", [3]bool{true, true, false}},
+		{"tcp[tcpflags] & tcp-syn != 0", [3]bool{true, true, false}},
+		{"tcp[tcpflags] & (tcp-syn|tcp-ack) == tcp-syn", [3]bool{true, false, false}},
+		{"tcp[13] & tcp-ack = 0", [3]bool{true, false, false}},
+		{"not tcp", [3]bool{false, false, true}},
+		{"! (udp || dst port 443)", [3]bool{false, false, false}},
+		{"not tcp[tcpflags] & tcp-ack != 0 and tcp", [3]bool{true, false, false}},
+		{"udp or tcp and port 53", [3]bool{false, false, true}},
+	}
+
+	for _, e := range suits {
+		ins, err := CompileFilter(e.filter, LinkIP)
+		require.NoError(t, err, e.filter)
+		vm, err := bpf.NewVM(ins)
+		require.NoError(t, err, e.filter)
+
+		for i, ip := range [][]byte{tcp4, tcp4opt, udp6} {
+			n, err := vm.Run(ip)
+			require.NoError(t, err)
+			require.Equal(t, e.match[i], n == 0xffff, "%q %d", e.filter, i)
+		}
+	}
+}
+
+func Test_CompileFilter_Ethernet(t *testing.T) {
+	var eth = func(typ uint16, ip []byte) []byte {
+		var b = make([]byte, header.EthernetMinimumSize, header.EthernetMinimumSize+len(ip))
+		binary.BigEndian.PutUint16(b[12:], typ)
+		return append(b, ip...)
+	}
+	var (
+		tcp4 = buildTCP4(netip.MustParseAddrPort("10.1.2.3:1234"), netip.MustParseAddrPort("192.168.1.5:443"), 0, true, "")
+		udp6 = buildUDP6(netip.MustParseAddrPort("[fd00::1:1]:53"), netip.MustParseAddrPort("[fd00::2]:5353"), "")
+	)
+
+	for _, e := range []struct {
+		filter string
+		frame  []byte
+		match  bool
+	}{
+		{"tcp and src net 10.0.0.0/8 and dst port 443", eth(uint16(header.IPv4ProtocolNumber), tcp4), true},
+		{"tcp and dst port 1234", eth(uint16(header.IPv4ProtocolNumber), tcp4), false},
+		{"udp src port 53", eth(uint16(header.IPv6ProtocolNumber), udp6), true},
+		{"", eth(uint16(header.IPv6ProtocolNumber), udp6), true},
+		{"", eth(uint16(header.ARPProtocolNumber), tcp4), false},
+	} {
+		ins, err := CompileFilter(e.filter, LinkEthernet)
+		require.NoError(t, err)
+		vm, err := bpf.NewVM(ins)
+		require.NoError(t, err)
+		n, err := vm.Run(e.frame)
+		require.NoError(t, err)
+		require.Equal(t, e.match, n == 0xffff, e.filter)
+	}
+}
+
+func Test_Parse_Error(t *testing.T) {
+	for _, filter := range []string{
+		"tcp and",
+		"(tcp",
+		"tcp)",
+		"foo",
+		"host",
+		"host 1.2.3",
+		"ip host fd00::1",
+		"port 65536",
+		"portrange 10",
+		"src proto 6",
+		"proto abc",
+		"tcp[14] & tcp-syn != 0",
+		"tcp[tcpflags] & tcp-foo != 0",
+		"tcp[tcpflags] & tcp-syn > 0",
+	} {
+		_, err := Parse(filter)
+		require.Error(t, err, filter)
+	}
+}
@@ -0,0 +1,76 @@
+package helper
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// CtxReader serialize reads of a shared conn (such as Listener's raw socket)
+// in a single goroutine, every ReadCtx wait for recved packet with it's own
+// ctx, so needn't change the shared read deadline to interrupt blocked read.
+type CtxReader struct {
+	read func(b []byte) (int, error)
+	size int
+
+	pkts   chan []byte
+	done   chan struct{} // closed when read failed
+	err    error
+	closed chan struct{}
+	once   sync.Once
+}
+
+// NewCtxReader start read goroutine, size is max bytes of once read, the
+// CtxReader should be closed before close the conn.
+func NewCtxReader(read func(b []byte) (int, error), size int) *CtxReader {
+	var r = &CtxReader{
+		read:   read,
+		size:   size,
+		pkts:   make(chan []byte),
+		done:   make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+	go r.readService()
+	return r
+}
+
+func (r *CtxReader) readService() {
+	var b = make([]byte, r.size)
+	for {
+		n, err := r.read(b)
+		if err != nil {
+			r.err = errors.WithStack(err)
+			close(r.done)
+			return
+		}
+
+		select {
+		case r.pkts <- append(make([]byte, 0, n), b[:n]...):
+		case <-r.closed:
+			return
+		}
+	}
+}
+
+// ReadCtx read a packet, return ctx's error if ctx done before recved.
+func (r *CtxReader) ReadCtx(ctx context.Context) ([]byte, error) {
+	select {
+	case b := <-r.pkts:
+		return b, nil
+	case <-r.done:
+		return nil, r.err
+	case <-r.closed:
+		return nil, errors.WithStack(net.ErrClosed)
+	case <-ctx.Done():
+		return nil, errors.WithStack(ctx.Err())
+	}
+}
+
+// Close stop read goroutine if it's blocked on deliver packet, the blocked
+// read is returned after the conn closed.
+func (r *CtxReader) Close() error {
+	r.once.Do(func() { close(r.closed) })
+	return nil
+}
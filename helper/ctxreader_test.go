@@ -0,0 +1,56 @@
+package helper
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CtxReader(t *testing.T) {
+	var in = make(chan []byte)
+	read := func(b []byte) (int, error) {
+		p, ok := <-in
+		if !ok {
+			return 0, io.EOF
+		}
+		return copy(b, p), nil
+	}
+
+	r := NewCtxReader(read, 64)
+	defer r.Close()
+
+	t.Run("cancel-not-affect-other", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var errCh = make(chan error, 1)
+		go func() {
+			_, err := r.ReadCtx(ctx)
+			errCh <- err
+		}()
+
+		var pktCh = make(chan []byte, 1)
+		go func() {
+			b, err := r.ReadCtx(context.Background())
+			require.NoError(t, err)
+			pktCh <- b
+		}()
+
+		time.Sleep(time.Millisecond * 100)
+		cancel()
+		require.True(t, errors.Is(<-errCh, context.Canceled))
+
+		in <- []byte("hello")
+		require.Equal(t, []byte("hello"), <-pktCh)
+	})
+
+	t.Run("read-failed", func(t *testing.T) {
+		close(in)
+		for i := 0; i < 2; i++ {
+			_, err := r.ReadCtx(context.Background())
+			require.True(t, errors.Is(err, io.EOF))
+		}
+	})
+}
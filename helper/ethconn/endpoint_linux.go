@@ -0,0 +1,237 @@
+//go:build linux
+// +build linux
+
+package ethconn
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/lysShub/netkit/debug"
+	"github.com/lysShub/netkit/errorx"
+	"github.com/lysShub/netkit/eth"
+	"github.com/lysShub/netkit/packet"
+	"github.com/lysShub/rawsock"
+	"github.com/lysShub/rawsock/helper"
+	"github.com/lysShub/rawsock/helper/bind"
+	"github.com/lysShub/rawsock/helper/ipstack"
+	"github.com/lysShub/rawsock/helper/neigh"
+	"github.com/lysShub/rawsock/helper/ring"
+	"github.com/lysShub/rawsock/test"
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// Endpoint is the transport independent part of tcp/eth and udp/eth Conn, it
+// read/write ip packet of the endpoint by AF_PACKET.
+type Endpoint struct {
+	raw     Conn
+	gateway *neigh.Neigh
+	offload *bind.OffloadRef
+	ipstack *ipstack.IPStack
+
+	// IPPROTO_RAW socket, used to inject ip packet to local stack
+	hdrincl *net.IPConn
+
+	// packet recved by Listener, it's the first read result
+	first   []byte
+	firstMu sync.Mutex
+
+	closeErr errorx.CloseErr
+}
+
+// NewEndpoint create Endpoint from laddr to raddr, first is the packet recved
+// by Listener, maybe nil.
+func NewEndpoint(proto tcpip.TransportProtocolNumber, laddr, raddr netip.AddrPort, first []byte, cfg *rawsock.Config) (*Endpoint, error) {
+	var e = &Endpoint{first: first}
+
+	var err error
+	if e.raw, e.gateway, e.offload, err = Dial(proto, laddr, raddr, cfg); err != nil {
+		return nil, err
+	}
+
+	if e.hdrincl, err = helper.ListenRawIP(laddr.Addr()); err != nil {
+		return nil, e.close(err)
+	}
+
+	var opts = []ipstack.Option{cfg.IPStack.Unmarshal()}
+	if Offload(e.raw) {
+		opts = append(opts, ipstack.OffloadChecksum)
+	}
+	if e.ipstack, err = ipstack.New(
+		laddr.Addr(), raddr.Addr(), proto, opts...,
+	); err != nil {
+		return nil, e.close(err)
+	}
+	return e, nil
+}
+
+func (e *Endpoint) close(cause error) error {
+	return e.closeErr.Close(func() (errs []error) {
+		errs = append(errs, cause)
+		if e.raw != nil {
+			errs = append(errs, e.raw.Close())
+		}
+		if e.hdrincl != nil {
+			errs = append(errs, e.hdrincl.Close())
+		}
+		if e.gateway != nil {
+			errs = append(errs, e.gateway.Close())
+		}
+		errs = append(errs, e.offload.Close())
+		return
+	})
+}
+
+func (e *Endpoint) Read(pkt *packet.Packet) (err error) {
+	hdr, err := e.read(pkt)
+	if err != nil {
+		return err
+	}
+	pkt.SetHead(pkt.Head() + hdr)
+	return nil
+}
+
+func (e *Endpoint) ReadRaw(ip *packet.Packet) (err error) {
+	_, err = e.read(ip)
+	return err
+}
+
+func (e *Endpoint) read(pkt *packet.Packet) (hdr int, err error) {
+	n, err := e.readFirst(pkt)
+	if err != nil {
+		return 0, err
+	} else if n == 0 {
+		if n, _, err = e.raw.ReadFromETH(pkt.Bytes()); err != nil {
+			return 0, err
+		}
+	}
+	pkt.SetData(n)
+
+	hdr, err = helper.CheckIP(pkt.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	if debug.Debug() {
+		test.ValidIP(test.P(), pkt.Bytes())
+	}
+	return hdr, nil
+}
+
+// readFirst read the packet recved by Listener, return 0 if it has been read
+func (e *Endpoint) readFirst(pkt *packet.Packet) (int, error) {
+	e.firstMu.Lock()
+	defer e.firstMu.Unlock()
+	if e.first == nil {
+		return 0, nil
+	} else if pkt.Data() < len(e.first) {
+		return 0, errorx.ShortBuff(len(e.first), pkt.Data())
+	}
+
+	n := copy(pkt.Bytes(), e.first)
+	e.first = nil
+	return n, nil
+}
+
+func (e *Endpoint) ReadBatch(pkts []*packet.Packet) (n int, err error) {
+	e.firstMu.Lock()
+	has := e.first != nil
+	e.firstMu.Unlock()
+
+	r, ok := e.raw.(*ring.Conn)
+	if !ok || has {
+		if len(pkts) == 0 {
+			return 0, nil
+		} else if err = e.Read(pkts[0]); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	}
+
+	n, err = r.ReadBatch(pkts)
+	for i := 0; i < n; i++ {
+		hdr, err := helper.CheckIP(pkts[i].Bytes())
+		if err != nil {
+			return i, err
+		}
+		pkts[i].SetHead(pkts[i].Head() + hdr)
+	}
+	return n, err
+}
+
+func (e *Endpoint) Write(pkt *packet.Packet) (err error) {
+	defer pkt.DetachN(e.ipstack.Size())
+	e.ipstack.AttachOutbound(pkt)
+	if e.ipstack.Offload() {
+		// checksum is partial, completed by nic
+		_, err = e.raw.(Offloader).WriteToETHPartial(pkt.Bytes(), e.gateway.HardwareAddr())
+		return err
+	}
+	return e.WriteRaw(pkt)
+}
+
+func (e *Endpoint) WriteRaw(ip *packet.Packet) (err error) {
+	if debug.Debug() {
+		test.ValidIP(test.P(), ip.Bytes())
+	}
+
+	_, err = e.raw.WriteToETH(ip.Bytes(), e.gateway.HardwareAddr())
+	return err
+}
+
+func (e *Endpoint) WriteBatch(pkts []*packet.Packet) (n int, err error) {
+	r, ok := e.raw.(*ring.Conn)
+	if !ok {
+		for i, pkt := range pkts {
+			if err = e.Write(pkt); err != nil {
+				return i, err
+			}
+		}
+		return len(pkts), nil
+	}
+
+	for _, pkt := range pkts {
+		e.ipstack.AttachOutbound(pkt)
+		if debug.Debug() && !e.ipstack.Offload() {
+			test.ValidIP(test.P(), pkt.Bytes())
+		}
+	}
+	defer func() {
+		for _, pkt := range pkts {
+			pkt.DetachN(e.ipstack.Size())
+		}
+	}()
+	if e.ipstack.Offload() {
+		return r.WriteBatchPartial(pkts, e.gateway.HardwareAddr())
+	}
+	return r.WriteBatch(pkts, e.gateway.HardwareAddr())
+}
+
+// Inject inject transport packet to local stack, as if it recved from remote.
+// the packet is routed to local address by IPPROTO_RAW socket, not by AF_PACKET.
+func (e *Endpoint) Inject(pkt *packet.Packet) (err error) {
+	defer pkt.DetachN(e.ipstack.Size())
+	e.ipstack.AttachInbound(pkt)
+	return e.InjectRaw(pkt)
+}
+
+func (e *Endpoint) InjectRaw(ip *packet.Packet) (err error) {
+	if debug.Debug() {
+		test.ValidIP(test.P(), ip.Bytes())
+	}
+	return helper.WriteRawIP(e.hdrincl, ip.Bytes())
+}
+
+// Raw return AF_PACKET conn, return nil if use PACKET_MMAP ring
+func (e *Endpoint) Raw() *eth.ETHConn {
+	return ETHConn(e.raw)
+}
+
+// Offload return whether tx checksum is completed by nic
+func (e *Endpoint) Offload() bool { return e.ipstack.Offload() }
+
+func (e *Endpoint) SetDeadline(t time.Time) error      { return e.raw.SetDeadline(t) }
+func (e *Endpoint) SetReadDeadline(t time.Time) error  { return e.raw.SetReadDeadline(t) }
+func (e *Endpoint) SetWriteDeadline(t time.Time) error { return e.raw.SetWriteDeadline(t) }
+func (e *Endpoint) Close() error                       { return e.close(nil) }
@@ -0,0 +1,166 @@
+//go:build linux
+// +build linux
+
+// Package ethconn create AF_PACKET conn for a transport endpoint, it's shared
+// by tcp/eth and udp/eth.
+package ethconn
+
+import (
+	"net"
+	"net/netip"
+	"syscall"
+	"time"
+
+	"github.com/lysShub/netkit/eth"
+	"github.com/lysShub/rawsock"
+	"github.com/lysShub/rawsock/helper"
+	"github.com/lysShub/rawsock/helper/bind"
+	"github.com/lysShub/rawsock/helper/bpf"
+	"github.com/lysShub/rawsock/helper/neigh"
+	"github.com/lysShub/rawsock/helper/ring"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// Conn implement by eth.ETHConn and ring.Conn
+type Conn interface {
+	ReadFromETH(ip []byte) (n int, from net.HardwareAddr, err error)
+	WriteToETH(ip []byte, hw net.HardwareAddr) (int, error)
+	SyscallConn() syscall.RawConn
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	Close() error
+}
+
+var _ Conn = (*eth.ETHConn)(nil)
+var _ Conn = (*ring.Conn)(nil)
+
+// Dial create AF_PACKET conn on the nic that route to raddr, only recv packet
+// from raddr to laddr, and tracking the gateway hardware address. the returned
+// OffloadRef reference nic offload settings changed by cfg.SetGRO, maybe nil.
+func Dial(proto tcpip.TransportProtocolNumber, laddr, raddr netip.AddrPort, cfg *rawsock.Config) (Conn, *neigh.Neigh, *bind.OffloadRef, error) {
+	entry, err := helper.GetRoute(raddr.Addr())
+	if err != nil {
+		return nil, nil, nil, errors.WithMessage(err, raddr.Addr().String())
+	}
+
+	// get gateway mac address
+	var ifi *net.Interface
+	var gateway *neigh.Neigh
+	if entry.Local {
+		if ifi, err = helper.LoopbackInterface(); err != nil {
+			return nil, nil, nil, err
+		}
+		// loopback nic's hardware address is zero
+		gateway = neigh.Static(make(net.HardwareAddr, 6))
+	} else {
+		ifi, err = net.InterfaceByIndex(entry.Interface)
+		if err != nil {
+			return nil, nil, nil, errors.WithStack(err)
+		}
+
+		next := entry.Next
+		if !next.IsValid() {
+			next = raddr.Addr() // on-link
+		}
+		if gateway, err = neigh.Track(ifi, next, &neigh.Config{
+			Timeout: cfg.ARPTimeout,
+			Retry:   cfg.ARPRetry,
+		}); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	var ref *bind.OffloadRef
+	if cfg.SetGRO && !cfg.UserGRO {
+		if ref, err = bind.SetGRO(laddr.Addr(), raddr.Addr(), false); err != nil {
+			gateway.Close()
+			return nil, nil, nil, err
+		}
+	}
+
+	conn, err := dial(proto, laddr, raddr, ifi, entry.Local, cfg)
+	if err != nil {
+		gateway.Close()
+		ref.Close()
+		return nil, nil, nil, err
+	}
+	return conn, gateway, ref, nil
+}
+
+func dial(proto tcpip.TransportProtocolNumber, laddr, raddr netip.AddrPort, ifi *net.Interface, loopback bool, cfg *rawsock.Config) (conn Conn, err error) {
+	// create eth conn and set bpf filter
+	network, netproto := "eth:ip4", header.IPv4ProtocolNumber
+	if !raddr.Addr().Is4() {
+		network, netproto = "eth:ip6", header.IPv6ProtocolNumber
+	}
+	// loopback nic never complete partial checksum, other AF_PACKET or raw
+	// socket will recv packet with invalid checksum
+	offload := cfg.ChecksumOffload && !loopback
+	if cfg.PacketMMAP {
+		if conn, err = ring.Listen(network, ifi, &ring.Config{
+			BlockSize: cfg.RingBlockSize,
+			BlockNum:  cfg.RingBlockNum,
+			FrameSize: cfg.RingFrameSize,
+			VnetHdr:   offload,
+			GRO:       cfg.UserGRO,
+		}); err != nil {
+			return nil, err
+		}
+	} else if cfg.UserGRO {
+		if conn, err = newGROConn(netproto, ifi, offload); err != nil {
+			return nil, err
+		}
+	} else {
+		ec, err := eth.Listen(network, ifi)
+		if err != nil {
+			return nil, err
+		}
+		conn = ec
+		if offload {
+			// fallback to software checksum if not support
+			if oc, err := newOffloadConn(ec, netproto, ifi); err == nil {
+				conn = oc
+			}
+		}
+	}
+
+	if err := ignoreOutgoing(conn.SyscallConn()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	filter := bpf.FilterEndpoint(proto, raddr, laddr)
+	if cfg.UserGRO {
+		// SOCK_RAW socket recv packet with ethernet header
+		filter = bpf.Ethernet(filter)
+	}
+	if err := bpf.SetRawBPF(conn.SyscallConn(), filter); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if loopback {
+		lc, err := newLoopbackConn(conn, laddr.Addr())
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = lc
+	}
+	return conn, nil
+}
+
+// ignoreOutgoing not recv outgoing packet, on loopback every packet will be
+// recved twice as outgoing and incoming
+func ignoreOutgoing(raw syscall.RawConn) error {
+	var e error
+	if err := raw.Control(func(fd uintptr) {
+		e = unix.SetsockoptInt(int(fd), unix.SOL_PACKET, unix.PACKET_IGNORE_OUTGOING, 1)
+	}); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(e)
+}
@@ -0,0 +1,151 @@
+//go:build linux
+// +build linux
+
+package ethconn
+
+import (
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lysShub/netkit/errorx"
+	"github.com/lysShub/netkit/eth"
+	"github.com/lysShub/rawsock/helper/vnet"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// groConn recv/send by SOCK_RAW socket with PACKET_VNET_HDR, the recved GRO
+// super-packet is split to wire-sized segments, so needn't disable nic GRO.
+type groConn struct {
+	proto   tcpip.NetworkProtocolNumber
+	ifi     *net.Interface
+	offload bool
+	fd      *os.File
+	raw     syscall.RawConn
+
+	mu    sync.Mutex
+	split vnet.Splitter
+	from  net.HardwareAddr // source of super-packet
+	buf   []byte
+
+	closeErr errorx.CloseErr
+}
+
+func newGROConn(proto tcpip.NetworkProtocolNumber, ifi *net.Interface, offload bool) (*groConn, error) {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, int(eth.Htons(proto)))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err = unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_VNET_HDR, 1); err != nil {
+		unix.Close(fd)
+		return nil, errors.WithStack(err)
+	}
+	if err = unix.Bind(fd, &unix.SockaddrLinklayer{
+		Protocol: eth.Htons(uint16(proto)),
+		Ifindex:  ifi.Index,
+	}); err != nil {
+		unix.Close(fd)
+		return nil, errors.WithStack(err)
+	}
+
+	var c = &groConn{
+		proto: proto, ifi: ifi, offload: offload,
+		buf: make([]byte, 0xffff),
+	}
+	c.fd = os.NewFile(uintptr(fd), "")
+	if c.raw, err = c.fd.SyscallConn(); err != nil {
+		c.fd.Close()
+		return nil, errors.WithStack(err)
+	}
+	return c, nil
+}
+
+func (c *groConn) ReadFromETH(ip []byte) (int, net.HardwareAddr, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.split.More() {
+		n, err := c.split.Next(ip)
+		return n, c.from, err
+	}
+
+	// super-packet exceed ip is recved to the rest of buf
+	var hdr [vnet.Size + header.EthernetMinimumSize]byte
+	var bufs = [][]byte{hdr[:], ip}
+	if len(ip) < len(c.buf) {
+		bufs = append(bufs, c.buf[len(ip):])
+	}
+	var n, flags int
+	var operr error
+	if err := c.raw.Read(func(fd uintptr) (done bool) {
+		// with MSG_TRUNC, return the real length of truncated packet
+		n, _, flags, _, operr = unix.RecvmsgBuffers(int(fd), bufs, nil, unix.MSG_TRUNC)
+		return operr != unix.EAGAIN && operr != unix.EWOULDBLOCK
+	}); err != nil {
+		return 0, nil, errors.WithStack(err)
+	} else if operr != nil {
+		return 0, nil, errors.WithStack(operr)
+	} else if n < len(hdr) {
+		return 0, nil, errors.Errorf("invalid packet with length %d", n)
+	}
+	n -= len(hdr)
+	if flags&unix.MSG_TRUNC != 0 {
+		return 0, nil, errorx.ShortBuff(n, max(len(ip), len(c.buf)))
+	}
+
+	var h vnet.Hdr
+	if err := h.Decode(hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	// ethernet source address
+	from := append(net.HardwareAddr{}, hdr[vnet.Size+6:vnet.Size+12]...)
+
+	if h.GSOType == vnet.GSONone {
+		if n > len(ip) {
+			return 0, nil, errorx.ShortBuff(n, len(ip))
+		}
+		if h.Flags&vnet.FlagNeedsCsum != 0 {
+			vnet.Complete(ip[:n])
+		}
+		return n, from, nil
+	}
+
+	copy(c.buf, ip[:min(n, len(ip))])
+	if err := c.split.Reset(h, c.buf[:n]); err != nil {
+		return 0, nil, err
+	}
+	c.from = from
+	n, err := c.split.Next(ip)
+	return n, from, err
+}
+
+func (c *groConn) Offload() bool { return c.offload }
+
+func (c *groConn) WriteToETH(ip []byte, hw net.HardwareAddr) (int, error) {
+	return writeVnet(c.raw, c.proto, c.ifi, ip, hw, vnet.Hdr{})
+}
+
+func (c *groConn) WriteToETHPartial(ip []byte, hw net.HardwareAddr) (int, error) {
+	return writeVnet(c.raw, c.proto, c.ifi, ip, hw, vnet.Partial(ip, header.EthernetMinimumSize))
+}
+
+func (c *groConn) SyscallConn() syscall.RawConn { return c.raw }
+func (c *groConn) SetDeadline(t time.Time) error {
+	return errors.WithStack(c.fd.SetDeadline(t))
+}
+func (c *groConn) SetReadDeadline(t time.Time) error {
+	return errors.WithStack(c.fd.SetReadDeadline(t))
+}
+func (c *groConn) SetWriteDeadline(t time.Time) error {
+	return errors.WithStack(c.fd.SetWriteDeadline(t))
+}
+
+func (c *groConn) Close() error {
+	return c.closeErr.Close(func() (errs []error) {
+		return append(errs, errors.WithStack(c.fd.Close()))
+	})
+}
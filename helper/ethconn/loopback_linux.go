@@ -0,0 +1,48 @@
+//go:build linux
+// +build linux
+
+package ethconn
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/lysShub/netkit/errorx"
+	"github.com/lysShub/rawsock/helper"
+	"github.com/pkg/errors"
+)
+
+// loopbackConn recv by AF_PACKET conn on loopback nic, send by IPPROTO_RAW
+// socket. packet written to loopback by AF_PACKET has not route, it's martian
+// source for ipv4, and only accepted when enable accept_local and route_localnet.
+type loopbackConn struct {
+	Conn
+
+	raw *net.IPConn
+
+	closeErr errorx.CloseErr
+}
+
+func newLoopbackConn(conn Conn, laddr netip.Addr) (*loopbackConn, error) {
+	raw, err := helper.ListenRawIP(laddr)
+	if err != nil {
+		return nil, err
+	}
+	return &loopbackConn{Conn: conn, raw: raw}, nil
+}
+
+// WriteToETH write ip packet to local stack, hw is ignored.
+func (c *loopbackConn) WriteToETH(ip []byte, hw net.HardwareAddr) (int, error) {
+	if err := helper.WriteRawIP(c.raw, ip); err != nil {
+		return 0, err
+	}
+	return len(ip), nil
+}
+
+func (c *loopbackConn) Close() error {
+	return c.closeErr.Close(func() (errs []error) {
+		errs = append(errs, c.Conn.Close())
+		errs = append(errs, errors.WithStack(c.raw.Close()))
+		return
+	})
+}
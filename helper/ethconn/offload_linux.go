@@ -0,0 +1,157 @@
+//go:build linux
+// +build linux
+
+package ethconn
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/lysShub/netkit/errorx"
+	"github.com/lysShub/netkit/eth"
+	"github.com/lysShub/rawsock/helper/ring"
+	"github.com/lysShub/rawsock/helper/vnet"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// Offloader is Conn that support tx checksum offload by PACKET_VNET_HDR
+type Offloader interface {
+	Conn
+
+	// Offload return whether support checksum offload
+	Offload() bool
+
+	// WriteToETHPartial write ip packet that tcp/udp checksum field is pseudo
+	// header checksum, the checksum will be completed by nic.
+	WriteToETHPartial(ip []byte, hw net.HardwareAddr) (int, error)
+}
+
+var _ Offloader = (*ring.Conn)(nil)
+var _ Offloader = (*offloadConn)(nil)
+var _ Offloader = (*groConn)(nil)
+
+// Offload return whether conn support tx checksum offload
+func Offload(conn Conn) bool {
+	o, ok := conn.(Offloader)
+	return ok && o.Offload()
+}
+
+// ETHConn return underlying eth.ETHConn, return nil if use PACKET_MMAP ring
+func ETHConn(conn Conn) *eth.ETHConn {
+	switch c := conn.(type) {
+	case *eth.ETHConn:
+		return c
+	case *offloadConn:
+		return c.ETHConn
+	case *loopbackConn:
+		return ETHConn(c.Conn)
+	default:
+		return nil
+	}
+}
+
+// offloadConn recv by eth.ETHConn, send by SOCK_RAW socket with PACKET_VNET_HDR,
+// PACKET_VNET_HDR not support SOCK_DGRAM.
+type offloadConn struct {
+	*eth.ETHConn
+
+	proto tcpip.NetworkProtocolNumber
+	ifi   *net.Interface
+	fd    *os.File
+	raw   syscall.RawConn
+
+	closeErr errorx.CloseErr
+}
+
+// newOffloadConn create tx socket with PACKET_VNET_HDR for conn, return
+// error if not supported.
+func newOffloadConn(conn *eth.ETHConn, proto tcpip.NetworkProtocolNumber, ifi *net.Interface) (*offloadConn, error) {
+	// protocol 0, never recv packet
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err = unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_VNET_HDR, 1); err != nil {
+		unix.Close(fd)
+		return nil, errors.WithStack(err)
+	}
+
+	var c = &offloadConn{ETHConn: conn, proto: proto, ifi: ifi}
+	c.fd = os.NewFile(uintptr(fd), "")
+	if c.raw, err = c.fd.SyscallConn(); err != nil {
+		c.fd.Close()
+		return nil, errors.WithStack(err)
+	}
+	return c, nil
+}
+
+func (c *offloadConn) Offload() bool { return true }
+
+func (c *offloadConn) WriteToETH(ip []byte, hw net.HardwareAddr) (int, error) {
+	return c.write(ip, hw, vnet.Hdr{})
+}
+
+func (c *offloadConn) WriteToETHPartial(ip []byte, hw net.HardwareAddr) (int, error) {
+	return c.write(ip, hw, vnet.Partial(ip, header.EthernetMinimumSize))
+}
+
+func (c *offloadConn) write(ip []byte, hw net.HardwareAddr, h vnet.Hdr) (int, error) {
+	return writeVnet(c.raw, c.proto, c.ifi, ip, hw, h)
+}
+
+// writeVnet write ip packet by SOCK_RAW socket with PACKET_VNET_HDR
+func writeVnet(raw syscall.RawConn, proto tcpip.NetworkProtocolNumber, ifi *net.Interface, ip []byte, hw net.HardwareAddr, h vnet.Hdr) (int, error) {
+	var b [vnet.Size + header.EthernetMinimumSize]byte
+	h.Encode(b[:])
+	header.Ethernet(b[vnet.Size:]).Encode(&header.EthernetFields{
+		SrcAddr: tcpip.LinkAddress(ifi.HardwareAddr),
+		DstAddr: tcpip.LinkAddress(hw),
+		Type:    proto,
+	})
+
+	to := &unix.SockaddrLinklayer{
+		Protocol: eth.Htons(uint16(proto)),
+		Ifindex:  ifi.Index,
+		Halen:    uint8(len(hw)),
+	}
+	copy(to.Addr[:], hw)
+
+	var n int
+	var operr error
+	if err := raw.Write(func(fd uintptr) (done bool) {
+		n, operr = unix.SendmsgBuffers(int(fd), [][]byte{b[:], ip}, nil, to, 0)
+		return operr != unix.EAGAIN && operr != unix.EWOULDBLOCK
+	}); err != nil {
+		return 0, errors.WithStack(err)
+	} else if operr != nil {
+		return 0, errors.WithStack(operr)
+	}
+	return max(n-len(b), 0), nil
+}
+
+func (c *offloadConn) SetDeadline(t time.Time) error {
+	if err := c.ETHConn.SetDeadline(t); err != nil {
+		return err
+	}
+	return errors.WithStack(c.fd.SetWriteDeadline(t))
+}
+
+func (c *offloadConn) SetWriteDeadline(t time.Time) error {
+	if err := c.ETHConn.SetWriteDeadline(t); err != nil {
+		return err
+	}
+	return errors.WithStack(c.fd.SetWriteDeadline(t))
+}
+
+func (c *offloadConn) Close() error {
+	return c.closeErr.Close(func() (errs []error) {
+		errs = append(errs, c.ETHConn.Close())
+		errs = append(errs, errors.WithStack(c.fd.Close()))
+		return errs
+	})
+}
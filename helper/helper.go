@@ -1,38 +1,52 @@
 package helper
 
 import (
+	"math"
+	"net"
 	"net/netip"
 	"syscall"
 
 	"github.com/lysShub/netkit/debug"
-	"github.com/lysShub/netkit/errorx"
 	"github.com/lysShub/netkit/route"
 	"github.com/pkg/errors"
-	"gvisor.dev/gvisor/pkg/tcpip/header"
 )
 
-// todo: set MSG_TRUNC flag
+// IPCheck check ip packet, return ip header length.
+//
+// Deprecated: ipv6 extension headers maybe longer than 255 bytes, use CheckIP.
 func IPCheck(ip []byte) (iphdrsize uint8, err error) {
-	switch header.IPVersion(ip) {
-	case 4:
-		hdr := header.IPv4(ip)
-		if tn := int(hdr.TotalLength()); tn != len(ip) {
-			return 0, errorx.ShortBuff(int(tn), len(ip))
-		}
-		return hdr.HeaderLength(), nil
-	case 6:
-		hdr := header.IPv6(ip)
-		tn := int(hdr.PayloadLength()) + header.IPv6MinimumSize
-		if tn != len(ip) {
-			return 0, errorx.ShortBuff(int(tn), len(ip))
-		}
-		return header.IPv6MinimumSize, nil
-	default:
+	n, err := CheckIP(ip)
+	if err != nil {
+		return 0, err
+	} else if n > math.MaxUint8 {
+		return 0, errors.Errorf("ip header length %d overflow uint8", n)
+	}
+	return uint8(n), nil
+}
+
+// CheckIP check ip packet, return ip header length include ipv4 options or
+// ipv6 extension headers, fragmented ipv6 packet is invalid. use ParseIP to
+// get the parsed headers.
+// todo: set MSG_TRUNC flag
+func CheckIP(ip []byte) (iphdrsize int, err error) {
+	var hdr IPHeader
+	if err = parseIP(ip, &hdr, false, false); err != nil {
 		if debug.Debug() {
-			return 0, errors.Errorf("invalid ip packet: %#v", ip)
+			return 0, errors.WithMessagef(err, "%#v", ip)
 		}
-		return 0, errors.New("invalid ip packet")
+		return 0, err
+	}
+	return hdr.Len, nil
+}
+
+// IPHeaderLen like CheckIP, but not check total length, suit truncated packet
+// such as Listener recved, that only contain headers.
+func IPHeaderLen(ip []byte) (iphdrsize int, err error) {
+	var hdr IPHeader
+	if err = parseIP(ip, &hdr, false, true); err != nil {
+		return 0, err
 	}
+	return hdr.Len, nil
 }
 
 // DefaultLocal alloc deault local-addr by remote-addr
@@ -40,6 +54,15 @@ func DefaultLocal(laddr, raddr netip.Addr) (netip.Addr, error) {
 	if !laddr.IsUnspecified() {
 		return laddr, nil
 	}
+	if !raddr.Is4() {
+		// route table only support ipv4, get it by system udp dial
+		conn, err := net.DialUDP("udp6", nil, &net.UDPAddr{IP: raddr.AsSlice(), Zone: raddr.Zone(), Port: 53})
+		if err != nil {
+			return netip.Addr{}, errors.WithStack(err)
+		}
+		defer conn.Close()
+		return netip.MustParseAddrPort(conn.LocalAddr().String()).Addr(), nil
+	}
 
 	table, err := route.GetTable()
 	if err != nil {
@@ -66,3 +89,17 @@ func DefaultLocal(laddr, raddr netip.Addr) (netip.Addr, error) {
 	}
 	return laddr, nil
 }
+
+// LoopbackInterface return loopback nic
+func LoopbackInterface() (*net.Interface, error) {
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for _, e := range ifs {
+		if e.Flags&net.FlagLoopback != 0 {
+			return &e, nil
+		}
+	}
+	return nil, errors.New("not found loopback interface")
+}
@@ -0,0 +1,149 @@
+package helper
+
+import (
+	"encoding/binary"
+
+	"github.com/lysShub/netkit/errorx"
+	"github.com/pkg/errors"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// IPv6AuthenticationExtHdrIdentifier header identifier of ipv6 authentication
+// header (RFC 4302), it's length is in 4-octet units.
+const IPv6AuthenticationExtHdrIdentifier header.IPv6ExtensionHeaderIdentifier = 51
+
+// IPHeader parsed ip header, include ipv4 options or ipv6 extension headers
+type IPHeader struct {
+	Len   int                           // ip header length, include options/extension headers
+	Proto tcpip.TransportProtocolNumber // upper layer protocol
+
+	Options    []IPv4Option    // ipv4 options, without EOL and NOP
+	Extensions []IPv6Extension // ipv6 extension headers, in order
+}
+
+// IPv4Option ipv4 option, Data include type and length fields
+type IPv4Option struct {
+	Type header.IPv4OptionType
+	Data []byte
+}
+
+// IPv6Extension ipv6 extension header, Data is the whole extension header
+type IPv6Extension struct {
+	ID   header.IPv6ExtensionHeaderIdentifier
+	Data []byte
+}
+
+// ParseIP like CheckIP, and return the parsed ipv4 options or ipv6 extension
+// headers, the returned slices reference ip.
+func ParseIP(ip []byte) (IPHeader, error) {
+	var hdr IPHeader
+	err := parseIP(ip, &hdr, true, false)
+	return hdr, err
+}
+
+// parseIP check ip packet, walk ipv4 options or ipv6 extension headers, they
+// are recorded to hdr if record. total length is not checked if trunc.
+func parseIP(ip []byte, hdr *IPHeader, record, trunc bool) error {
+	switch header.IPVersion(ip) {
+	case 4:
+		if len(ip) < header.IPv4MinimumSize {
+			return errorx.ShortBuff(header.IPv4MinimumSize, len(ip))
+		}
+		iphdr := header.IPv4(ip)
+		if tn := int(iphdr.TotalLength()); tn != len(ip) && !trunc {
+			return errorx.ShortBuff(tn, len(ip))
+		}
+		n := int(iphdr.HeaderLength())
+		if n < header.IPv4MinimumSize || n > len(ip) {
+			return errors.Errorf("invalid ipv4 header length %d", n)
+		}
+		hdr.Len, hdr.Proto = n, iphdr.TransportProtocol()
+		return parseIPv4Options(ip[header.IPv4MinimumSize:n], hdr, record)
+	case 6:
+		if len(ip) < header.IPv6MinimumSize {
+			return errorx.ShortBuff(header.IPv6MinimumSize, len(ip))
+		}
+		iphdr := header.IPv6(ip)
+		if tn := int(iphdr.PayloadLength()) + header.IPv6MinimumSize; tn != len(ip) && !trunc {
+			return errorx.ShortBuff(tn, len(ip))
+		}
+		return parseIPv6Extensions(ip, hdr, record)
+	default:
+		return errors.New("invalid ip packet")
+	}
+}
+
+func parseIPv4Options(opts []byte, hdr *IPHeader, record bool) error {
+	for i := 0; i < len(opts); {
+		switch typ := header.IPv4OptionType(opts[i]); typ {
+		case header.IPv4OptionListEndType:
+			return nil
+		case header.IPv4OptionNOPType:
+			i++
+		default:
+			if i+1 >= len(opts) {
+				return errors.Errorf("invalid ipv4 option %d", typ)
+			}
+			n := int(opts[i+1])
+			if n < 2 || i+n > len(opts) {
+				return errors.Errorf("invalid ipv4 option %d with length %d", typ, n)
+			}
+			if record {
+				hdr.Options = append(hdr.Options, IPv4Option{Type: typ, Data: opts[i : i+n]})
+			}
+			i += n
+		}
+	}
+	return nil
+}
+
+func parseIPv6Extensions(ip []byte, hdr *IPHeader, record bool) error {
+	next := header.IPv6(ip).NextHeader()
+	off := header.IPv6MinimumSize
+	for {
+		var n int
+		switch id := header.IPv6ExtensionHeaderIdentifier(next); id {
+		case header.IPv6HopByHopOptionsExtHdrIdentifier:
+			if off != header.IPv6MinimumSize {
+				return errors.New("ipv6 hop-by-hop options header not follow ipv6 header")
+			}
+			fallthrough
+		case header.IPv6RoutingExtHdrIdentifier, header.IPv6DestinationOptionsExtHdrIdentifier:
+			if off+2 > len(ip) {
+				return errorx.ShortBuff(off+2, len(ip))
+			}
+			n = (int(ip[off+1]) + 1) * 8
+		case IPv6AuthenticationExtHdrIdentifier:
+			if off+2 > len(ip) {
+				return errorx.ShortBuff(off+2, len(ip))
+			}
+			n = (int(ip[off+1]) + 2) * 4
+		case header.IPv6FragmentExtHdrIdentifier:
+			n = header.IPv6FragmentExtHdrLength
+		default:
+			hdr.Len, hdr.Proto = off, tcpip.TransportProtocolNumber(next)
+			return nil
+		}
+		if off+n > len(ip) {
+			return errorx.ShortBuff(off+n, len(ip))
+		}
+
+		if header.IPv6ExtensionHeaderIdentifier(next) == header.IPv6FragmentExtHdrIdentifier {
+			// only atomic fragment, upper layer header of fragmented packet
+			// is incomplete
+			frag := binary.BigEndian.Uint16(ip[off+2:])
+			if frag>>3 != 0 || frag&1 != 0 {
+				return errors.New("not support fragmented ipv6 packet")
+			}
+		}
+
+		if record {
+			hdr.Extensions = append(hdr.Extensions, IPv6Extension{
+				ID:   header.IPv6ExtensionHeaderIdentifier(next),
+				Data: ip[off : off+n],
+			})
+		}
+		next, off = ip[off], off+n
+	}
+}
@@ -0,0 +1,168 @@
+package helper
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func Test_ParseIP(t *testing.T) {
+	var (
+		src = netip.MustParseAddr("10.0.0.1")
+		dst = netip.MustParseAddr("10.0.0.2")
+	)
+	ipv4 := func(opts []byte) []byte {
+		hdrLen := header.IPv4MinimumSize + len(opts)
+		var ip = make(header.IPv4, hdrLen+header.UDPMinimumSize)
+		ip.Encode(&header.IPv4Fields{
+			TotalLength: uint16(len(ip)),
+			TTL:         64,
+			Protocol:    uint8(header.UDPProtocolNumber),
+			SrcAddr:     tcpip.AddrFrom4(src.As4()),
+			DstAddr:     tcpip.AddrFrom4(dst.As4()),
+		})
+		ip.SetHeaderLength(uint8(hdrLen))
+		copy(ip[header.IPv4MinimumSize:], opts)
+		return ip
+	}
+
+	t.Run("ipv4-options", func(t *testing.T) {
+		// NOP, Router Alert, EOL padding
+		ip := ipv4([]byte{1, 148, 4, 0, 0, 0, 0, 0})
+
+		hdr, err := ParseIP(ip)
+		require.NoError(t, err)
+		require.Equal(t, 28, hdr.Len)
+		require.Equal(t, header.UDPProtocolNumber, hdr.Proto)
+		require.Equal(t, []IPv4Option{{Type: 148, Data: []byte{148, 4, 0, 0}}}, hdr.Options)
+
+		n, err := CheckIP(ip)
+		require.NoError(t, err)
+		require.Equal(t, 28, n)
+	})
+
+	t.Run("ipv4-invalid-option", func(t *testing.T) {
+		_, err := CheckIP(ipv4([]byte{148, 8, 0, 0}))
+		require.Error(t, err)
+
+		_, err = CheckIP(ipv4([]byte{1, 1, 1, 148}))
+		require.Error(t, err)
+	})
+
+	ipv6 := func(exts ...[]byte) []byte {
+		var n int
+		for _, e := range exts {
+			n += len(e)
+		}
+		var ip = make(header.IPv6, header.IPv6MinimumSize+n+header.TCPMinimumSize)
+		ip.Encode(&header.IPv6Fields{
+			PayloadLength:     uint16(n + header.TCPMinimumSize),
+			TransportProtocol: header.TCPProtocolNumber,
+			HopLimit:          64,
+			SrcAddr:           tcpip.AddrFrom16(netip.MustParseAddr("fd00::1").As16()),
+			DstAddr:           tcpip.AddrFrom16(netip.MustParseAddr("fd00::2").As16()),
+		})
+		if len(exts) > 0 {
+			ip[6] = exts[0][0] // next header field of ipv6 header
+			off := header.IPv6MinimumSize
+			for i, e := range exts {
+				copy(ip[off:], e)
+				if i+1 < len(exts) {
+					ip[off] = exts[i+1][0]
+				} else {
+					ip[off] = uint8(header.TCPProtocolNumber)
+				}
+				off += len(e)
+			}
+		}
+		return ip
+	}
+	// extension header with it's identifier placed at first byte, replaced
+	// by next header when build packet.
+	var (
+		hopByHop = []byte{0, 0, 1, 4, 0, 0, 0, 0}
+		dstOpts  = []byte{60, 1, 1, 12, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+		fragment = []byte{44, 0, 0, 0, 0, 0, 0, 1}
+		auth     = []byte{51, 4, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	)
+
+	t.Run("ipv6-extensions", func(t *testing.T) {
+		ip := ipv6(hopByHop, dstOpts, fragment)
+
+		hdr, err := ParseIP(ip)
+		require.NoError(t, err)
+		require.Equal(t, header.IPv6MinimumSize+32, hdr.Len)
+		require.Equal(t, header.TCPProtocolNumber, hdr.Proto)
+		require.Equal(t, 3, len(hdr.Extensions))
+		require.Equal(t, header.IPv6HopByHopOptionsExtHdrIdentifier, hdr.Extensions[0].ID)
+		require.Equal(t, header.IPv6DestinationOptionsExtHdrIdentifier, hdr.Extensions[1].ID)
+		require.Equal(t, 16, len(hdr.Extensions[1].Data))
+		require.Equal(t, header.IPv6FragmentExtHdrIdentifier, hdr.Extensions[2].ID)
+
+		n, err := CheckIP(ip)
+		require.NoError(t, err)
+		require.Equal(t, hdr.Len, n)
+
+		m, err := IPCheck(ip)
+		require.NoError(t, err)
+		require.Equal(t, n, int(m))
+	})
+
+	t.Run("ipv6-truncated", func(t *testing.T) {
+		ip := ipv6(hopByHop, dstOpts, fragment)
+		ip = ip[:len(ip)-header.TCPMinimumSize+4]
+
+		_, err := CheckIP(ip)
+		require.Error(t, err)
+
+		n, err := IPHeaderLen(ip)
+		require.NoError(t, err)
+		require.Equal(t, header.IPv6MinimumSize+32, n)
+
+		_, err = IPHeaderLen(ip[:header.IPv6MinimumSize+8])
+		require.Error(t, err)
+	})
+
+	t.Run("ipv6-authentication", func(t *testing.T) {
+		hdr, err := ParseIP(ipv6(hopByHop, auth))
+		require.NoError(t, err)
+		require.Equal(t, header.IPv6MinimumSize+32, hdr.Len)
+		require.Equal(t, header.TCPProtocolNumber, hdr.Proto)
+		require.Equal(t, 2, len(hdr.Extensions))
+		require.Equal(t, IPv6AuthenticationExtHdrIdentifier, hdr.Extensions[1].ID)
+		require.Equal(t, 24, len(hdr.Extensions[1].Data))
+	})
+
+	t.Run("ipv6-fragmented", func(t *testing.T) {
+		// more fragments
+		_, err := CheckIP(ipv6([]byte{44, 0, 0, 1, 0, 0, 0, 1}))
+		require.Error(t, err)
+
+		// not first fragment
+		_, err = CheckIP(ipv6([]byte{44, 0, 0, 8, 0, 0, 0, 1}))
+		require.Error(t, err)
+	})
+
+	t.Run("ipv6-no-extension", func(t *testing.T) {
+		hdr, err := ParseIP(ipv6())
+		require.NoError(t, err)
+		require.Equal(t, header.IPv6MinimumSize, hdr.Len)
+		require.Equal(t, header.TCPProtocolNumber, hdr.Proto)
+		require.Nil(t, hdr.Extensions)
+	})
+
+	t.Run("ipv6-invalid-extensions", func(t *testing.T) {
+		// hop-by-hop options header must follow ipv6 header
+		_, err := CheckIP(ipv6(dstOpts, hopByHop))
+		require.Error(t, err)
+
+		// extension header exceed packet
+		ip := ipv6(dstOpts)
+		ip[header.IPv6MinimumSize+1] = 8
+		_, err = CheckIP(ip)
+		require.Error(t, err)
+	})
+}
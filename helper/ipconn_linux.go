@@ -0,0 +1,247 @@
+//go:build linux
+// +build linux
+
+package helper
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/lysShub/netkit/errorx"
+	"github.com/lysShub/rawsock/helper/bpf"
+	"github.com/pkg/errors"
+	xbpf "golang.org/x/net/bpf"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// IPConn raw ip socket, read packet contain ip header.
+//
+// ipv6 raw socket not deliver ip header, so IPConn rebuild it by ancillary
+// data, the rebuilt header not contain extension headers.
+type IPConn struct {
+	*net.IPConn
+	proto tcpip.TransportProtocolNumber
+
+	// not nil if ipv6
+	ipv6  *ipv6.PacketConn
+	oob   []byte
+	oobMu sync.Mutex
+}
+
+const ip6cmFlags = ipv6.FlagTrafficClass | ipv6.FlagHopLimit | ipv6.FlagDst
+
+func ListenIP(proto tcpip.TransportProtocolNumber, laddr netip.Addr) (*IPConn, error) {
+	conn, err := net.ListenIP(
+		network(proto, laddr),
+		&net.IPAddr{IP: laddr.AsSlice(), Zone: laddr.Zone()},
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return newIPConn(conn, proto, laddr)
+}
+
+func DialIP(proto tcpip.TransportProtocolNumber, laddr, raddr netip.Addr) (*IPConn, error) {
+	conn, err := net.DialIP(
+		network(proto, laddr),
+		&net.IPAddr{IP: laddr.AsSlice(), Zone: laddr.Zone()},
+		&net.IPAddr{IP: raddr.AsSlice(), Zone: raddr.Zone()},
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return newIPConn(conn, proto, laddr)
+}
+
+func network(proto tcpip.TransportProtocolNumber, laddr netip.Addr) string {
+	if laddr.Is4() {
+		return fmt.Sprintf("ip4:%d", proto)
+	}
+	return fmt.Sprintf("ip6:%d", proto)
+}
+
+func newIPConn(conn *net.IPConn, proto tcpip.TransportProtocolNumber, laddr netip.Addr) (*IPConn, error) {
+	var c = &IPConn{IPConn: conn, proto: proto}
+	if !laddr.Is4() {
+		c.ipv6 = ipv6.NewPacketConn(conn)
+		if err := c.ipv6.SetControlMessage(ip6cmFlags, true); err != nil {
+			conn.Close()
+			return nil, errors.WithStack(err)
+		}
+
+		// IPV6_FLOWINFO enable recv flow info ancillary data, not supported by ipv6.ControlMessage
+		raw, err := conn.SyscallConn()
+		if err != nil {
+			conn.Close()
+			return nil, errors.WithStack(err)
+		}
+		var e error
+		if err = raw.Control(func(fd uintptr) {
+			e = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, ipv6FlowInfo, 1)
+		}); err == nil {
+			err = e
+		}
+		if err != nil {
+			conn.Close()
+			return nil, errors.WithStack(err)
+		}
+		c.oob = make([]byte, oobSize6)
+	}
+	return c, nil
+}
+
+var oobSize6 = len(ipv6.NewControlMessage(ip6cmFlags)) + unix.CmsgSpace(4)
+
+// IPV6_FLOWINFO, see linux/in6.h
+const ipv6FlowInfo = 11
+
+// IPv4 return whether is ipv4 socket
+func (c *IPConn) IPv4() bool { return c.ipv6 == nil }
+
+// Read read ip packet, contain ip header.
+func (c *IPConn) Read(ip []byte) (int, error) {
+	return c.recv(ip, 0)
+}
+
+// Peek same as Read, but not remove the packet from receive queue, the next
+// Read or Peek will return the same packet.
+func (c *IPConn) Peek(ip []byte) (int, error) {
+	return c.recv(ip, unix.MSG_PEEK)
+}
+
+// SnapRead return read function of Listener that only parse headers, it copy
+// the first snap bytes of packet, unless full report the packet should be read
+// entirely, such as the first packet of new flow that will be delivered to Conn.
+// the bpf filter can't truncate packet by snap length.
+func (c *IPConn) SnapRead(snap int, full func(hdr []byte) bool) func(ip []byte) (int, error) {
+	return func(ip []byte) (int, error) {
+		n, err := c.Peek(ip[:min(snap, len(ip))])
+		if err != nil {
+			return 0, err
+		}
+		if full(ip[:n]) {
+			return c.Read(ip)
+		}
+		return c.Read(ip[:n])
+	}
+}
+
+func (c *IPConn) recv(ip []byte, flags int) (n int, err error) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	if c.ipv6 == nil {
+		var e error
+		if err = raw.Read(func(fd uintptr) (done bool) {
+			n, _, e = unix.Recvfrom(int(fd), ip, flags)
+			return e != unix.EAGAIN
+		}); err == nil {
+			err = e
+		}
+		return n, errors.WithStack(err)
+	}
+
+	if len(ip) < header.IPv6MinimumSize {
+		return 0, errorx.ShortBuff(header.IPv6MinimumSize, len(ip))
+	}
+	c.oobMu.Lock()
+	defer c.oobMu.Unlock()
+
+	// MSG_TRUNC return the real payload length, truncated packet can be detected
+	// by PayloadLength, as ipv4 TotalLength
+	var (
+		oobn int
+		from unix.Sockaddr
+		e    error
+	)
+	if err = raw.Read(func(fd uintptr) (done bool) {
+		n, oobn, _, from, e = unix.Recvmsg(int(fd), ip[header.IPv6MinimumSize:], c.oob, flags|unix.MSG_TRUNC)
+		return e != unix.EAGAIN
+	}); err == nil {
+		err = e
+	}
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	var src net.Addr
+	if sa, ok := from.(*unix.SockaddrInet6); ok {
+		src = &net.IPAddr{IP: sa.Addr[:]}
+	}
+	return c.rebuild6(ip, n, c.oob[:oobn], src)
+}
+
+// rebuild6 rebuild ipv6 header in ip[:IPv6MinimumSize], n is the real payload
+// length. same as ipv4, packet exceed buffer will be truncated silently, return
+// bytes of ip.
+func (c *IPConn) rebuild6(ip []byte, n int, oob []byte, src net.Addr) (int, error) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	var fields = header.IPv6Fields{
+		PayloadLength:     uint16(n),
+		TransportProtocol: c.proto,
+	}
+	if a, ok := src.(*net.IPAddr); ok && a.IP.To16() != nil {
+		fields.SrcAddr = tcpip.AddrFrom16([16]byte(a.IP.To16()))
+	} else {
+		return 0, errors.New("invalid ipv6 ancillary data")
+	}
+	var dst bool
+	for _, m := range msgs {
+		if m.Header.Level != unix.IPPROTO_IPV6 {
+			continue
+		}
+		switch m.Header.Type {
+		case unix.IPV6_TCLASS:
+			if len(m.Data) >= 4 {
+				fields.TrafficClass = uint8(binary.NativeEndian.Uint32(m.Data))
+			}
+		case unix.IPV6_HOPLIMIT:
+			if len(m.Data) >= 4 {
+				fields.HopLimit = uint8(binary.NativeEndian.Uint32(m.Data))
+			}
+		case ipv6FlowInfo:
+			// only deliver if not zero, contain traffic class and flow label
+			if len(m.Data) >= 4 {
+				fields.FlowLabel = binary.BigEndian.Uint32(m.Data) & 0xfffff
+			}
+		case unix.IPV6_PKTINFO:
+			if len(m.Data) >= unix.SizeofInet6Pktinfo {
+				fields.DstAddr = tcpip.AddrFrom16([16]byte(m.Data[:16]))
+				dst = true
+			}
+		}
+	}
+	if !dst {
+		return 0, errors.New("invalid ipv6 ancillary data")
+	}
+
+	header.IPv6(ip).Encode(&fields)
+	return min(header.IPv6MinimumSize+n, len(ip)), nil
+}
+
+// SetBPF set bpf filter, the filter should suit packet that start with ip
+// header, it will be converted for ipv6.
+func (c *IPConn) SetBPF(ins []xbpf.Instruction) error {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if c.ipv6 != nil {
+		if ins, err = bpf.Transport(ins); err != nil {
+			return err
+		}
+	}
+	return bpf.SetRawBPF(raw, ins)
+}
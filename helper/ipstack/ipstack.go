@@ -7,6 +7,7 @@ import (
 	"sync/atomic"
 
 	"github.com/lysShub/netkit/packet"
+	"github.com/lysShub/rawsock/helper"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/checksum"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
@@ -31,10 +32,17 @@ type IPStack struct {
 	inId  atomic.Uint32
 }
 
+// New create IPStack from laddr to raddr. ip header fields options, such as
+// TTL, DSCP, DontFragment, FlowLabel and IPv4Options, only take effect on
+// outbound ip header, inbound ip header use default fields.
 func New(laddr, raddr netip.Addr, proto tcpip.TransportProtocolNumber, opts ...Option) (*IPStack, error) {
 
 	switch proto {
 	case header.TCPProtocolNumber, header.UDPProtocolNumber:
+	case header.ICMPv4ProtocolNumber:
+		if !laddr.Is4() {
+			return nil, fmt.Errorf("not support transport protocol number %d for ipv6", proto)
+		}
 	default:
 		return nil, fmt.Errorf("not support transport protocol number %d", proto)
 	}
@@ -46,21 +54,33 @@ func New(laddr, raddr netip.Addr, proto tcpip.TransportProtocolNumber, opts ...O
 
 	if laddr.Is4() {
 		s.network = header.IPv4ProtocolNumber
-		s.in, s.psoSum1 = initHdr(raddr, laddr, proto)
-		s.out, s.psoSum1 = initHdr(laddr, raddr, proto)
+		// inbound ip header padded with EOL, keep the same length as outbound
+		s.in, _ = initHdr(raddr, laddr, proto, &Configs{
+			ipv4Options: make([]byte, len(s.option.ipv4Options)),
+		})
+		s.out, s.psoSum1 = initHdr(laddr, raddr, proto, s.option)
 		s.outId.Store(rand.Uint32())
 		s.inId.Store(rand.Uint32())
+
+		// validate options
+		b := header.IPv4(append([]byte{}, s.out...))
+		b.SetTotalLength(uint16(len(b)))
+		if len(b) > header.IPv4MaximumHeaderSize {
+			return nil, fmt.Errorf("ipv4 options length %d exceed %d", len(b)-header.IPv4MinimumSize, header.IPv4MaximumHeaderSize-header.IPv4MinimumSize)
+		} else if _, err := helper.CheckIP(b); err != nil {
+			return nil, fmt.Errorf("invalid ipv4 options: %w", err)
+		}
 	} else {
 		s.network = header.IPv6ProtocolNumber
-		s.in, s.psoSum1 = initHdr6(raddr, laddr, proto)
-		s.out, s.psoSum1 = initHdr6(laddr, raddr, proto)
+		s.in, _ = initHdr6(raddr, laddr, proto, &Configs{})
+		s.out, s.psoSum1 = initHdr6(laddr, raddr, proto, s.option)
 	}
 	return s, nil
 }
 
-func initHdr(src, dst netip.Addr, proto tcpip.TransportProtocolNumber) ([]byte, uint16) {
+func initHdr(src, dst netip.Addr, proto tcpip.TransportProtocolNumber, opt *Configs) ([]byte, uint16) {
 	f := &header.IPv4Fields{
-		TOS:            0,
+		TOS:            opt.trafficClass(),
 		TotalLength:    0, // dynamic
 		ID:             0, // dynamic
 		Flags:          0,
@@ -72,34 +92,47 @@ func initHdr(src, dst netip.Addr, proto tcpip.TransportProtocolNumber) ([]byte,
 		DstAddr:        tcpip.AddrFrom4(dst.As4()),
 		Options:        nil,
 	}
+	if opt.ttl != 0 {
+		f.TTL = opt.ttl
+	}
+	if opt.df {
+		f.Flags = header.IPv4FlagDontFragment
+	}
 
-	b := header.IPv4(make([]byte, header.IPv4MinimumSize))
+	// padding options with EOL
+	n := header.IPv4MinimumSize + (len(opt.ipv4Options)+3)&^3
+	b := header.IPv4(make([]byte, n))
 	b.Encode(f)
+	b.SetHeaderLength(uint8(min(n, header.IPv4MaximumHeaderSize)))
+	copy(b[header.IPv4MinimumSize:], opt.ipv4Options)
 	return []byte(b), header.PseudoHeaderChecksum(proto, f.SrcAddr, f.DstAddr, 0)
 }
 
-func initHdr6(src, dst netip.Addr, proto tcpip.TransportProtocolNumber) ([]byte, uint16) {
+func initHdr6(src, dst netip.Addr, proto tcpip.TransportProtocolNumber, opt *Configs) ([]byte, uint16) {
 	f := &header.IPv6Fields{
-		TrafficClass:      0,
-		FlowLabel:         0,
+		TrafficClass:      opt.trafficClass(),
+		FlowLabel:         opt.flowLabel,
 		PayloadLength:     0, // dynamic
 		TransportProtocol: proto,
 		HopLimit:          128,
 		SrcAddr:           tcpip.AddrFrom16(src.As16()),
 		DstAddr:           tcpip.AddrFrom16(dst.As16()),
 	}
+	if opt.ttl != 0 {
+		f.HopLimit = opt.ttl
+	}
 
 	b := header.IPv6(make([]byte, header.IPv6MinimumSize))
 	b.Encode(f)
+	if opt.hashFlowLabel {
+		b.SetTOS(f.TrafficClass, flowLabel(b))
+	}
 	return []byte(b), header.PseudoHeaderChecksum(proto, f.SrcAddr, f.DstAddr, 0)
 }
 
+// Size ip header size, include ipv4 options
 func (i *IPStack) Size() int {
-	if i.network == header.IPv4ProtocolNumber {
-		return header.IPv4MinimumSize
-	} else {
-		return header.IPv6MinimumSize
-	}
+	return len(i.out)
 }
 
 func (i *IPStack) IPv4() bool {
@@ -108,7 +141,7 @@ func (i *IPStack) IPv4() bool {
 
 func (i *IPStack) AttachInbound(pkt *packet.Packet) {
 	pkt.Attach(i.in...)
-	i.calcTransportChecksum(pkt.Bytes())
+	i.calcTransportChecksum(pkt.Bytes(), false)
 }
 
 func (i *IPStack) UpdateInbound(ip header.IPv4) {
@@ -128,7 +161,7 @@ func (i *IPStack) UpdateInbound(ip header.IPv4) {
 // AttachOutbound attach a ip header for outbound address
 func (i *IPStack) AttachOutbound(pkt *packet.Packet) {
 	pkt.Attach(i.out...)
-	i.calcTransportChecksum(pkt.Bytes())
+	i.calcTransportChecksum(pkt.Bytes(), i.Offload())
 }
 
 // UpdateOutbound update outbound ip id field
@@ -146,8 +179,23 @@ func (i *IPStack) UpdateOutbound(ip header.IPv4) {
 	}
 }
 
-func (i *IPStack) calcTransportChecksum(ip []byte) {
+// Offload return whether outbound tcp/udp checksum is partial, see OffloadChecksum
+func (i *IPStack) Offload() bool {
+	return i.option.offload && i.option.checksum == reCalcChecksum &&
+		(i.transport == header.TCPProtocolNumber || i.transport == header.UDPProtocolNumber)
+}
+
+func (i *IPStack) calcTransportChecksum(ip []byte, partial bool) {
 	psosum, p := i.checksum(ip)
+	if partial {
+		// not complemented pseudo header checksum
+		if i.transport == header.TCPProtocolNumber {
+			header.TCP(p).SetChecksum(psosum)
+		} else {
+			header.UDP(p).SetChecksum(psosum)
+		}
+		return
+	}
 
 	switch i.transport {
 	case header.TCPProtocolNumber:
@@ -180,6 +228,18 @@ func (i *IPStack) calcTransportChecksum(ip []byte) {
 			panic("")
 		}
 		udphdr.SetChecksum(^checksum.Combine(psosum, sum))
+	case header.ICMPv4ProtocolNumber:
+		// icmp checksum without pseudo header
+		icmphdr := header.ICMPv4(p)
+		switch i.option.checksum {
+		case updateChecksumWithoutPseudo:
+		case reCalcChecksum:
+			icmphdr.SetChecksum(0)
+			icmphdr.SetChecksum(^checksum.Checksum(icmphdr, 0))
+		case notCalcChecksum:
+		default:
+			panic("")
+		}
 	}
 }
 
@@ -216,3 +276,19 @@ func (i *IPStack) checksum(ip []byte) (psosum uint16, transport []byte) {
 		return psosum, iphdr.Payload()
 	}
 }
+
+// flowLabel hash ipv6 flow label by addresses and next header, by FNV-1a
+func flowLabel(ip header.IPv6) uint32 {
+	const prime = 16777619
+	var h uint32 = 2166136261
+	for _, b := range ip[8:header.IPv6MinimumSize] {
+		h = (h ^ uint32(b)) * prime
+	}
+	h = (h ^ uint32(ip[6])) * prime
+
+	// zero means not set flow label
+	if label := (h ^ h>>20) & 0xfffff; label != 0 {
+		return label
+	}
+	return 1
+}
@@ -7,8 +7,10 @@ import (
 
 	"github.com/lysShub/netkit/packet"
 	"github.com/lysShub/rawsock/helper/ipstack"
+	"github.com/lysShub/rawsock/helper/vnet"
 	"github.com/lysShub/rawsock/test"
 	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/checksum"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 )
@@ -155,3 +157,178 @@ func Test_IP_Stack_UDP(t *testing.T) {
 	}
 
 }
+
+func Test_IP_Stack_Offload(t *testing.T) {
+	for _, suit := range suits {
+		for _, proto := range []tcpip.TransportProtocolNumber{header.TCPProtocolNumber, header.UDPProtocolNumber} {
+			s, err := ipstack.New(suit.src, suit.dst, proto, ipstack.OffloadChecksum)
+			require.NoError(t, err)
+			require.True(t, s.Offload())
+
+			var b []byte
+			if proto == header.TCPProtocolNumber {
+				b = test.BuildTCPSync(t, netip.AddrPortFrom(suit.src, 1234), netip.AddrPortFrom(suit.dst, 80))
+			} else {
+				b = make(header.UDP, header.UDPMinimumSize+rand.Intn(64))
+				header.UDP(b).Encode(&header.UDPFields{SrcPort: 1234, DstPort: 53, Length: uint16(len(b))})
+			}
+			ip := packet.Make(header.IPv6FixedHeaderSize, 0, len(b)).Append(b...)
+			s.AttachOutbound(ip)
+
+			// complete checksum as nic
+			h := vnet.Partial(ip.Bytes(), 0)
+			require.Equal(t, uint8(vnet.FlagNeedsCsum), h.Flags)
+			p := ip.Bytes()[h.CsumStart:]
+			sum := ^checksum.Checksum(p, 0)
+			p[h.CsumOffset], p[h.CsumOffset+1] = byte(sum>>8), byte(sum)
+			test.ValidIP(t, ip.Bytes())
+
+			// inbound not offload
+			ip = packet.Make(header.IPv6FixedHeaderSize, 0, len(b)).Append(b...)
+			s.AttachInbound(ip)
+			test.ValidIP(t, ip.Bytes())
+		}
+	}
+
+	s, err := ipstack.New(suits[0].src, suits[0].dst, header.TCPProtocolNumber, ipstack.OffloadChecksum, ipstack.NotCalcChecksum)
+	require.NoError(t, err)
+	require.False(t, s.Offload())
+}
+
+func Test_IP_Stack_Header(t *testing.T) {
+	var (
+		src4 = netip.MustParseAddrPort("10.0.0.1:1234")
+		dst4 = netip.MustParseAddrPort("10.0.0.2:80")
+		src6 = netip.MustParseAddrPort("[fd00::1]:1234")
+		dst6 = netip.MustParseAddrPort("[fd00::2]:80")
+	)
+	attach := func(t *testing.T, s *ipstack.IPStack, src, dst netip.AddrPort) []byte {
+		b := test.BuildTCPSync(t, src, dst)
+		ip := packet.Make(s.Size(), 0, len(b)).Append(b...)
+		s.AttachOutbound(ip)
+		test.ValidIP(t, ip.Bytes())
+		return ip.Bytes()
+	}
+
+	t.Run("ipv4", func(t *testing.T) {
+		s, err := ipstack.New(src4.Addr(), dst4.Addr(), header.TCPProtocolNumber,
+			ipstack.TTL(32), ipstack.TOS(0b11), ipstack.DSCP(46), ipstack.DontFragment,
+			ipstack.IPv4Options([]byte{148, 4, 0, 0, 1}),
+		)
+		require.NoError(t, err)
+		require.Equal(t, header.IPv4MinimumSize+8, s.Size())
+
+		ip := header.IPv4(attach(t, s, src4, dst4))
+		require.Equal(t, uint8(32), ip.TTL())
+		tos, _ := ip.TOS()
+		require.Equal(t, uint8(46<<2|0b11), tos)
+		require.Equal(t, uint8(header.IPv4FlagDontFragment), ip.Flags())
+		require.Equal(t, []byte{148, 4, 0, 0, 1, 0, 0, 0}, []byte(ip.Options()))
+	})
+
+	t.Run("ipv4-dscp-before-tos", func(t *testing.T) {
+		s, err := ipstack.New(src4.Addr(), dst4.Addr(), header.TCPProtocolNumber,
+			ipstack.DSCP(46), ipstack.TOS(0b11),
+		)
+		require.NoError(t, err)
+
+		tos, _ := header.IPv4(attach(t, s, src4, dst4)).TOS()
+		require.Equal(t, uint8(46<<2|0b11), tos)
+	})
+
+	t.Run("ipv4-inbound", func(t *testing.T) {
+		s, err := ipstack.New(src4.Addr(), dst4.Addr(), header.TCPProtocolNumber,
+			ipstack.TTL(32), ipstack.DSCP(46), ipstack.DontFragment,
+			ipstack.IPv4Options([]byte{148, 4, 0, 0}),
+		)
+		require.NoError(t, err)
+
+		b := test.BuildTCPSync(t, dst4, src4)
+		pkt := packet.Make(s.Size(), 0, len(b)).Append(b...)
+		s.AttachInbound(pkt)
+		test.ValidIP(t, pkt.Bytes())
+
+		ip := header.IPv4(pkt.Bytes())
+		require.Equal(t, s.Size(), int(ip.HeaderLength()))
+		require.Equal(t, uint8(64), ip.TTL())
+		tos, _ := ip.TOS()
+		require.Zero(t, tos)
+		require.Zero(t, ip.Flags())
+		require.Equal(t, []byte{0, 0, 0, 0}, []byte(ip.Options()))
+	})
+
+	t.Run("ipv4-invalid-options", func(t *testing.T) {
+		_, err := ipstack.New(src4.Addr(), dst4.Addr(), header.TCPProtocolNumber,
+			ipstack.IPv4Options([]byte{148, 8, 0, 0}),
+		)
+		require.Error(t, err)
+
+		_, err = ipstack.New(src4.Addr(), dst4.Addr(), header.TCPProtocolNumber,
+			ipstack.IPv4Options(make([]byte, 44)),
+		)
+		require.Error(t, err)
+	})
+
+	t.Run("ipv6", func(t *testing.T) {
+		s, err := ipstack.New(src6.Addr(), dst6.Addr(), header.TCPProtocolNumber,
+			ipstack.TTL(16), ipstack.DSCP(10), ipstack.FlowLabel(0x12345),
+		)
+		require.NoError(t, err)
+		require.Equal(t, header.IPv6MinimumSize, s.Size())
+
+		ip := header.IPv6(attach(t, s, src6, dst6))
+		require.Equal(t, uint8(16), ip.HopLimit())
+		tc, label := ip.TOS()
+		require.Equal(t, uint8(10<<2), tc)
+		require.Equal(t, uint32(0x12345), label)
+	})
+
+	t.Run("ipv6-hash-flow-label", func(t *testing.T) {
+		s, err := ipstack.New(src6.Addr(), dst6.Addr(), header.TCPProtocolNumber, ipstack.HashFlowLabel)
+		require.NoError(t, err)
+
+		_, label1 := header.IPv6(attach(t, s, src6, dst6)).TOS()
+		_, label2 := header.IPv6(attach(t, s, src6, dst6)).TOS()
+		require.NotZero(t, label1)
+		require.Equal(t, label1, label2)
+
+		src := netip.AddrPortFrom(netip.MustParseAddr("fd00::3"), src6.Port())
+		s, err = ipstack.New(src.Addr(), dst6.Addr(), header.TCPProtocolNumber, ipstack.HashFlowLabel)
+		require.NoError(t, err)
+		_, label3 := header.IPv6(attach(t, s, src, dst6)).TOS()
+		require.NotEqual(t, label1, label3)
+	})
+}
+
+func Test_IP_Stack_ICMP(t *testing.T) {
+	var (
+		src = netip.MustParseAddr("127.0.0.1")
+		dst = netip.MustParseAddr("8.8.8.8")
+	)
+
+	t.Run("ipv6", func(t *testing.T) {
+		_, err := ipstack.New(
+			netip.MustParseAddr("3ffe:ffff:fe00:0001:0000:0000:0000:0001"),
+			netip.MustParseAddr("2001:0db8:85a3:0000:0000:8a2e:0370:7334"),
+			header.ICMPv4ProtocolNumber,
+		)
+		require.Error(t, err)
+	})
+
+	s, err := ipstack.New(src, dst, header.ICMPv4ProtocolNumber, ipstack.ReCalcChecksum)
+	require.NoError(t, err)
+
+	var icmp = header.ICMPv4(make([]byte, header.ICMPv4MinimumSize+16))
+	icmp.SetType(header.ICMPv4Echo)
+	icmp.SetIdent(uint16(rand.Uint32()))
+	icmp.SetSequence(uint16(rand.Uint32()))
+
+	ip := packet.Make(header.IPv4MinimumSize, 0, len(icmp)).Append(icmp...)
+	s.AttachOutbound(ip)
+
+	test.ValidIP(t, ip.Bytes())
+	iphdr := header.IPv4(ip.Bytes())
+	require.Equal(t, src.String(), iphdr.SourceAddress().String())
+	require.Equal(t, dst.String(), iphdr.DestinationAddress().String())
+	require.Equal(t, header.ICMPv4ProtocolNumber, iphdr.TransportProtocol())
+}
@@ -29,21 +29,108 @@ func NotCalcChecksum(o *Configs) {
 	o.checksum = notCalcChecksum
 }
 
+// OffloadChecksum outbound tcp/udp checksum only set pseudo header checksum,
+// the rest is completed by nic checksum offload (CHECKSUM_PARTIAL). it's set
+// by backend that support offload, only take effect with ReCalcChecksum.
+func OffloadChecksum(o *Configs) {
+	o.offload = true
+}
+
 // NotCalcIPChecksum not set ip4 checksum
 func NotCalcIPChecksum(o *Configs) {
 	o.calcIPChecksum = false
 }
 
+// TTL set ipv4 TTL or ipv6 hop limit, default 64 for ipv4 and 128 for ipv6
+func TTL(ttl uint8) Option {
+	return func(o *Configs) {
+		o.ttl = ttl
+	}
+}
+
+// TOS set ipv4 TOS or ipv6 traffic class, include DSCP and ECN, the DSCP
+// bits are replaced by DSCP option if set, regardless of order.
+func TOS(tos uint8) Option {
+	return func(o *Configs) {
+		o.tos = tos
+	}
+}
+
+// DSCP set DSCP of ipv4 TOS or ipv6 traffic class, ECN is set by TOS option.
+func DSCP(dscp uint8) Option {
+	return func(o *Configs) {
+		o.dscp = dscp & 0b111111
+		o.setDSCP = true
+	}
+}
+
+// DontFragment set ipv4 DF flag
+func DontFragment(o *Configs) {
+	o.df = true
+}
+
+// FlowLabel set fixed ipv6 flow label, only low 20 bits is used
+func FlowLabel(label uint32) Option {
+	return func(o *Configs) {
+		o.flowLabel = label & 0xfffff
+		o.hashFlowLabel = false
+	}
+}
+
+// HashFlowLabel set ipv6 flow label by hash of addresses and protocol, it's
+// calculated once by ipstack.New.
+func HashFlowLabel(o *Configs) {
+	o.flowLabel = 0
+	o.hashFlowLabel = true
+}
+
+// IPv4Options set ipv4 options, padded to multiple of 4 bytes with EOL,
+// ipstack.New return error if options is invalid or exceed 40 bytes. inbound
+// ip header is padded with EOL to the same length.
+func IPv4Options(opts []byte) Option {
+	return func(o *Configs) {
+		o.ipv4Options = append([]byte{}, opts...)
+	}
+}
+
 type Configs struct {
 	calcIPChecksum bool
 	checksum       uint8
+	offload        bool
+
+	ttl           uint8 // zero use default
+	tos           uint8
+	dscp          uint8
+	setDSCP       bool
+	df            bool
+	flowLabel     uint32
+	hashFlowLabel bool
+	ipv4Options   []byte
 }
 
 func (os Configs) Unmarshal() Option {
 	return func(o *Configs) {
 		o.calcIPChecksum = os.calcIPChecksum
 		o.checksum = os.checksum
+		o.offload = os.offload
+
+		o.ttl = os.ttl
+		o.tos = os.tos
+		o.dscp = os.dscp
+		o.setDSCP = os.setDSCP
+		o.df = os.df
+		o.flowLabel = os.flowLabel
+		o.hashFlowLabel = os.hashFlowLabel
+		o.ipv4Options = os.ipv4Options
+	}
+}
+
+// trafficClass return ipv4 TOS or ipv6 traffic class
+func (o *Configs) trafficClass() uint8 {
+	if o.setDSCP {
+		return o.dscp<<2 | o.tos&0b11
 	}
+	return o.tos
 }
 
 const (
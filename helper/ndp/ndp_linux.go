@@ -0,0 +1,129 @@
+//go:build linux
+// +build linux
+
+// Package ndp resolve ipv6 neighbor hardware address by NDP, like mdlayher/arp for ipv4.
+package ndp
+
+import (
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+type Client struct {
+	ifi  *net.Interface
+	conn *icmp.PacketConn
+	ipv6 *ipv6.PacketConn
+}
+
+// Dial create a NDP client on the nic
+func Dial(ifi *net.Interface) (*Client, error) {
+	if len(ifi.HardwareAddr) != header.EthernetAddressSize {
+		return nil, errors.Errorf("invalid interface %s hardware address %s", ifi.Name, ifi.HardwareAddr)
+	}
+
+	conn, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var c = &Client{ifi: ifi, conn: conn, ipv6: conn.IPv6PacketConn()}
+
+	// NDP message must be sent with hop limit 255, RFC4861 7.1
+	if err := c.ipv6.SetMulticastHopLimit(255); err != nil {
+		return nil, c.close(err)
+	}
+	if err := c.ipv6.SetHopLimit(255); err != nil {
+		return nil, c.close(err)
+	}
+	if err := c.ipv6.SetMulticastInterface(ifi); err != nil {
+		return nil, c.close(err)
+	}
+	if err := c.ipv6.SetControlMessage(ipv6.FlagHopLimit|ipv6.FlagInterface, true); err != nil {
+		return nil, c.close(err)
+	}
+	var f ipv6.ICMPFilter
+	f.SetAll(true)
+	f.Accept(ipv6.ICMPTypeNeighborAdvertisement)
+	if err := c.ipv6.SetICMPFilter(&f); err != nil {
+		return nil, c.close(err)
+	}
+	return c, nil
+}
+
+func (c *Client) close(cause error) error {
+	if err := c.conn.Close(); err != nil && cause == nil {
+		cause = err
+	}
+	return errors.WithStack(cause)
+}
+
+// Resolve send Neighbor Solicitation and wait Neighbor Advertisement, until
+// get hardware address of addr or deadline exceeded.
+func (c *Client) Resolve(addr netip.Addr) (net.HardwareAddr, error) {
+	if !addr.Is6() || addr.Is4In6() {
+		return nil, errors.Errorf("invalid ipv6 address %s", addr)
+	}
+	target := tcpip.AddrFrom16(addr.As16())
+
+	var b = make([]byte, header.ICMPv6NeighborSolicitMinimumSize+header.NDPLinkLayerAddressSize)
+	ns := header.ICMPv6(b)
+	ns.SetType(header.ICMPv6NeighborSolicit)
+	header.NDPNeighborSolicit(ns.MessageBody()).SetTargetAddress(target)
+	header.NDPNeighborSolicit(ns.MessageBody()).Options().Serialize(header.NDPOptionsSerializer{
+		header.NDPSourceLinkLayerAddressOption(tcpip.LinkAddress(c.ifi.HardwareAddr)),
+	})
+
+	// checksum calculated by kernel
+	dst := header.SolicitedNodeAddr(target)
+	if _, err := c.ipv6.WriteTo(b, nil, &net.IPAddr{IP: dst.AsSlice(), Zone: c.ifi.Name}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	b = make([]byte, header.IPv6MinimumMTU)
+	for {
+		n, cm, _, err := c.ipv6.ReadFrom(b)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		} else if n < header.ICMPv6NeighborAdvertMinimumSize {
+			continue
+		} else if cm != nil && (cm.HopLimit != 255 || cm.IfIndex != c.ifi.Index) {
+			continue
+		}
+
+		msg := header.ICMPv6(b[:n])
+		if msg.Type() != header.ICMPv6NeighborAdvert {
+			continue
+		}
+		na := header.NDPNeighborAdvert(msg.MessageBody())
+		if na.TargetAddress() != target {
+			continue
+		}
+
+		it, err := na.Options().Iter(true)
+		if err != nil {
+			continue
+		}
+		for {
+			opt, done, err := it.Next()
+			if err != nil || done {
+				break
+			}
+			if hw, ok := opt.(header.NDPTargetLinkLayerAddressOption); ok {
+				if addr := hw.EthernetAddress(); len(addr) > 0 {
+					return net.HardwareAddr(addr), nil
+				}
+			}
+		}
+	}
+}
+
+func (c *Client) SetDeadline(t time.Time) error      { return c.conn.SetDeadline(t) }
+func (c *Client) SetReadDeadline(t time.Time) error  { return c.conn.SetReadDeadline(t) }
+func (c *Client) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
+func (c *Client) Close() error                       { return c.close(nil) }
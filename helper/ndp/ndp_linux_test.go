@@ -0,0 +1,47 @@
+//go:build linux
+// +build linux
+
+package ndp_test
+
+import (
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lysShub/rawsock/helper/ndp"
+	"github.com/lysShub/rawsock/test"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Resolve(t *testing.T) {
+	var (
+		local = netip.MustParsePrefix("fd01:9a6::1/64")
+		peer  = netip.MustParsePrefix("fd01:9a6::2/64")
+	)
+	v := test.CreateVeth(t, "rawsock-ndp", []netip.Prefix{local}, []netip.Prefix{peer})
+	ifi, hw := v.Ifi, v.PeerHW
+
+	c, err := ndp.Dial(ifi)
+	require.NoError(t, err)
+	defer c.Close()
+
+	t.Run("resolve", func(t *testing.T) {
+		require.NoError(t, c.SetDeadline(time.Now().Add(time.Second*3)))
+		got, err := c.Resolve(peer.Addr())
+		require.NoError(t, err)
+		require.Equal(t, hw, got)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		require.NoError(t, c.SetDeadline(time.Now().Add(time.Millisecond*500)))
+		_, err := c.Resolve(netip.MustParseAddr("fd01:9a6::3"))
+		require.True(t, errors.Is(err, os.ErrDeadlineExceeded), err)
+	})
+
+	t.Run("ipv4", func(t *testing.T) {
+		_, err := c.Resolve(netip.MustParseAddr("192.0.2.1"))
+		require.Error(t, err)
+	})
+}
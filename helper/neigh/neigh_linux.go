@@ -0,0 +1,302 @@
+//go:build linux
+// +build linux
+
+// Package neigh resolve and tracking neighbor hardware address, query kernel
+// neighbor table first, then resolve by ARP(ipv4) or NDP(ipv6).
+package neigh
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/lysShub/rawsock/helper/ndp"
+	"github.com/mdlayher/arp"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+type Config struct {
+	Timeout time.Duration // timeout of once ARP/NDP resolve
+	Retry   int           // retry times after resolve timeout
+	Expiry  time.Duration // expiry of cached entry
+}
+
+func (c *Config) init() *Config {
+	var cfg = Config{Timeout: time.Second * 3, Expiry: time.Second * 30}
+	if c != nil {
+		if c.Timeout > 0 {
+			cfg.Timeout = c.Timeout
+		}
+		if c.Retry > 0 {
+			cfg.Retry = c.Retry
+		}
+		if c.Expiry > 0 {
+			cfg.Expiry = c.Expiry
+		}
+	}
+	return &cfg
+}
+
+type key struct {
+	ifidx int
+	addr  netip.Addr
+}
+
+type entry struct {
+	hw     net.HardwareAddr
+	expire time.Time
+}
+
+var cache = struct {
+	sync.RWMutex
+	entries map[key]entry
+}{
+	entries: map[key]entry{},
+}
+
+// Resolve get neighbor hardware address, from cache, kernel neighbor table, or
+// resolve by ARP/NDP.
+func Resolve(ifi *net.Interface, addr netip.Addr, cfg *Config) (net.HardwareAddr, error) {
+	cfg = cfg.init()
+	k := key{ifidx: ifi.Index, addr: addr.Unmap()}
+
+	cache.RLock()
+	e, has := cache.entries[k]
+	cache.RUnlock()
+	if has && time.Now().Before(e.expire) {
+		return e.hw, nil
+	}
+	return resolve(ifi, k.addr, cfg)
+}
+
+func resolve(ifi *net.Interface, addr netip.Addr, cfg *Config) (hw net.HardwareAddr, err error) {
+	hw, err = Lookup(ifi.Index, addr)
+	if err != nil {
+		return nil, err
+	} else if hw == nil {
+		for i := 0; i <= cfg.Retry; i++ {
+			hw, err = query(ifi, addr, cfg.Timeout)
+			if err == nil || !errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cache.Lock()
+	cache.entries[key{ifidx: ifi.Index, addr: addr}] = entry{hw: hw, expire: time.Now().Add(cfg.Expiry)}
+	cache.Unlock()
+	return hw, nil
+}
+
+// query resolve by ARP for ipv4, by NDP for ipv6
+func query(ifi *net.Interface, addr netip.Addr, timeout time.Duration) (net.HardwareAddr, error) {
+	deadline := time.Now().Add(timeout)
+	if addr.Is4() {
+		client, err := arp.Dial(ifi)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		defer client.Close()
+		if err = client.SetDeadline(deadline); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		hw, err := client.Resolve(addr)
+		return hw, errors.WithStack(err)
+	} else {
+		client, err := ndp.Dial(ifi)
+		if err != nil {
+			return nil, err
+		}
+		defer client.Close()
+		if err = client.SetDeadline(deadline); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return client.Resolve(addr)
+	}
+}
+
+// Lookup get confirmed neighbor hardware address from kernel neighbor table by
+// netlink, return nil if not found.
+func Lookup(ifidx int, addr netip.Addr) (net.HardwareAddr, error) {
+	addr = addr.Unmap()
+	var family = unix.AF_INET
+	if addr.Is6() {
+		family = unix.AF_INET6
+	}
+
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var req = make([]byte, unix.NLMSG_HDRLEN+unix.SizeofNdMsg)
+	*(*unix.NlMsghdr)(unsafe.Pointer(&req[0])) = unix.NlMsghdr{
+		Len:   uint32(len(req)),
+		Type:  unix.RTM_GETNEIGH,
+		Flags: unix.NLM_F_REQUEST | unix.NLM_F_DUMP,
+		Seq:   1,
+	}
+	*(*unix.NdMsg)(unsafe.Pointer(&req[unix.NLMSG_HDRLEN])) = unix.NdMsg{
+		Family:  uint8(family),
+		Ifindex: int32(ifidx),
+	}
+	if err := unix.Sendto(fd, req, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var hw net.HardwareAddr
+	var b = make([]byte, unix.Getpagesize()*4)
+	for {
+		n, _, err := unix.Recvfrom(fd, b, 0)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		msgs, err := parseMessage(b[:n])
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			switch m.hdr.Type {
+			case unix.RTM_NEWNEIGH:
+				if hw != nil || len(m.data) < unix.SizeofNdMsg {
+					continue
+				}
+				nd := (*unix.NdMsg)(unsafe.Pointer(unsafe.SliceData(m.data)))
+				const valid = unix.NUD_REACHABLE | unix.NUD_PERMANENT | unix.NUD_NOARP
+				if int(nd.Ifindex) != ifidx || nd.State&valid == 0 {
+					continue
+				}
+
+				var dst netip.Addr
+				var lladdr net.HardwareAddr
+				for _, attr := range parseAttrs(m.data[unix.SizeofNdMsg:]) {
+					switch attr.typ {
+					case unix.NDA_DST:
+						dst, _ = netip.AddrFromSlice(attr.value)
+					case unix.NDA_LLADDR:
+						lladdr = append(net.HardwareAddr{}, attr.value...)
+					}
+				}
+				if dst.Unmap() == addr && len(lladdr) > 0 {
+					hw = lladdr
+				}
+			case unix.NLMSG_DONE:
+				return hw, nil
+			case unix.NLMSG_ERROR:
+				e := (*unix.NlMsgerr)(unsafe.Pointer(unsafe.SliceData(m.data)))
+				return nil, errors.WithStack(unix.Errno(-e.Error))
+			}
+		}
+	}
+}
+
+type message struct {
+	hdr  unix.NlMsghdr
+	data []byte
+}
+
+func parseMessage(b []byte) (msgs []message, err error) {
+	for len(b) >= unix.NLMSG_HDRLEN {
+		hdr := *(*unix.NlMsghdr)(unsafe.Pointer(&b[0]))
+		if int(hdr.Len) < unix.NLMSG_HDRLEN || int(hdr.Len) > len(b) {
+			return nil, errors.Errorf("invalid netlink message length %d", hdr.Len)
+		}
+		msgs = append(msgs, message{hdr: hdr, data: b[unix.NLMSG_HDRLEN:hdr.Len]})
+		b = b[min(nlmAlign(int(hdr.Len)), len(b)):]
+	}
+	return msgs, nil
+}
+
+type attr struct {
+	typ   uint16
+	value []byte
+}
+
+func parseAttrs(b []byte) (attrs []attr) {
+	for len(b) >= unix.SizeofRtAttr {
+		a := *(*unix.RtAttr)(unsafe.Pointer(&b[0]))
+		if int(a.Len) < unix.SizeofRtAttr || int(a.Len) > len(b) {
+			break
+		}
+		attrs = append(attrs, attr{typ: a.Type, value: b[unix.SizeofRtAttr:a.Len]})
+		b = b[min(nlmAlign(int(a.Len)), len(b)):]
+	}
+	return attrs
+}
+
+func nlmAlign(n int) int { return (n + unix.NLMSG_ALIGNTO - 1) &^ (unix.NLMSG_ALIGNTO - 1) }
+
+// Neigh tracking hardware address of a neighbor, re-resolve it in background
+// when cached entry expired, such as the gateway changed after router failover.
+type Neigh struct {
+	ifi  *net.Interface
+	addr netip.Addr
+	cfg  *Config
+
+	hw atomic.Pointer[net.HardwareAddr]
+
+	closed chan struct{}
+	once   sync.Once
+}
+
+func Track(ifi *net.Interface, addr netip.Addr, cfg *Config) (*Neigh, error) {
+	var n = &Neigh{
+		ifi:    ifi,
+		addr:   addr.Unmap(),
+		cfg:    cfg.init(),
+		closed: make(chan struct{}),
+	}
+
+	hw, err := Resolve(ifi, addr, n.cfg)
+	if err != nil {
+		return nil, err
+	}
+	n.hw.Store(&hw)
+
+	go n.refreshService()
+	return n, nil
+}
+
+// Static a neighbor with fixed hardware address, such as loopback nic
+func Static(hw net.HardwareAddr) *Neigh {
+	var n = &Neigh{closed: make(chan struct{})}
+	n.hw.Store(&hw)
+	return n
+}
+
+func (n *Neigh) refreshService() {
+	var ticker = time.NewTicker(n.cfg.Expiry)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.closed:
+			return
+		case <-ticker.C:
+		}
+
+		// keep old hardware address if re-resolve failed
+		if hw, err := resolve(n.ifi, n.addr, n.cfg); err == nil {
+			n.hw.Store(&hw)
+		}
+	}
+}
+
+// HardwareAddr get the latest resolved hardware address
+func (n *Neigh) HardwareAddr() net.HardwareAddr { return *n.hw.Load() }
+
+func (n *Neigh) Close() error {
+	n.once.Do(func() { close(n.closed) })
+	return nil
+}
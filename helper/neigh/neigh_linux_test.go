@@ -0,0 +1,86 @@
+//go:build linux
+// +build linux
+
+package neigh_test
+
+import (
+	"net/netip"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/lysShub/rawsock/helper/neigh"
+	"github.com/lysShub/rawsock/test"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	local4 = netip.MustParsePrefix("10.99.7.1/24")
+	peer4  = netip.MustParsePrefix("10.99.7.2/24")
+	local6 = netip.MustParsePrefix("fd01:9a8::1/64")
+	peer6  = netip.MustParsePrefix("fd01:9a8::2/64")
+)
+
+func ip(t *testing.T, args ...string) {
+	if out, err := exec.Command("ip", args...).CombinedOutput(); err != nil {
+		t.Skipf("ip %v: %s", args, out)
+	}
+}
+
+func Test_Neigh(t *testing.T) {
+	v := test.CreateVeth(t, "rawsock-neigh", []netip.Prefix{local4, local6}, []netip.Prefix{peer4, peer6})
+	ifi, hw := v.Ifi, v.PeerHW
+
+	t.Run("resolve", func(t *testing.T) {
+		for _, addr := range []netip.Addr{peer4.Addr(), peer6.Addr()} {
+			got, err := neigh.Resolve(ifi, addr, nil)
+			require.NoError(t, err)
+			require.Equal(t, hw, got)
+		}
+	})
+
+	t.Run("lookup", func(t *testing.T) {
+		var (
+			addr = netip.MustParseAddr("10.99.7.3")
+			mac  = "02:00:00:00:07:03"
+		)
+		hw, err := neigh.Lookup(ifi.Index, addr)
+		require.NoError(t, err)
+		require.Nil(t, hw)
+
+		ip(t, "neigh", "replace", addr.String(), "lladdr", mac, "dev", v.Name, "nud", "permanent")
+		hw, err = neigh.Lookup(ifi.Index, addr)
+		require.NoError(t, err)
+		require.Equal(t, mac, hw.String())
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		var cfg = &neigh.Config{Timeout: time.Millisecond * 200, Retry: 2}
+
+		start := time.Now()
+		_, err := neigh.Resolve(ifi, netip.MustParseAddr("10.99.7.4"), cfg)
+		require.True(t, errors.Is(err, os.ErrDeadlineExceeded), err)
+		require.GreaterOrEqual(t, time.Since(start), time.Millisecond*600)
+	})
+
+	t.Run("track", func(t *testing.T) {
+		var (
+			addr       = netip.MustParseAddr("fd01:9a8::5")
+			mac1, mac2 = "02:00:00:00:07:05", "02:00:00:00:07:06"
+		)
+		ip(t, "neigh", "replace", addr.String(), "lladdr", mac1, "dev", v.Name, "nud", "permanent")
+
+		n, err := neigh.Track(ifi, addr, &neigh.Config{Expiry: time.Millisecond * 100})
+		require.NoError(t, err)
+		defer n.Close()
+		require.Equal(t, mac1, n.HardwareAddr().String())
+
+		// gateway changed
+		ip(t, "neigh", "replace", addr.String(), "lladdr", mac2, "dev", v.Name, "nud", "permanent")
+		require.Eventually(t, func() bool {
+			return n.HardwareAddr().String() == mac2
+		}, time.Second*2, time.Millisecond*50)
+	})
+}
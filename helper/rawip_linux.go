@@ -0,0 +1,51 @@
+//go:build linux
+// +build linux
+
+package helper
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/pkg/errors"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// ListenRawIP listen a IPPROTO_RAW socket, it implies IP_HDRINCL, so can
+// send ip packet with caller's ip header, it never recv packet.
+func ListenRawIP(laddr netip.Addr) (*net.IPConn, error) {
+	network := "ip4:255"
+	if laddr.Is6() && !laddr.Is4In6() {
+		network = "ip6:255"
+	}
+
+	conn, err := net.ListenIP(network, &net.IPAddr{IP: laddr.AsSlice(), Zone: laddr.Zone()})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return conn, nil
+}
+
+// WriteRawIP write ip packet by IPPROTO_RAW socket, route by the packet's
+// destination address.
+func WriteRawIP(conn *net.IPConn, ip []byte) error {
+	var dst tcpip.Address
+	switch header.IPVersion(ip) {
+	case 4:
+		if len(ip) < header.IPv4MinimumSize {
+			return errors.Errorf("invalid ipv4 packet, bytes %d", len(ip))
+		}
+		dst = header.IPv4(ip).DestinationAddress()
+	case 6:
+		if len(ip) < header.IPv6MinimumSize {
+			return errors.Errorf("invalid ipv6 packet, bytes %d", len(ip))
+		}
+		dst = header.IPv6(ip).DestinationAddress()
+	default:
+		return errors.New("invalid ip packet")
+	}
+
+	_, err := conn.WriteToIP(ip, &net.IPAddr{IP: dst.AsSlice()})
+	return errors.WithStack(err)
+}
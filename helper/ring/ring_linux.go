@@ -0,0 +1,654 @@
+//go:build linux
+// +build linux
+
+// Package ring implement AF_PACKET PACKET_MMAP ring, recv by TPACKET_V3
+// block-based RX ring, send by TPACKET_V2 TX ring.
+//
+// https://docs.kernel.org/networking/packet_mmap.html
+package ring
+
+import (
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/lysShub/netkit/errorx"
+	"github.com/lysShub/netkit/eth"
+	"github.com/lysShub/netkit/packet"
+	"github.com/lysShub/rawsock/helper/vnet"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+type Config struct {
+	// BlockSize ring block size, must be multiple of page size
+	BlockSize int
+	BlockNum  int
+
+	// FrameSize TX ring frame size, limit max size of sent packet
+	FrameSize int
+
+	// Timeout RX ring retire a not full block after timeout, millisecond precision
+	Timeout time.Duration
+
+	// VnetHdr enable PACKET_VNET_HDR on TX ring for checksum offload, ignored
+	// if not supported
+	VnetHdr bool
+
+	// GRO enable PACKET_VNET_HDR on RX ring, recv GRO super-packet and split
+	// it to wire-sized segments
+	GRO bool
+}
+
+func (c *Config) init() error {
+	if c.BlockSize == 0 {
+		c.BlockSize = 1 << 16
+		if c.GRO {
+			// hold max size super-packet
+			c.BlockSize = 1 << 17
+		}
+	}
+	if c.BlockNum == 0 {
+		c.BlockNum = 16
+	}
+	if c.FrameSize == 0 {
+		c.FrameSize = 2048
+	}
+	if c.Timeout == 0 {
+		c.Timeout = time.Millisecond
+	}
+
+	if c.BlockSize%os.Getpagesize() != 0 {
+		return errors.Errorf("block size %d must be multiple of page size", c.BlockSize)
+	} else if c.FrameSize%unix.TPACKET_ALIGNMENT != 0 || c.FrameSize <= txOffset {
+		return errors.Errorf("invalid frame size %d", c.FrameSize)
+	} else if c.BlockSize%c.FrameSize != 0 {
+		return errors.Errorf("block size %d must be multiple of frame size %d", c.BlockSize, c.FrameSize)
+	}
+	return nil
+}
+
+// Conn same as eth.ETHConn, but recv/send by PACKET_MMAP ring
+type Conn struct {
+	proto tcpip.NetworkProtocolNumber
+	ifi   *net.Interface
+
+	rx *rxRing
+	tx *txRing
+
+	closeErr errorx.CloseErr
+}
+
+func Listen(network string, ifi *net.Interface, cfg *Config) (*Conn, error) {
+	var c = &Conn{ifi: ifi}
+	switch network {
+	case "eth:ip", "eth:ip4":
+		c.proto = header.IPv4ProtocolNumber
+	case "eth:ip6":
+		c.proto = header.IPv6ProtocolNumber
+	default:
+		return nil, errors.Errorf("not support network %s", network)
+	}
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	if err := cfg.init(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if c.rx, err = newRxRing(c.proto, ifi, cfg); err != nil {
+		return nil, c.close(err)
+	}
+	if c.tx, err = newTxRing(c.proto, ifi, cfg); err != nil {
+		return nil, c.close(err)
+	}
+	return c, nil
+}
+
+func (c *Conn) close(cause error) error {
+	return c.closeErr.Close(func() (errs []error) {
+		errs = append(errs, cause)
+		if c.rx != nil {
+			errs = append(errs, c.rx.close())
+		}
+		if c.tx != nil {
+			errs = append(errs, c.tx.close())
+		}
+		return errs
+	})
+}
+
+// ReadFromETH read ip packet, same as eth.ETHConn, GRO super-packet is split
+// to segments if enable Config.GRO
+func (c *Conn) ReadFromETH(ip []byte) (n int, from net.HardwareAddr, err error) {
+	c.rx.mu.Lock()
+	defer c.rx.mu.Unlock()
+
+	hdr, data, err := c.rx.peek()
+	if err != nil {
+		return 0, nil, err
+	} else if len(ip) < len(data) {
+		return 0, nil, errorx.ShortBuff(len(data), len(ip))
+	}
+	n = copy(ip, data)
+	from = c.rx.from(hdr)
+	c.rx.skip()
+	return n, from, nil
+}
+
+// Next read ip packet without copy, the returned slice is reference of ring
+// memory or split segment, it's valid until next read.
+func (c *Conn) Next() (ip []byte, err error) {
+	c.rx.mu.Lock()
+	defer c.rx.mu.Unlock()
+
+	_, ip, err = c.rx.peek()
+	if err != nil {
+		return nil, err
+	}
+	c.rx.skip()
+	return ip, nil
+}
+
+// ReadBatch read ip packets, only block until recved first packet.
+func (c *Conn) ReadBatch(ips []*packet.Packet) (n int, err error) {
+	c.rx.mu.Lock()
+	defer c.rx.mu.Unlock()
+
+	for n < len(ips) {
+		if n > 0 && !c.rx.ready() {
+			break
+		}
+		_, data, err := c.rx.peek()
+		if err != nil {
+			return n, err
+		} else if ips[n].Data() < len(data) {
+			return n, errorx.ShortBuff(len(data), ips[n].Data())
+		}
+
+		ips[n].SetData(copy(ips[n].Bytes(), data))
+		c.rx.skip()
+		n++
+	}
+	return n, nil
+}
+
+// WriteToETH write ip packet, same as eth.ETHConn
+func (c *Conn) WriteToETH(ip []byte, hw net.HardwareAddr) (int, error) {
+	if _, err := c.tx.write([][]byte{ip}, c.sockaddr(hw), false); err != nil {
+		return 0, err
+	}
+	return len(ip), nil
+}
+
+// WriteBatch write ip packets by one syscall, return the number of packets written.
+func (c *Conn) WriteBatch(ips []*packet.Packet, hw net.HardwareAddr) (n int, err error) {
+	var bs = make([][]byte, 0, len(ips))
+	for _, e := range ips {
+		bs = append(bs, e.Bytes())
+	}
+	return c.tx.write(bs, c.sockaddr(hw), false)
+}
+
+// Offload return whether TX ring support checksum offload
+func (c *Conn) Offload() bool { return c.tx.vnet }
+
+// WriteToETHPartial write ip packet with partial tcp/udp checksum, the
+// checksum will be completed by nic, require Offload.
+func (c *Conn) WriteToETHPartial(ip []byte, hw net.HardwareAddr) (int, error) {
+	if _, err := c.tx.write([][]byte{ip}, c.sockaddr(hw), true); err != nil {
+		return 0, err
+	}
+	return len(ip), nil
+}
+
+// WriteBatchPartial same as WriteBatch, but packets with partial tcp/udp
+// checksum, require Offload.
+func (c *Conn) WriteBatchPartial(ips []*packet.Packet, hw net.HardwareAddr) (n int, err error) {
+	var bs = make([][]byte, 0, len(ips))
+	for _, e := range ips {
+		bs = append(bs, e.Bytes())
+	}
+	return c.tx.write(bs, c.sockaddr(hw), true)
+}
+
+func (c *Conn) sockaddr(hw net.HardwareAddr) *unix.SockaddrLinklayer {
+	sa := &unix.SockaddrLinklayer{
+		Protocol: eth.Htons(uint16(c.proto)),
+		Ifindex:  c.ifi.Index,
+		Halen:    uint8(len(hw)),
+	}
+	copy(sa.Addr[:], hw)
+	return sa
+}
+
+func (c *Conn) Close() error { return c.close(nil) }
+
+// SyscallConn return RX socket, used to set bpf filter
+func (c *Conn) SyscallConn() syscall.RawConn { return c.rx.raw }
+func (c *Conn) SetDeadline(t time.Time) error {
+	if err := c.rx.fd.SetDeadline(t); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(c.tx.fd.SetDeadline(t))
+}
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return errors.WithStack(c.rx.fd.SetReadDeadline(t))
+}
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return errors.WithStack(c.tx.fd.SetWriteDeadline(t))
+}
+func (c *Conn) Interface() *net.Interface { return c.ifi }
+
+// txOffset TPACKET_V2 TX frame's data offset, TPACKET_ALIGN(sizeof(tpacket2_hdr))
+const txOffset = (unix.SizeofTpacket2Hdr + unix.TPACKET_ALIGNMENT - 1) &^ (unix.TPACKET_ALIGNMENT - 1)
+
+// rx3Offset TPACKET_V3 RX frame's sockaddr_ll offset, TPACKET_ALIGN(sizeof(tpacket3_hdr))
+const rx3Offset = (unix.SizeofTpacket3Hdr + unix.TPACKET_ALIGNMENT - 1) &^ (unix.TPACKET_ALIGNMENT - 1)
+
+type rxRing struct {
+	fd  *os.File
+	raw syscall.RawConn
+	mem []byte
+
+	blockSize, blockNum int
+
+	// SOCK_RAW socket with PACKET_VNET_HDR, every frame start with
+	// virtio_net_hdr and ethernet header
+	vnet  bool
+	split vnet.Splitter
+	seg   []byte // current segment of super-packet, nil if not split
+	buf   []byte
+
+	mu     sync.Mutex
+	block  int    // current block index
+	inuse  bool   // current block is owned by user
+	remain uint32 // remain packets of current block
+	off    uint32 // next packet offset of current block
+}
+
+func newRxRing(proto tcpip.NetworkProtocolNumber, ifi *net.Interface, cfg *Config) (*rxRing, error) {
+	typ := unix.SOCK_DGRAM
+	if cfg.GRO {
+		typ = unix.SOCK_RAW
+	}
+	fd, err := unix.Socket(unix.AF_PACKET, typ, int(eth.Htons(proto)))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var r = &rxRing{blockSize: cfg.BlockSize, blockNum: cfg.BlockNum}
+
+	if cfg.GRO {
+		// must set before ring created
+		if err = unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_VNET_HDR, 1); err != nil {
+			unix.Close(fd)
+			return nil, errors.WithStack(err)
+		}
+		r.vnet, r.buf = true, make([]byte, 0xffff)
+	}
+
+	if err = unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_VERSION, unix.TPACKET_V3); err != nil {
+		unix.Close(fd)
+		return nil, errors.WithStack(err)
+	}
+	if err = unix.SetsockoptTpacketReq3(fd, unix.SOL_PACKET, unix.PACKET_RX_RING, &unix.TpacketReq3{
+		Block_size:     uint32(cfg.BlockSize),
+		Block_nr:       uint32(cfg.BlockNum),
+		Frame_size:     uint32(cfg.FrameSize),
+		Frame_nr:       uint32(cfg.BlockSize / cfg.FrameSize * cfg.BlockNum),
+		Retire_blk_tov: uint32(max(cfg.Timeout.Milliseconds(), 1)),
+	}); err != nil {
+		unix.Close(fd)
+		return nil, errors.WithStack(err)
+	}
+	if r.mem, err = unix.Mmap(fd, 0, cfg.BlockSize*cfg.BlockNum, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED); err != nil {
+		unix.Close(fd)
+		return nil, errors.WithStack(err)
+	}
+
+	if err = unix.Bind(fd, &unix.SockaddrLinklayer{
+		Protocol: eth.Htons(uint16(proto)),
+		Ifindex:  ifi.Index,
+	}); err != nil {
+		unix.Munmap(r.mem)
+		unix.Close(fd)
+		return nil, errors.WithStack(err)
+	}
+
+	// for support deadline
+	if err = unix.SetNonblock(fd, true); err != nil {
+		unix.Munmap(r.mem)
+		unix.Close(fd)
+		return nil, errors.WithStack(err)
+	}
+	r.fd = os.NewFile(uintptr(fd), "")
+	if r.raw, err = r.fd.SyscallConn(); err != nil {
+		r.close()
+		return nil, errors.WithStack(err)
+	}
+	return r, nil
+}
+
+func (r *rxRing) desc(block int) *unix.TpacketHdrV1 {
+	// tpacket_block_desc{version, offset_to_priv, hdr}
+	return (*unix.TpacketHdrV1)(unsafe.Pointer(&r.mem[block*r.blockSize+8]))
+}
+
+// ready return whether has packet can be read without block
+func (r *rxRing) ready() bool {
+	if r.remain > 0 {
+		return true
+	}
+	block := r.block
+	if r.inuse {
+		block = (block + 1) % r.blockNum
+	}
+	return atomic.LoadUint32(&r.desc(block).Block_status)&unix.TP_STATUS_USER != 0
+}
+
+// peek return next packet, block until recved, GRO super-packet is split
+// and return it's segments one by one.
+func (r *rxRing) peek() (*unix.Tpacket3Hdr, []byte, error) {
+	hdr, ip, err := r.peekRing()
+	if err != nil || !r.vnet {
+		return hdr, ip, err
+	} else if r.seg != nil {
+		return hdr, r.seg, nil
+	}
+
+	if !r.split.More() {
+		var h vnet.Hdr
+		start := r.block*r.blockSize + int(r.off) + int(hdr.Mac) - vnet.Size
+		if err := h.Decode(r.mem[start:]); err != nil {
+			return nil, nil, err
+		}
+		if h.GSOType == vnet.GSONone {
+			if h.Flags&vnet.FlagNeedsCsum != 0 {
+				vnet.Complete(ip)
+			}
+			return hdr, ip, nil
+		}
+		if err := r.split.Reset(h, ip); err != nil {
+			r.skipRing()
+			return nil, nil, err
+		}
+	}
+
+	n, err := r.split.Next(r.buf)
+	if err != nil {
+		r.split = vnet.Splitter{}
+		r.skipRing()
+		return nil, nil, err
+	}
+	r.seg = r.buf[:n]
+	return hdr, r.seg, nil
+}
+
+// skip skip the packet returned by peek
+func (r *rxRing) skip() {
+	if r.seg != nil {
+		r.seg = nil
+		if r.split.More() {
+			return
+		}
+	}
+	r.skipRing()
+}
+
+func (r *rxRing) peekRing() (*unix.Tpacket3Hdr, []byte, error) {
+	for r.remain == 0 {
+		if r.inuse {
+			// return used block to kernel
+			atomic.StoreUint32(&r.desc(r.block).Block_status, unix.TP_STATUS_KERNEL)
+			r.block = (r.block + 1) % r.blockNum
+			r.inuse = false
+		}
+
+		desc := r.desc(r.block)
+		if atomic.LoadUint32(&desc.Block_status)&unix.TP_STATUS_USER == 0 {
+			if err := r.raw.Read(func(fd uintptr) (done bool) {
+				return atomic.LoadUint32(&desc.Block_status)&unix.TP_STATUS_USER != 0
+			}); err != nil {
+				return nil, nil, errors.WithStack(err)
+			}
+		}
+		r.inuse = true
+		r.remain = desc.Num_pkts
+		r.off = desc.Offset_to_first_pkt
+	}
+
+	base := r.block*r.blockSize + int(r.off)
+	hdr := (*unix.Tpacket3Hdr)(unsafe.Pointer(&r.mem[base]))
+	if hdr.Snaplen < hdr.Len {
+		// drop truncated packet, the next peek return the following packet
+		r.skipRing()
+		return nil, nil, errorx.ShortBuff(int(hdr.Len), int(hdr.Snaplen))
+	}
+	// snaplen start from link header for SOCK_RAW socket
+	start, end := base+int(hdr.Net), base+int(hdr.Mac)+int(hdr.Snaplen)
+	return hdr, r.mem[start:end], nil
+}
+
+func (r *rxRing) skipRing() {
+	hdr := (*unix.Tpacket3Hdr)(unsafe.Pointer(&r.mem[r.block*r.blockSize+int(r.off)]))
+	r.off += hdr.Next_offset
+	r.remain--
+}
+
+// from return source hardware address of packet
+func (r *rxRing) from(hdr *unix.Tpacket3Hdr) net.HardwareAddr {
+	// sockaddr_ll{family, protocol, ifindex, hatype, pkttype, halen, addr}
+	sll := unsafe.Slice((*byte)(unsafe.Add(unsafe.Pointer(hdr), rx3Offset)), 20)
+	halen := min(int(sll[11]), 8)
+	return append(net.HardwareAddr{}, sll[12:12+halen]...)
+}
+
+func (r *rxRing) close() error {
+	var err error
+	if r.fd != nil {
+		err = r.fd.Close()
+	}
+	if r.mem != nil {
+		if e := unix.Munmap(r.mem); err == nil {
+			err = e
+		}
+	}
+	return errors.WithStack(err)
+}
+
+type txRing struct {
+	fd  *os.File
+	raw syscall.RawConn
+	mem []byte
+
+	frameSize, frameNum int
+
+	// SOCK_RAW socket with PACKET_VNET_HDR, every frame start with
+	// virtio_net_hdr and ethernet header
+	vnet  bool
+	proto tcpip.NetworkProtocolNumber
+	src   net.HardwareAddr
+
+	mu    sync.Mutex
+	frame int // next frame index
+}
+
+func newTxRing(proto tcpip.NetworkProtocolNumber, ifi *net.Interface, cfg *Config) (*txRing, error) {
+	var t = &txRing{
+		frameSize: cfg.FrameSize,
+		frameNum:  cfg.BlockSize / cfg.FrameSize * cfg.BlockNum,
+		proto:     proto,
+		src:       ifi.HardwareAddr,
+	}
+
+	var fd int
+	var err error
+	if cfg.VnetHdr {
+		// PACKET_VNET_HDR only support SOCK_RAW, must be set before setup ring
+		if fd, err = unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, 0); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if t.vnet = unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_VNET_HDR, 1) == nil; !t.vnet {
+			unix.Close(fd)
+		}
+	}
+	if !t.vnet {
+		// protocol 0, never recv packet
+		if fd, err = unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM, 0); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if err = unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_VERSION, unix.TPACKET_V2); err != nil {
+		unix.Close(fd)
+		return nil, errors.WithStack(err)
+	}
+	if err = unix.SetsockoptTpacketReq(fd, unix.SOL_PACKET, unix.PACKET_TX_RING, &unix.TpacketReq{
+		Block_size: uint32(cfg.BlockSize),
+		Block_nr:   uint32(cfg.BlockNum),
+		Frame_size: uint32(t.frameSize),
+		Frame_nr:   uint32(t.frameNum),
+	}); err != nil {
+		unix.Close(fd)
+		return nil, errors.WithStack(err)
+	}
+	if t.mem, err = unix.Mmap(fd, 0, cfg.BlockSize*cfg.BlockNum, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED); err != nil {
+		unix.Close(fd)
+		return nil, errors.WithStack(err)
+	}
+
+	// for support deadline
+	if err = unix.SetNonblock(fd, true); err != nil {
+		unix.Munmap(t.mem)
+		unix.Close(fd)
+		return nil, errors.WithStack(err)
+	}
+	t.fd = os.NewFile(uintptr(fd), "")
+	if t.raw, err = t.fd.SyscallConn(); err != nil {
+		t.close()
+		return nil, errors.WithStack(err)
+	}
+	return t, nil
+}
+
+func (t *txRing) hdr(frame int) *unix.Tpacket2Hdr {
+	return (*unix.Tpacket2Hdr)(unsafe.Pointer(&t.mem[frame*t.frameSize]))
+}
+
+// write fill frames and send by one syscall, wait if ring is full. partial
+// indicate packets' tcp/udp checksum need be completed by nic.
+func (t *txRing) write(ips [][]byte, to *unix.SockaddrLinklayer, partial bool) (n int, err error) {
+	if partial && !t.vnet {
+		return 0, errors.New("not support checksum offload")
+	}
+	var prefix int
+	if t.vnet {
+		prefix = vnet.Size + header.EthernetMinimumSize
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, ip := range ips {
+		if prefix+len(ip) > t.frameSize-txOffset {
+			err = errors.Errorf("packet size %d exceed ring frame size %d", len(ip), t.frameSize-txOffset-prefix)
+			break
+		}
+
+		hdr := t.hdr(t.frame)
+		if atomic.LoadUint32(&hdr.Status) != unix.TP_STATUS_AVAILABLE {
+			// ring is full
+			if err = t.flush(to); err != nil {
+				return n - t.cancel(n), err
+			}
+			if err = t.raw.Write(func(fd uintptr) (done bool) {
+				return atomic.LoadUint32(&hdr.Status)&(unix.TP_STATUS_SEND_REQUEST|unix.TP_STATUS_SENDING) == 0
+			}); err != nil {
+				return n, errors.WithStack(err)
+			}
+			if atomic.LoadUint32(&hdr.Status)&unix.TP_STATUS_WRONG_FORMAT != 0 {
+				atomic.StoreUint32(&hdr.Status, unix.TP_STATUS_AVAILABLE)
+				return n, errors.New("ring frame wrong format")
+			}
+		}
+
+		data := t.mem[t.frame*t.frameSize+txOffset:]
+		if t.vnet {
+			var h vnet.Hdr
+			if partial {
+				h = vnet.Partial(ip, header.EthernetMinimumSize)
+			}
+			h.Encode(data)
+			encodeEthernet(data[vnet.Size:], t.src, to.Addr[:to.Halen], t.proto)
+		}
+		copy(data[prefix:], ip)
+		hdr.Len = uint32(prefix + len(ip))
+		atomic.StoreUint32(&hdr.Status, unix.TP_STATUS_SEND_REQUEST)
+		t.frame = (t.frame + 1) % t.frameNum
+		n++
+	}
+
+	if n > 0 {
+		if e := t.flush(to); e != nil {
+			return n - t.cancel(n), e
+		}
+	}
+	return n, err
+}
+
+// cancel the last n filled frames that not be taken by kernel after flush
+// failed, rewind to the first canceled frame, so kernel's ring head keep
+// pace with us. return the number of canceled frames.
+func (t *txRing) cancel(n int) (m int) {
+	for m < n {
+		hdr := t.hdr((t.frame - m - 1 + t.frameNum) % t.frameNum)
+		if !atomic.CompareAndSwapUint32(&hdr.Status, unix.TP_STATUS_SEND_REQUEST, unix.TP_STATUS_AVAILABLE) {
+			break
+		}
+		m++
+	}
+	t.frame = (t.frame - m + t.frameNum) % t.frameNum
+	return m
+}
+
+// flush send all frames with TP_STATUS_SEND_REQUEST
+func (t *txRing) flush(to *unix.SockaddrLinklayer) error {
+	var operr error
+	if err := t.raw.Write(func(fd uintptr) (done bool) {
+		operr = unix.Sendto(int(fd), nil, unix.MSG_DONTWAIT, to)
+		return operr != unix.EAGAIN && operr != unix.EWOULDBLOCK
+	}); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(operr)
+}
+
+func (t *txRing) close() error {
+	var err error
+	if t.fd != nil {
+		err = t.fd.Close()
+	}
+	if t.mem != nil {
+		if e := unix.Munmap(t.mem); err == nil {
+			err = e
+		}
+	}
+	return errors.WithStack(err)
+}
+
+// encodeEthernet encode ethernet header to b, nil hardware address is zero
+func encodeEthernet(b []byte, src, dst net.HardwareAddr, proto tcpip.NetworkProtocolNumber) {
+	clear(b[:header.EthernetMinimumSize])
+	header.Ethernet(b).Encode(&header.EthernetFields{
+		SrcAddr: tcpip.LinkAddress(src),
+		DstAddr: tcpip.LinkAddress(dst),
+		Type:    proto,
+	})
+}
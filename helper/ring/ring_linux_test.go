@@ -0,0 +1,192 @@
+//go:build linux
+// +build linux
+
+package ring_test
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lysShub/netkit/packet"
+	"github.com/lysShub/rawsock/helper/bpf"
+	"github.com/lysShub/rawsock/helper/ring"
+	"github.com/lysShub/rawsock/test"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func loopback(t *testing.T) *net.Interface {
+	ifs, err := net.Interfaces()
+	require.NoError(t, err)
+	for _, e := range ifs {
+		if e.Flags&net.FlagLoopback != 0 {
+			return &e
+		}
+	}
+	t.Skip("not found loopback interface")
+	return nil
+}
+
+func buildUDP(src, dst netip.AddrPort, payload []byte) []byte {
+	var ip = make([]byte, header.IPv4MinimumSize+header.UDPMinimumSize+len(payload))
+	header.IPv4(ip).Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(ip)),
+		TTL:         64,
+		Protocol:    uint8(header.UDPProtocolNumber),
+		SrcAddr:     tcpip.AddrFrom4(src.Addr().As4()),
+		DstAddr:     tcpip.AddrFrom4(dst.Addr().As4()),
+	})
+	header.IPv4(ip).SetChecksum(^header.IPv4(ip).CalculateChecksum())
+
+	udp := header.UDP(ip[header.IPv4MinimumSize:])
+	udp.Encode(&header.UDPFields{
+		SrcPort: src.Port(),
+		DstPort: dst.Port(),
+		Length:  uint16(len(udp)),
+	})
+	copy(udp.Payload(), payload)
+	return ip
+}
+
+func Test_Ring(t *testing.T) {
+	var (
+		saddr = netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), test.RandPort())
+		caddr = netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), test.RandPort())
+	)
+
+	conn, err := ring.Listen("eth:ip4", loopback(t), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	err = bpf.SetRawBPF(conn.SyscallConn(), bpf.FilterEndpoint(header.UDPProtocolNumber, caddr, saddr))
+	require.NoError(t, err)
+
+	udp, err := net.ListenUDP("udp4", test.UDPAddr(saddr))
+	require.NoError(t, err)
+	defer udp.Close()
+
+	t.Run("read", func(t *testing.T) {
+		c, err := net.DialUDP("udp4", test.UDPAddr(caddr), test.UDPAddr(saddr))
+		require.NoError(t, err)
+		defer c.Close()
+		_, err = c.Write([]byte("hello"))
+		require.NoError(t, err)
+
+		var ip = make([]byte, 1536)
+		n, _, err := conn.ReadFromETH(ip)
+		require.NoError(t, err)
+		iphdr := header.IPv4(ip[:n])
+		require.Equal(t, "hello", string(header.UDP(iphdr.Payload()).Payload()))
+
+		_, err = udp.Read(ip)
+		require.NoError(t, err)
+	})
+
+	// sent packet on loopback will be captured by RX ring
+	t.Run("write", func(t *testing.T) {
+		ip := buildUDP(caddr, saddr, []byte("hello"))
+		_, err := conn.WriteToETH(ip, nil)
+		require.NoError(t, err)
+
+		var b = make([]byte, 1536)
+		n, _, err := conn.ReadFromETH(b)
+		require.NoError(t, err)
+		require.Equal(t, ip, b[:n])
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		var ips []*packet.Packet
+		for _, msg := range []string{"a", "b", "c"} {
+			ips = append(ips, packet.Make().Append(buildUDP(caddr, saddr, []byte(msg))...))
+		}
+		n, err := conn.WriteBatch(ips, nil)
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+
+		var pkts = []*packet.Packet{packet.Make(0, 128), packet.Make(0, 128), packet.Make(0, 128)}
+		var i int
+		for i < len(pkts) {
+			n, err := conn.ReadBatch(pkts[i:])
+			require.NoError(t, err)
+			i += n
+		}
+		for i := range ips {
+			require.Equal(t, ips[i].Bytes(), pkts[i].Bytes())
+		}
+	})
+
+	t.Run("write-failed", func(t *testing.T) {
+		var ips []*packet.Packet
+		for _, msg := range []string{"a", "b", "c"} {
+			ips = append(ips, packet.Make().Append(buildUDP(caddr, saddr, []byte(msg))...))
+		}
+
+		// flush failed, no frame is committed
+		require.NoError(t, conn.SetWriteDeadline(time.Now().Add(-time.Second)))
+		n, err := conn.WriteBatch(ips, nil)
+		require.True(t, errors.Is(err, os.ErrDeadlineExceeded), err)
+		require.Zero(t, n)
+
+		// canceled frames not be sent by later write
+		require.NoError(t, conn.SetWriteDeadline(time.Time{}))
+		n, err = conn.WriteBatch(ips[2:], nil)
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+
+		var b = make([]byte, 1536)
+		m, _, err := conn.ReadFromETH(b)
+		require.NoError(t, err)
+		require.Equal(t, ips[2].Bytes(), b[:m])
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		for {
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Millisecond*100)))
+			if _, err = conn.Next(); err != nil {
+				break
+			}
+		}
+		require.True(t, errors.Is(err, os.ErrDeadlineExceeded), err)
+	})
+}
+
+func Test_Ring_Truncated(t *testing.T) {
+	var (
+		saddr = netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), test.RandPort())
+		caddr = netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), test.RandPort())
+	)
+
+	// packet exceed block size will be truncated
+	conn, err := ring.Listen("eth:ip4", loopback(t), &ring.Config{BlockSize: os.Getpagesize()})
+	require.NoError(t, err)
+	defer conn.Close()
+	err = bpf.SetRawBPF(conn.SyscallConn(), bpf.FilterEndpoint(header.UDPProtocolNumber, caddr, saddr))
+	require.NoError(t, err)
+
+	udp, err := net.ListenUDP("udp4", test.UDPAddr(saddr))
+	require.NoError(t, err)
+	defer udp.Close()
+	c, err := net.DialUDP("udp4", test.UDPAddr(caddr), test.UDPAddr(saddr))
+	require.NoError(t, err)
+	defer c.Close()
+
+	_, err = c.Write(make([]byte, os.Getpagesize()*2))
+	require.NoError(t, err)
+	_, err = c.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	var ip = make([]byte, 0xffff)
+	_, _, err = conn.ReadFromETH(ip)
+	require.True(t, errors.Is(err, io.ErrShortBuffer), err)
+
+	// truncated packet is skipped
+	n, _, err := conn.ReadFromETH(ip)
+	require.NoError(t, err)
+	iphdr := header.IPv4(ip[:n])
+	require.Equal(t, "hello", string(header.UDP(iphdr.Payload()).Payload()))
+}
@@ -0,0 +1,98 @@
+//go:build linux
+// +build linux
+
+package helper
+
+import (
+	"net/netip"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+type Route struct {
+	Next      netip.Addr // next hop, invalid if destination is on-link
+	Addr      netip.Addr // preferred source address
+	Interface int        // output interface index
+	Local     bool       // destination is local address
+}
+
+// GetRoute query the route to dst by netlink RTM_GETROUTE, like `ip route get`,
+// support ipv4 and ipv6.
+func GetRoute(dst netip.Addr) (*Route, error) {
+	dst = dst.Unmap()
+	var family, bits = unix.AF_INET, 32
+	if dst.Is6() {
+		family, bits = unix.AF_INET6, 128
+	}
+
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var req = make([]byte, unix.NLMSG_HDRLEN+unix.SizeofRtMsg+unix.SizeofRtAttr+bits/8)
+	*(*unix.NlMsghdr)(unsafe.Pointer(&req[0])) = unix.NlMsghdr{
+		Len:   uint32(len(req)),
+		Type:  unix.RTM_GETROUTE,
+		Flags: unix.NLM_F_REQUEST,
+		Seq:   1,
+	}
+	*(*unix.RtMsg)(unsafe.Pointer(&req[unix.NLMSG_HDRLEN])) = unix.RtMsg{
+		Family:  uint8(family),
+		Dst_len: uint8(bits),
+	}
+	attr := req[unix.NLMSG_HDRLEN+unix.SizeofRtMsg:]
+	*(*unix.RtAttr)(unsafe.Pointer(&attr[0])) = unix.RtAttr{
+		Len:  uint16(unix.SizeofRtAttr + bits/8),
+		Type: unix.RTA_DST,
+	}
+	copy(attr[unix.SizeofRtAttr:], dst.AsSlice())
+
+	if err := unix.Sendto(fd, req, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var b = make([]byte, unix.Getpagesize())
+	n, _, err := unix.Recvfrom(fd, b, 0)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	msgs, err := syscall.ParseNetlinkMessage(b[:n])
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for _, m := range msgs {
+		switch m.Header.Type {
+		case unix.RTM_NEWROUTE:
+			rt := (*unix.RtMsg)(unsafe.Pointer(unsafe.SliceData(m.Data)))
+			attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+
+			var r = &Route{Local: rt.Type == unix.RTN_LOCAL}
+			for _, attr := range attrs {
+				switch attr.Attr.Type {
+				case unix.RTA_GATEWAY:
+					r.Next, _ = netip.AddrFromSlice(attr.Value)
+				case unix.RTA_PREFSRC:
+					r.Addr, _ = netip.AddrFromSlice(attr.Value)
+				case unix.RTA_OIF:
+					r.Interface = int(*(*int32)(unsafe.Pointer(unsafe.SliceData(attr.Value))))
+				}
+			}
+			return r, nil
+		case unix.NLMSG_ERROR:
+			e := (*unix.NlMsgerr)(unsafe.Pointer(unsafe.SliceData(m.Data)))
+			return nil, errors.WithStack(unix.Errno(-e.Error))
+		}
+	}
+	return nil, errors.Errorf("not found route to %s", dst)
+}
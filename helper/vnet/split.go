@@ -0,0 +1,191 @@
+package vnet
+
+import (
+	"github.com/lysShub/netkit/errorx"
+	"github.com/pkg/errors"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// GSOECN VIRTIO_NET_HDR_GSO_ECN, flag of GSOType
+const GSOECN = 0x80
+
+// Splitter split GRO/GSO super-packet to wire-sized segments by GSOSize, every
+// segment's ip/transport header is updated and checksum is re-calculated.
+type Splitter struct {
+	ip     []byte // super-packet
+	iphdr  int    // ip header length
+	hdrLen int    // ip and transport header length
+	proto  tcpip.TransportProtocolNumber
+	size   int // segment payload size
+	off    int // next segment payload offset
+	idx    int // next segment index
+}
+
+// Reset set super-packet ip with it's virtio_net_hdr, ip is referenced until
+// all segments are read.
+func (s *Splitter) Reset(h Hdr, ip []byte) error {
+	s.ip = nil
+	if h.GSOSize == 0 {
+		return errors.Errorf("invalid gso size %d", h.GSOSize)
+	}
+
+	var iphdr int
+	var proto tcpip.TransportProtocolNumber
+	switch header.IPVersion(ip) {
+	case 4:
+		if len(ip) < header.IPv4MinimumSize || len(ip) < int(header.IPv4(ip).HeaderLength()) {
+			return errors.New("invalid ipv4 packet")
+		}
+		iphdr, proto = int(header.IPv4(ip).HeaderLength()), header.IPv4(ip).TransportProtocol()
+		ip = ip[:min(int(header.IPv4(ip).TotalLength()), len(ip))]
+	case 6:
+		if len(ip) < header.IPv6MinimumSize {
+			return errors.New("invalid ipv6 packet")
+		}
+		iphdr, proto = header.IPv6MinimumSize, header.IPv6(ip).TransportProtocol()
+		ip = ip[:min(header.IPv6MinimumSize+int(header.IPv6(ip).PayloadLength()), len(ip))]
+	default:
+		return errors.Errorf("invalid ip version %d", header.IPVersion(ip))
+	}
+
+	var hdrLen int
+	switch typ := h.GSOType &^ GSOECN; {
+	case (typ == GSOTCPv4 || typ == GSOTCPv6) && proto == header.TCPProtocolNumber:
+		if len(ip) < iphdr+header.TCPMinimumSize {
+			return errors.New("invalid tcp packet")
+		}
+		hdrLen = iphdr + int(header.TCP(ip[iphdr:]).DataOffset())
+	case typ == GSOUDPL4 && proto == header.UDPProtocolNumber:
+		hdrLen = iphdr + header.UDPMinimumSize
+	default:
+		return errors.Errorf("not support gso type %d with protocol %d", h.GSOType, proto)
+	}
+	if len(ip) < hdrLen {
+		return errorx.ShortBuff(hdrLen, len(ip))
+	}
+
+	*s = Splitter{
+		ip: ip, iphdr: iphdr, hdrLen: hdrLen, proto: proto,
+		size: int(h.GSOSize),
+	}
+	return nil
+}
+
+// More return whether has segment not read
+func (s *Splitter) More() bool { return s.ip != nil }
+
+// Next write next segment to b, return segment length
+func (s *Splitter) Next(b []byte) (int, error) {
+	if !s.More() {
+		return 0, errors.New("not segment")
+	}
+	payload := s.ip[s.hdrLen:]
+	n := min(s.size, len(payload)-s.off)
+	size := s.hdrLen + n
+	if len(b) < size {
+		return 0, errorx.ShortBuff(size, len(b))
+	}
+	last := s.off+n == len(payload)
+
+	copy(b, s.ip[:s.hdrLen])
+	copy(b[s.hdrLen:], payload[s.off:s.off+n])
+	seg := b[:size]
+
+	var src, dst tcpip.Address
+	if header.IPVersion(seg) == 4 {
+		ip := header.IPv4(seg)
+		ip.SetTotalLength(uint16(size))
+		// GRO merge packets with incremental ID, or with fixed ID if DF set
+		// (SKB_GSO_TCP_FIXEDID), virtio_net_hdr not tell which one. ID of
+		// atomic datagram is meaningless (RFC 6864), so keep it unchanged.
+		if ip.Flags()&header.IPv4FlagDontFragment == 0 {
+			ip.SetID(ip.ID() + uint16(s.idx))
+		}
+		ip.SetChecksum(0)
+		ip.SetChecksum(^ip.CalculateChecksum())
+		src, dst = ip.SourceAddress(), ip.DestinationAddress()
+	} else {
+		ip := header.IPv6(seg)
+		ip.SetPayloadLength(uint16(size - header.IPv6MinimumSize))
+		src, dst = ip.SourceAddress(), ip.DestinationAddress()
+	}
+
+	psum := header.PseudoHeaderChecksum(s.proto, src, dst, uint16(size-s.iphdr))
+	switch s.proto {
+	case header.TCPProtocolNumber:
+		tcp := header.TCP(seg[s.iphdr:])
+		tcp.SetSequenceNumber(tcp.SequenceNumber() + uint32(s.off))
+		flags := tcp.Flags()
+		if !last {
+			flags &^= header.TCPFlagFin | header.TCPFlagPsh
+		}
+		if s.idx > 0 {
+			flags &^= header.TCPFlagCwr
+		}
+		tcp.SetFlags(uint8(flags))
+		tcp.SetChecksum(0)
+		tcp.SetChecksum(^checksum.Checksum(tcp, psum))
+	case header.UDPProtocolNumber:
+		udp := header.UDP(seg[s.iphdr:])
+		udp.SetLength(uint16(size - s.iphdr))
+		udp.SetChecksum(0)
+		udp.SetChecksum(udpChecksum(udp, psum))
+	}
+
+	s.off += n
+	s.idx++
+	if last {
+		s.ip = nil
+	}
+	return size, nil
+}
+
+// Complete complete partial tcp/udp checksum of ip packet, that recved with
+// FlagNeedsCsum, it's checksum field only is pseudo header checksum.
+func Complete(ip []byte) {
+	var src, dst tcpip.Address
+	var proto tcpip.TransportProtocolNumber
+	var hdr []byte
+	switch header.IPVersion(ip) {
+	case 4:
+		if len(ip) < header.IPv4MinimumSize {
+			return
+		}
+		ip := header.IPv4(ip)
+		src, dst, proto = ip.SourceAddress(), ip.DestinationAddress(), ip.TransportProtocol()
+		hdr = ip[min(int(ip.HeaderLength()), len(ip)):]
+	case 6:
+		if len(ip) < header.IPv6MinimumSize {
+			return
+		}
+		ip := header.IPv6(ip)
+		src, dst, proto = ip.SourceAddress(), ip.DestinationAddress(), ip.TransportProtocol()
+		hdr = ip[header.IPv6MinimumSize:]
+	default:
+		return
+	}
+
+	psum := header.PseudoHeaderChecksum(proto, src, dst, uint16(len(hdr)))
+	switch proto {
+	case header.TCPProtocolNumber:
+		if len(hdr) >= header.TCPMinimumSize {
+			header.TCP(hdr).SetChecksum(0)
+			header.TCP(hdr).SetChecksum(^checksum.Checksum(hdr, psum))
+		}
+	case header.UDPProtocolNumber:
+		if len(hdr) >= header.UDPMinimumSize {
+			header.UDP(hdr).SetChecksum(0)
+			header.UDP(hdr).SetChecksum(udpChecksum(hdr, psum))
+		}
+	}
+}
+
+// udpChecksum zero udp checksum means not checksum, use 0xffff instead
+func udpChecksum(udp []byte, psum uint16) uint16 {
+	if sum := ^checksum.Checksum(udp, psum); sum != 0 {
+		return sum
+	}
+	return 0xffff
+}
@@ -0,0 +1,136 @@
+package vnet
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/lysShub/rawsock/test"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func Test_Splitter(t *testing.T) {
+	var payload = make([]byte, 1000)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	t.Run("tcp4", func(t *testing.T) {
+		ip := test.BuildRawTCP(t,
+			netip.MustParseAddrPort("10.0.0.1:1234"), netip.MustParseAddrPort("10.0.0.2:80"),
+			payload,
+		)
+		ip.SetID(0xfffe)
+		tcp := header.TCP(ip.Payload())
+		tcp.SetFlags(uint8(header.TCPFlagAck | header.TCPFlagPsh | header.TCPFlagFin | header.TCPFlagCwr))
+		seq := tcp.SequenceNumber()
+
+		var s Splitter
+		require.NoError(t, s.Reset(Hdr{GSOType: GSOTCPv4 | GSOECN, GSOSize: 300}, ip))
+
+		var b = make([]byte, 1500)
+		for i := 0; i < 4; i++ {
+			require.True(t, s.More())
+			n, err := s.Next(b)
+			require.NoError(t, err)
+			seg := header.IPv4(b[:n])
+			test.ValidIP(t, seg)
+
+			require.Equal(t, uint16(0xfffe+i), seg.ID())
+			tcp := header.TCP(seg.Payload())
+			require.Equal(t, seq+uint32(i*300), tcp.SequenceNumber())
+			require.Equal(t, payload[i*300:min(i*300+300, len(payload))], []byte(tcp.Payload()))
+
+			var flags = header.TCPFlagAck
+			if i == 0 {
+				flags |= header.TCPFlagCwr
+			}
+			if i == 3 {
+				flags |= header.TCPFlagPsh | header.TCPFlagFin
+			}
+			require.Equal(t, flags, tcp.Flags())
+		}
+		require.False(t, s.More())
+	})
+
+	t.Run("tcp4-df", func(t *testing.T) {
+		ip := test.BuildRawTCP(t,
+			netip.MustParseAddrPort("10.0.0.1:1234"), netip.MustParseAddrPort("10.0.0.2:80"),
+			payload,
+		)
+		ip.SetFlagsFragmentOffset(header.IPv4FlagDontFragment, 0)
+		ip.SetID(1234)
+
+		var s Splitter
+		require.NoError(t, s.Reset(Hdr{GSOType: GSOTCPv4, GSOSize: 500}, ip))
+
+		var b = make([]byte, 1500)
+		for s.More() {
+			n, err := s.Next(b)
+			require.NoError(t, err)
+			test.ValidIP(t, b[:n])
+			require.Equal(t, uint16(1234), header.IPv4(b[:n]).ID())
+		}
+	})
+
+	t.Run("udp6", func(t *testing.T) {
+		var ip = make(header.IPv6, header.IPv6MinimumSize+header.UDPMinimumSize+len(payload))
+		ip.Encode(&header.IPv6Fields{
+			PayloadLength:     uint16(header.UDPMinimumSize + len(payload)),
+			TransportProtocol: header.UDPProtocolNumber,
+			HopLimit:          64,
+			SrcAddr:           tcpip.AddrFrom16(netip.MustParseAddr("fd00::1").As16()),
+			DstAddr:           tcpip.AddrFrom16(netip.MustParseAddr("fd00::2").As16()),
+		})
+		header.UDP(ip.Payload()).Encode(&header.UDPFields{SrcPort: 53, DstPort: 1234})
+		copy(ip.Payload()[header.UDPMinimumSize:], payload)
+
+		var s Splitter
+		require.NoError(t, s.Reset(Hdr{GSOType: GSOUDPL4, GSOSize: 512}, ip))
+
+		var b = make([]byte, 1500)
+		for i := 0; i < 2; i++ {
+			n, err := s.Next(b)
+			require.NoError(t, err)
+			test.ValidIP(t, b[:n])
+
+			udp := header.UDP(header.IPv6(b[:n]).Payload())
+			require.Equal(t, payload[i*512:min(i*512+512, len(payload))], []byte(udp.Payload()))
+		}
+		require.False(t, s.More())
+	})
+
+	t.Run("short-buff", func(t *testing.T) {
+		ip := test.BuildRawTCP(t,
+			netip.MustParseAddrPort("10.0.0.1:1234"), netip.MustParseAddrPort("10.0.0.2:80"),
+			payload,
+		)
+		var s Splitter
+		require.NoError(t, s.Reset(Hdr{GSOType: GSOTCPv4, GSOSize: 500}, ip))
+		_, err := s.Next(make([]byte, 100))
+		require.Error(t, err)
+	})
+
+	t.Run("not-support", func(t *testing.T) {
+		ip := test.BuildRawTCP(t,
+			netip.MustParseAddrPort("10.0.0.1:1234"), netip.MustParseAddrPort("10.0.0.2:80"),
+			payload,
+		)
+		var s Splitter
+		require.Error(t, s.Reset(Hdr{GSOType: GSOUDPL4, GSOSize: 500}, ip))
+		require.Error(t, s.Reset(Hdr{GSOType: GSOTCPv4}, ip))
+		require.False(t, s.More())
+	})
+}
+
+func Test_Complete(t *testing.T) {
+	ip := test.BuildRawTCP(t,
+		netip.MustParseAddrPort("10.0.0.1:1234"), netip.MustParseAddrPort("10.0.0.2:80"),
+		[]byte("hello"),
+	)
+	header.TCP(ip.Payload()).SetChecksum(0x1234)
+
+	Complete(ip)
+	test.ValidIP(t, ip)
+}
@@ -0,0 +1,88 @@
+// Package vnet encode/decode virtio_net_hdr, it's prefix of packet on
+// PACKET_VNET_HDR AF_PACKET socket, used by checksum and segmentation offload.
+//
+// https://docs.oasis-open.org/virtio/virtio/v1.2/virtio-v1.2.html#x1-2050001
+package vnet
+
+import (
+	"encoding/binary"
+
+	"github.com/lysShub/netkit/errorx"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// Size sizeof(struct virtio_net_hdr)
+const Size = 10
+
+const (
+	FlagNeedsCsum = 1 // VIRTIO_NET_HDR_F_NEEDS_CSUM
+	FlagDataValid = 2 // VIRTIO_NET_HDR_F_DATA_VALID
+
+	GSONone  = 0 // VIRTIO_NET_HDR_GSO_NONE
+	GSOTCPv4 = 1 // VIRTIO_NET_HDR_GSO_TCPV4
+	GSOUDP   = 3 // VIRTIO_NET_HDR_GSO_UDP
+	GSOTCPv6 = 4 // VIRTIO_NET_HDR_GSO_TCPV6
+	GSOUDPL4 = 5 // VIRTIO_NET_HDR_GSO_UDP_L4
+)
+
+// Hdr is struct virtio_net_hdr, CsumStart and HdrLen include link header.
+type Hdr struct {
+	Flags      uint8
+	GSOType    uint8
+	HdrLen     uint16
+	GSOSize    uint16
+	CsumStart  uint16
+	CsumOffset uint16
+}
+
+// Encode encode to b, legacy virtio use native endian
+func (h *Hdr) Encode(b []byte) {
+	_ = b[Size-1]
+	b[0] = h.Flags
+	b[1] = h.GSOType
+	binary.NativeEndian.PutUint16(b[2:], h.HdrLen)
+	binary.NativeEndian.PutUint16(b[4:], h.GSOSize)
+	binary.NativeEndian.PutUint16(b[6:], h.CsumStart)
+	binary.NativeEndian.PutUint16(b[8:], h.CsumOffset)
+}
+
+// Decode decode from b
+func (h *Hdr) Decode(b []byte) error {
+	if len(b) < Size {
+		return errorx.ShortBuff(Size, len(b))
+	}
+	h.Flags = b[0]
+	h.GSOType = b[1]
+	h.HdrLen = binary.NativeEndian.Uint16(b[2:])
+	h.GSOSize = binary.NativeEndian.Uint16(b[4:])
+	h.CsumStart = binary.NativeEndian.Uint16(b[6:])
+	h.CsumOffset = binary.NativeEndian.Uint16(b[8:])
+	return nil
+}
+
+// Partial return header that request tcp/udp checksum offload of ip packet,
+// the transport checksum field should be pseudo header checksum. link is
+// link header length of device, return zero header if not tcp/udp packet.
+func Partial(ip []byte, link int) Hdr {
+	var hdrLen int
+	var proto uint8
+	switch header.IPVersion(ip) {
+	case 4:
+		hdrLen, proto = int(header.IPv4(ip).HeaderLength()), header.IPv4(ip).Protocol()
+	case 6:
+		hdrLen, proto = header.IPv6MinimumSize, header.IPv6(ip).NextHeader()
+	default:
+		return Hdr{}
+	}
+
+	var h = Hdr{Flags: FlagNeedsCsum, CsumStart: uint16(link + hdrLen)}
+	switch proto {
+	case uint8(header.TCPProtocolNumber):
+		h.CsumOffset = header.TCPChecksumOffset
+	case uint8(header.UDPProtocolNumber):
+		h.CsumOffset = 6 // udp checksum offset
+	default:
+		return Hdr{}
+	}
+	return h
+}
@@ -1 +1,3 @@
+// Package icmp read/write icmp echo flows, the echo identifier is used as
+// port. only support ipv4, ICMPv6 is not supported.
 package icmp
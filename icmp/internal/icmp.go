@@ -0,0 +1,23 @@
+package icmp
+
+import (
+	"net/netip"
+
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func SizeRange(ipv4 bool) (min, max int) {
+	if ipv4 {
+		min, max = header.ICMPv4MinimumSize, header.ICMPv4MinimumSize
+		min += header.IPv4MinimumSize
+		max += header.IPv4MaximumHeaderSize
+	} else {
+		min, max = header.ICMPv6MinimumSize, header.ICMPv6MinimumSize
+		min += header.IPv6MinimumSize
+		max += header.IPv6MinimumSize
+	}
+	return
+}
+
+// CloseCallback remote address's port is echo identifier
+type CloseCallback func(netip.AddrPort) error
@@ -0,0 +1,368 @@
+//go:build linux
+// +build linux
+
+package raw
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+
+	"github.com/lysShub/netkit/debug"
+	"github.com/lysShub/netkit/errorx"
+	"github.com/lysShub/netkit/packet"
+	"github.com/lysShub/rawsock"
+	"github.com/lysShub/rawsock/helper"
+	"github.com/lysShub/rawsock/helper/bpf"
+	"github.com/lysShub/rawsock/helper/ipstack"
+	iicmp "github.com/lysShub/rawsock/icmp/internal"
+	"github.com/lysShub/rawsock/test"
+)
+
+// Listener accept icmp echo flows, icmp hasn't port, the AddrPort's port
+// is echo identifier. only support ipv4.
+type Listener struct {
+	addr netip.AddrPort
+	cfg  *rawsock.Config
+
+	raw *helper.IPConn
+	// serialize AcceptCtx reads
+	reader *helper.CtxReader
+
+	conns   map[netip.AddrPort]struct{}
+	connsMu sync.RWMutex
+
+	closeErr errorx.CloseErr
+}
+
+var _ rawsock.Listener = (*Listener)(nil)
+
+// Listen listen icmp echo request, if laddr's port is zero, will accept
+// echo request with any identifier. if laddr's address is unspecified,
+// will listen on all nic.
+func Listen(laddr netip.AddrPort, opts ...rawsock.Option) (*Listener, error) {
+	var l = &Listener{
+		cfg:   rawsock.Options(opts...),
+		conns: make(map[netip.AddrPort]struct{}, 16),
+	}
+	var err error
+
+	if !laddr.Addr().Is4() {
+		return nil, errors.Errorf("icmp only support ipv4, not support listen on %s", laddr.Addr())
+	}
+	l.addr = laddr
+
+	l.raw, err = helper.ListenIP(header.ICMPv4ProtocolNumber, l.addr.Addr())
+	if err != nil {
+		return nil, l.close(err)
+	}
+
+	if err = l.raw.SetBPF(
+		// the first echo request of new flow will be delivered to Conn, can't
+		// be truncated by bpf, only copy headers by SnapRead
+		bpf.FilterICMPEchoRequest(l.addr.Port(), 0xffff),
+	); err != nil {
+		return nil, l.close(err)
+	}
+	l.reader = helper.NewCtxReader(l.raw.SnapRead(bpf.SnapICMP, l.isNew), 0xffff)
+
+	return l, nil
+}
+
+func (l *Listener) close(cause error) error {
+	return l.closeErr.Close(func() (errs []error) {
+		errs = append(errs, cause)
+
+		if l.reader != nil {
+			errs = append(errs, l.reader.Close())
+		}
+		if l.raw != nil {
+			errs = append(errs, errors.WithStack(l.raw.Close()))
+		}
+		return errs
+	})
+}
+
+func (l *Listener) Accept() (rawsock.RawConn, error) {
+	return l.AcceptCtx(context.Background())
+}
+
+func (l *Listener) AcceptCtx(ctx context.Context) (rawsock.RawConn, error) {
+	for {
+		// only read headers, except the first echo request of new flow, see isNew
+		ip, err := l.reader.ReadCtx(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			return nil, l.close(err)
+		}
+		laddr, id, err := l.flow(ip)
+		if err != nil {
+			return nil, err
+		}
+
+		l.connsMu.RLock()
+		_, has := l.conns[id]
+		l.connsMu.RUnlock()
+		if !has {
+			l.connsMu.Lock()
+			l.conns[id] = struct{}{}
+			l.connsMu.Unlock()
+
+			c := newConnect(laddr, id, l.deleteConn)
+			c.first = ip
+			if err := c.init(l.cfg); err != nil {
+				return nil, errorx.WrapTemp(c.close(err))
+			}
+			return c, nil
+		}
+	}
+}
+
+// flow parse local and remote address of echo request recved by Listener, the
+// port is echo identifier, the packet maybe truncated.
+func (l *Listener) flow(ip []byte) (laddr, raddr netip.AddrPort, err error) {
+	min, _ := iicmp.SizeRange(true)
+	if len(ip) < min {
+		return laddr, raddr, errors.Errorf("recved invalid ip packet, bytes %d", len(ip))
+	}
+	hdrLen, err := helper.IPHeaderLen(ip)
+	if err != nil {
+		return laddr, raddr, err
+	} else if len(ip) < hdrLen+header.ICMPv4MinimumSize {
+		return laddr, raddr, errors.Errorf("recved invalid ip packet, bytes %d", len(ip))
+	}
+
+	// if listen on unspecified address, local address is the packet's destination
+	switch header.IPVersion(ip) {
+	case 4:
+		iphdr := header.IPv4(ip)
+		ident := header.ICMPv4(iphdr[hdrLen:]).Ident()
+		laddr = netip.AddrPortFrom(netip.AddrFrom4(iphdr.DestinationAddress().As4()), ident)
+		raddr = netip.AddrPortFrom(netip.AddrFrom4(iphdr.SourceAddress().As4()), ident)
+	default:
+		return laddr, raddr, errors.Errorf("recved invalid ip packet, version %d", header.IPVersion(ip))
+	}
+	return laddr, raddr, nil
+}
+
+// isNew report whether the packet is the first echo request of new flow, it
+// will be read entirely and delivered to Conn.
+func (l *Listener) isNew(ip []byte) bool {
+	_, id, err := l.flow(ip)
+	if err != nil {
+		return false
+	}
+	l.connsMu.RLock()
+	defer l.connsMu.RUnlock()
+	_, has := l.conns[id]
+	return !has
+}
+
+func (l *Listener) deleteConn(raddr netip.AddrPort) error {
+	if l == nil {
+		return nil
+	}
+	l.connsMu.Lock()
+	delete(l.conns, raddr)
+	l.connsMu.Unlock()
+	return nil
+}
+func (l *Listener) Addr() netip.AddrPort { return l.addr }
+func (l *Listener) Close() error         { return l.close(nil) }
+
+// Connect create icmp echo flow, laddr and raddr's port is echo identifier, they
+// should be equal, if laddr's port is zero, will alloc a random identifier.
+func Connect(laddr, raddr netip.AddrPort, opts ...rawsock.Option) (*Conn, error) {
+	cfg := rawsock.Options(opts...)
+
+	if l, err := helper.DefaultLocal(laddr.Addr(), raddr.Addr()); err != nil {
+		return nil, errors.WithStack(err)
+	} else {
+		laddr = netip.AddrPortFrom(l, laddr.Port())
+	}
+	if !laddr.Addr().Is4() || !raddr.Addr().Is4() {
+		return nil, errors.Errorf("icmp only support ipv4, not support connect %s -> %s", laddr.Addr(), raddr.Addr())
+	}
+
+	ident := laddr.Port()
+	for ident == 0 {
+		ident = uint16(rand.Uint32())
+	}
+
+	var c = newConnect(
+		netip.AddrPortFrom(laddr.Addr(), ident),
+		netip.AddrPortFrom(raddr.Addr(), ident),
+		nil,
+	)
+	if err := c.init(cfg); err != nil {
+		return nil, c.close(err)
+	}
+	return c, nil
+}
+
+type Conn struct {
+	laddr, raddr  netip.AddrPort
+	closeCallback iicmp.CloseCallback
+
+	raw *net.IPConn
+
+	// IPPROTO_RAW socket, used to write/inject ip packet
+	hdrincl *net.IPConn
+	ipstack *ipstack.IPStack
+
+	// echo request recved by Listener, it's the first read result
+	first   []byte
+	firstMu sync.Mutex
+
+	closeErr errorx.CloseErr
+}
+
+var _ rawsock.RawConn = (*Conn)(nil)
+
+func newConnect(laddr, raddr netip.AddrPort, close iicmp.CloseCallback) *Conn {
+	return &Conn{laddr: laddr, raddr: raddr, closeCallback: close}
+}
+
+func (c *Conn) init(cfg *rawsock.Config) (err error) {
+	if c.raw, err = net.DialIP(
+		"ip4:icmp",
+		&net.IPAddr{IP: c.laddr.Addr().AsSlice()},
+		&net.IPAddr{IP: c.raddr.Addr().AsSlice()},
+	); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if raw, err := c.raw.SyscallConn(); err != nil {
+		return errors.WithStack(err)
+	} else {
+		err := bpf.SetRawBPF(raw,
+			bpf.FilterICMPIdent(c.laddr.Port()),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.hdrincl, err = helper.ListenRawIP(c.laddr.Addr()); err != nil {
+		return err
+	}
+
+	if c.ipstack, err = ipstack.New(
+		c.laddr.Addr(), c.raddr.Addr(),
+		header.ICMPv4ProtocolNumber,
+		cfg.IPStack.Unmarshal(),
+	); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *Conn) close(cause error) error {
+	return c.closeErr.Close(func() (errs []error) {
+		errs = append(errs, cause)
+
+		if c.closeCallback != nil {
+			errs = append(errs, c.closeCallback(c.RemoteAddr()))
+		}
+		if c.raw != nil {
+			errs = append(errs, c.raw.Close())
+		}
+		if c.hdrincl != nil {
+			errs = append(errs, c.hdrincl.Close())
+		}
+		return
+	})
+}
+
+// Read read icmp echo/echo-reply packet from remote address
+func (c *Conn) Read(pkt *packet.Packet) (err error) {
+	hdrLen, err := c.read(pkt)
+	if err != nil {
+		return err
+	}
+	pkt.SetHead(pkt.Head() + hdrLen)
+	return nil
+}
+
+func (c *Conn) ReadRaw(ip *packet.Packet) (err error) {
+	_, err = c.read(ip)
+	return err
+}
+
+func (c *Conn) read(pkt *packet.Packet) (hdrLen int, err error) {
+	n, err := c.readFirst(pkt)
+	if err != nil {
+		return 0, err
+	} else if n == 0 {
+		if n, err = c.raw.Read(pkt.Bytes()); err != nil {
+			return 0, errors.WithStack(err)
+		}
+	}
+	pkt.SetData(n)
+
+	hdrLen, err = helper.CheckIP(pkt.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	if debug.Debug() {
+		test.ValidIP(test.P(), pkt.Bytes())
+	}
+	return hdrLen, nil
+}
+
+// readFirst read the echo request recved by Listener, return 0 if it has been read
+func (c *Conn) readFirst(pkt *packet.Packet) (int, error) {
+	c.firstMu.Lock()
+	defer c.firstMu.Unlock()
+	if c.first == nil {
+		return 0, nil
+	} else if pkt.Data() < len(c.first) {
+		return 0, errorx.ShortBuff(len(c.first), pkt.Data())
+	}
+
+	n := copy(pkt.Bytes(), c.first)
+	c.first = nil
+	return n, nil
+}
+
+// Write write icmp packet to remote address, the icmp checksum
+// should be set by caller
+func (c *Conn) Write(pkt *packet.Packet) (err error) {
+	_, err = c.raw.Write(pkt.Bytes())
+	return errors.WithStack(err)
+}
+
+func (c *Conn) WriteRaw(ip *packet.Packet) (err error) {
+	if debug.Debug() {
+		test.ValidIP(test.P(), ip.Bytes())
+	}
+	return helper.WriteRawIP(c.hdrincl, ip.Bytes())
+}
+
+func (c *Conn) Inject(pkt *packet.Packet) (err error) {
+	defer pkt.DetachN(c.ipstack.Size())
+	c.ipstack.AttachInbound(pkt)
+	return c.InjectRaw(pkt)
+}
+
+func (c *Conn) InjectRaw(ip *packet.Packet) (err error) {
+	if debug.Debug() {
+		test.ValidIP(test.P(), ip.Bytes())
+	}
+	return helper.WriteRawIP(c.hdrincl, ip.Bytes())
+}
+
+func (c *Conn) LocalAddr() netip.AddrPort          { return c.laddr }
+func (c *Conn) RemoteAddr() netip.AddrPort         { return c.raddr }
+func (c *Conn) SetDeadline(t time.Time) error      { return c.raw.SetDeadline(t) }
+func (c *Conn) SetReadDeadline(t time.Time) error  { return c.raw.SetReadDeadline(t) }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.raw.SetWriteDeadline(t) }
+func (c *Conn) Close() error                       { return c.close(nil) }
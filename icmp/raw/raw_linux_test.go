@@ -0,0 +1,212 @@
+//go:build linux
+// +build linux
+
+package raw
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/lysShub/netkit/packet"
+	"github.com/lysShub/rawsock/test"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func buildEcho(typ header.ICMPv4Type, ident, seq uint16, msg []byte) header.ICMPv4 {
+	var b = make(header.ICMPv4, header.ICMPv4MinimumSize+len(msg))
+	b.SetType(typ)
+	b.SetCode(0)
+	b.SetIdent(ident)
+	b.SetSequence(seq)
+	copy(b.Payload(), msg)
+	b.SetChecksum(^checksum.Checksum(b, 0))
+	return b
+}
+
+func Test_Connect(t *testing.T) {
+	t.Run("loopback", func(t *testing.T) {
+		var (
+			caddr = netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), 0)
+			saddr = netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), 0)
+			msg   = []byte("hello world!")
+		)
+
+		raw, err := Connect(caddr, saddr)
+		require.NoError(t, err)
+		defer raw.Close()
+		ident := raw.LocalAddr().Port()
+		require.NotZero(t, ident)
+		require.Equal(t, ident, raw.RemoteAddr().Port())
+
+		req := buildEcho(header.ICMPv4Echo, ident, 1, msg)
+		err = raw.Write(packet.Make().Append(req...))
+		require.NoError(t, err)
+
+		// system stack will reply the echo request
+		var p = packet.Make(0, 1536)
+		for {
+			err = raw.Read(p.Sets(0, 1536))
+			require.NoError(t, err)
+
+			icmp := header.ICMPv4(p.Bytes())
+			require.Equal(t, ident, icmp.Ident())
+			if icmp.Type() == header.ICMPv4EchoReply {
+				require.Equal(t, msg, icmp.Payload())
+				break
+			}
+		}
+	})
+}
+
+func Test_Listen(t *testing.T) {
+	var (
+		saddr = netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), 0)
+		caddr = netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), 0)
+		msg   = bytes.Repeat([]byte("hello world!"), 16) // longer than bpf.SnapICMP
+	)
+
+	l, err := Listen(saddr)
+	require.NoError(t, err)
+	defer l.Close()
+
+	raw, err := Connect(caddr, l.Addr())
+	require.NoError(t, err)
+	defer raw.Close()
+	ident := raw.LocalAddr().Port()
+
+	go func() {
+		time.Sleep(time.Second)
+		req := buildEcho(header.ICMPv4Echo, ident, 1, msg)
+		err := raw.Write(packet.Make().Append(req...))
+		require.NoError(t, err)
+	}()
+
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, raw.LocalAddr(), conn.RemoteAddr())
+	require.Equal(t, ident, conn.LocalAddr().Port())
+
+	// first read is the echo request recved by Listener
+	var p = packet.Make(0, 1536)
+	err = conn.Read(p)
+	require.NoError(t, err)
+	icmp := header.ICMPv4(p.Bytes())
+	require.Equal(t, header.ICMPv4Echo, icmp.Type())
+	require.Equal(t, ident, icmp.Ident())
+	require.Equal(t, uint16(1), icmp.Sequence())
+	require.Equal(t, msg, icmp.Payload())
+
+	req := buildEcho(header.ICMPv4Echo, ident, 2, msg)
+	err = raw.Write(packet.Make().Append(req...))
+	require.NoError(t, err)
+
+	err = conn.Read(p.Sets(0, 1536))
+	require.NoError(t, err)
+	icmp = header.ICMPv4(p.Bytes())
+	require.Equal(t, header.ICMPv4Echo, icmp.Type())
+	require.Equal(t, ident, icmp.Ident())
+	require.Equal(t, uint16(2), icmp.Sequence())
+	require.Equal(t, msg, icmp.Payload())
+}
+
+func Test_Listen_Unspecified(t *testing.T) {
+	l, err := Listen(netip.AddrPortFrom(netip.IPv4Unspecified(), 0))
+	require.NoError(t, err)
+	defer l.Close()
+	require.True(t, l.Addr().Addr().IsUnspecified())
+
+	for _, addr := range []netip.Addr{netip.AddrFrom4([4]byte{127, 0, 0, 1}), test.LocIP()} {
+		raw, err := Connect(netip.AddrPortFrom(addr, 0), netip.AddrPortFrom(addr, 0))
+		require.NoError(t, err)
+		defer raw.Close()
+		ident := raw.LocalAddr().Port()
+
+		req := buildEcho(header.ICMPv4Echo, ident, 1, []byte("hello"))
+		require.NoError(t, raw.Write(packet.Make().Append(req...)))
+
+		conn, err := l.Accept()
+		require.NoError(t, err)
+		defer conn.Close()
+		require.Equal(t, netip.AddrPortFrom(addr, ident), conn.LocalAddr())
+		require.Equal(t, raw.LocalAddr(), conn.RemoteAddr())
+	}
+}
+
+func Test_Listen_IPv6(t *testing.T) {
+	_, err := Listen(netip.AddrPortFrom(netip.IPv6Loopback(), 0))
+	require.Error(t, err)
+}
+
+func Test_Inject(t *testing.T) {
+	var (
+		laddr = netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), 0)
+		raddr = netip.AddrPortFrom(netip.MustParseAddr("127.0.0.2"), 0)
+		msg   = []byte("hello world!")
+	)
+
+	raw, err := Connect(laddr, raddr)
+	require.NoError(t, err)
+	defer raw.Close()
+	ident := raw.LocalAddr().Port()
+
+	req := buildEcho(header.ICMPv4Echo, ident, 1, msg)
+	err = raw.Inject(packet.Make().Append(req...))
+	require.NoError(t, err)
+
+	var p = packet.Make(0, 1536)
+	err = raw.Read(p)
+	require.NoError(t, err)
+
+	iphdr := header.IPv4(p.SetHead(0).Bytes())
+	require.Equal(t, raddr.Addr().String(), iphdr.SourceAddress().String())
+	require.Equal(t, laddr.Addr().String(), iphdr.DestinationAddress().String())
+	icmp := header.ICMPv4(iphdr.Payload())
+	require.Equal(t, header.ICMPv4Echo, icmp.Type())
+	require.Equal(t, msg, icmp.Payload())
+}
+
+func Test_Raw(t *testing.T) {
+	var (
+		laddr = netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), 0)
+		raddr = netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), 0)
+		msg   = []byte("hello world!")
+	)
+
+	raw, err := Connect(laddr, raddr)
+	require.NoError(t, err)
+	defer raw.Close()
+	ident := raw.LocalAddr().Port()
+
+	req := buildEcho(header.ICMPv4Echo, ident, 1, msg)
+	var ip = header.IPv4(make([]byte, header.IPv4MinimumSize+len(req)))
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(ip)),
+		ID:          0x1234,
+		Flags:       header.IPv4FlagDontFragment,
+		TTL:         33,
+		Protocol:    uint8(header.ICMPv4ProtocolNumber),
+		SrcAddr:     tcpip.AddrFrom4(laddr.Addr().As4()),
+		DstAddr:     tcpip.AddrFrom4(raddr.Addr().As4()),
+	})
+	ip.SetChecksum(^ip.CalculateChecksum())
+	copy(ip.Payload(), req)
+
+	err = raw.WriteRaw(packet.Make().Append(ip...))
+	require.NoError(t, err)
+
+	var p = packet.Make(0, 1536)
+	err = raw.ReadRaw(p)
+	require.NoError(t, err)
+
+	iphdr := header.IPv4(p.Bytes())
+	require.Equal(t, uint8(33), iphdr.TTL())
+	require.Equal(t, uint16(0x1234), iphdr.ID())
+	require.Equal(t, uint8(header.IPv4FlagDontFragment), iphdr.Flags())
+	require.Equal(t, []byte(req), iphdr.Payload())
+}
@@ -1,10 +1,14 @@
 package rawsock
 
 import (
+	"context"
 	"net"
 	"net/netip"
+	"time"
 
 	"github.com/lysShub/netkit/packet"
+	"github.com/lysShub/rawsock/helper/bind"
+	"github.com/pkg/errors"
 )
 
 type Listener interface {
@@ -25,35 +29,138 @@ type Listener interface {
 	//   }
 	Accept() (RawConn, error)
 
-	// todo:
-	// AcceptCtx(ctx context.Context)(RawConn, error)
+	// AcceptCtx accept transport connection, cancel ctx will stop the
+	// pending accept, but not close the listener.
+	AcceptCtx(ctx context.Context) (RawConn, error)
 
 	Addr() netip.AddrPort
 
 	Close() error
 }
 
-// todo: 支持raw读写
 // todo: 删除Read会将tail作为容量进行读取
-// todo: 支持deadline
 type RawConn interface {
 
 	// Read read tcp/udp/icmp packet from remote address
 	Read(pkt *packet.Packet) (err error)
-	// ReadRaw(ip *packet.Packet) (err error)
+	// ReadRaw read ip packet from remote address, contain the ip header
+	// that actually recved.
+	ReadRaw(ip *packet.Packet) (err error)
 
 	// Write write tcp/udp/icmp packet to remote address
 	Write(pkt *packet.Packet) (err error)
-	// WriteRaw( ip *packet.Packet) (err error)
+	// WriteRaw write ip packet to remote address, the ip header is build by
+	// caller, such as TTL, ID, DF and options.
+	WriteRaw(ip *packet.Packet) (err error)
 
 	// Inject inject tcp/udp/icmp packet to local address
 	Inject(pkt *packet.Packet) (err error)
+	// InjectRaw inject ip packet to local address, the ip header is build by
+	// caller.
+	InjectRaw(ip *packet.Packet) (err error)
 
 	LocalAddr() netip.AddrPort
 	RemoteAddr() netip.AddrPort
+
+	// SetDeadline set read and write deadline, same as net.Conn, blocked
+	// Read/Write will return os.ErrDeadlineExceeded after deadline.
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+
 	Close() error
 }
 
+// BatchConn is optional for RawConn, read/write multiple packets by one syscall
+type BatchConn interface {
+
+	// ReadBatch read packets, return the number of packets read, every
+	// packet is same as Read.
+	ReadBatch(pkts []*packet.Packet) (n int, err error)
+
+	// WriteBatch write packets, return the number of packets written, every
+	// packet is same as Write.
+	WriteBatch(pkts []*packet.Packet) (n int, err error)
+}
+
+// ReadBatch read packets from conn, if conn not implement BatchConn, only
+// read one packet.
+func ReadBatch(conn RawConn, pkts []*packet.Packet) (n int, err error) {
+	if b, ok := conn.(BatchConn); ok {
+		return b.ReadBatch(pkts)
+	} else if len(pkts) == 0 {
+		return 0, nil
+	}
+
+	if err = conn.Read(pkts[0]); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// WriteBatch write packets to conn, if conn not implement BatchConn, will
+// write one by one.
+func WriteBatch(conn RawConn, pkts []*packet.Packet) (n int, err error) {
+	if b, ok := conn.(BatchConn); ok {
+		return b.WriteBatch(pkts)
+	}
+
+	for i, pkt := range pkts {
+		if err = conn.Write(pkt); err != nil {
+			return i, err
+		}
+	}
+	return len(pkts), nil
+}
+
+// ReadCtx read packet from conn, cancel ctx will interrupt the blocked Read
+// by set a past read deadline, and the read deadline is reset after return,
+// so it should not be used concurrently with other Read or SetReadDeadline.
+func ReadCtx(ctx context.Context, conn RawConn, pkt *packet.Packet) error {
+	return withCtx(ctx, conn.SetReadDeadline, func() error { return conn.Read(pkt) })
+}
+
+// WriteCtx write packet to conn, same as ReadCtx but use write deadline.
+func WriteCtx(ctx context.Context, conn RawConn, pkt *packet.Packet) error {
+	return withCtx(ctx, conn.SetWriteDeadline, func() error { return conn.Write(pkt) })
+}
+
+func withCtx(ctx context.Context, setDeadline func(time.Time) error, fn func() error) (err error) {
+	if err = ctx.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+	if d, ok := ctx.Deadline(); ok {
+		if err = setDeadline(d); err != nil {
+			return err
+		}
+	}
+
+	var done = make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		setDeadline(time.Now())
+		close(done)
+	})
+	err = fn()
+	if !stop() {
+		<-done
+	}
+	if e := setDeadline(time.Time{}); err == nil {
+		err = e
+	}
+
+	if err != nil && ctx.Err() != nil {
+		return errors.WithStack(ctx.Err())
+	}
+	return err
+}
+
+// Cleanup restore system settings changed by Conn, such as nic offload changed
+// by SetGRO, regardless of Conn not closed. should be called before process
+// exit, such as after received SIGINT.
+func Cleanup() error {
+	return bind.RestoreOffload()
+}
+
 func LocalAddr() netip.Addr {
 	c, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: []byte{8, 8, 8, 8}, Port: 53})
 	if err != nil {
@@ -64,7 +171,7 @@ func LocalAddr() netip.Addr {
 }
 
 func LocalAddr6() netip.Addr {
-	c, err := net.DialUDP("udp6", nil, &net.UDPAddr{IP: net.ParseIP("::FFFF:8.8.8.8"), Port: 53})
+	c, err := net.DialUDP("udp6", nil, &net.UDPAddr{IP: net.ParseIP("2001:4860:4860::8888"), Port: 53})
 	if err != nil {
 		panic(err)
 	}
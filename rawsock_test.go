@@ -1,10 +1,18 @@
 package rawsock_test
 
 import (
+	"context"
+	"net/netip"
 	"os/exec"
 	"testing"
+	"time"
 
+	"github.com/lysShub/netkit/packet"
+	"github.com/lysShub/rawsock"
+	"github.com/lysShub/rawsock/test"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
 )
 
 func Test_Gofmt(t *testing.T) {
@@ -14,3 +22,40 @@ func Test_Gofmt(t *testing.T) {
 	require.NoError(t, err)
 	require.Empty(t, string(out))
 }
+
+func Test_ReadCtx(t *testing.T) {
+	c, s := test.NewMockRaw(
+		t, header.TCPProtocolNumber,
+		netip.AddrPortFrom(test.LocIP(), test.RandPort()), netip.AddrPortFrom(test.LocIP(), test.RandPort()),
+	)
+	defer c.Close()
+	defer s.Close()
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(time.Millisecond*100, cancel)
+
+		err := rawsock.ReadCtx(ctx, s, packet.Make(0, 1536))
+		require.True(t, errors.Is(err, context.Canceled), err)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+
+		err := rawsock.ReadCtx(ctx, s, packet.Make(0, 1536))
+		require.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	})
+
+	t.Run("read-after-cancel", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+
+		tcp := test.BuildTCPSync(t, c.LocalAddr(), c.RemoteAddr())
+		require.NoError(t, rawsock.WriteCtx(ctx, c, packet.Make(64).Append(tcp...)))
+
+		var pkt = packet.Make(0, 1536)
+		require.NoError(t, rawsock.ReadCtx(ctx, s, pkt))
+		require.Equal(t, []byte(tcp), pkt.Bytes())
+	})
+}
@@ -1,9 +1,11 @@
 package divert
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
 	"net/netip"
+	"os"
 	"sync"
 	"time"
 
@@ -48,6 +50,9 @@ type Listener struct {
 
 	// priority int16
 
+	// packets recved by background goroutine, because divert recv can't be interrupted
+	synCh chan synPacket
+
 	conns   map[itcp.ID]struct{}
 	connsMu sync.RWMutex
 
@@ -62,11 +67,6 @@ func Listen(laddr netip.AddrPort, opts ...rawsock.Option) (*Listener, error) {
 		conns: make(map[itcp.ID]struct{}, 16),
 	}
 
-	// usaully should listen on all nic, but we juse listen on default nic
-	if laddr.Addr().IsUnspecified() {
-		laddr = netip.AddrPortFrom(rawsock.LocalAddr(), laddr.Port())
-	}
-
 	var err error
 	l.tcp, l.addr, err = bind.BindLocal(header.TCPProtocolNumber, laddr, l.cfg.UsedPort)
 	if err != nil {
@@ -75,7 +75,17 @@ func Listen(laddr netip.AddrPort, opts ...rawsock.Option) (*Listener, error) {
 	}
 
 	var filter string
-	if l.addr.Addr().IsLoopback() {
+	if l.addr.Addr().IsUnspecified() {
+		// listen on all nic
+		var ip = "ip"
+		if !l.addr.Addr().Is4() {
+			ip = "ipv6"
+		}
+		filter = fmt.Sprintf(
+			"%s and tcp and ((loopback and remotePort=%d) or (!loopback and localPort=%d))",
+			ip, l.addr.Port(), l.addr.Port(),
+		)
+	} else if l.addr.Addr().IsLoopback() {
 		filter = fmt.Sprintf(
 			"tcp and remotePort=%d and remoteAddr=%s",
 			l.addr.Port(), l.addr.Addr().String(),
@@ -92,9 +102,39 @@ func Listen(laddr netip.AddrPort, opts ...rawsock.Option) (*Listener, error) {
 		l.Close()
 		return nil, err
 	}
+
+	l.synCh = make(chan synPacket, 16)
+	go l.recvService()
 	return l, err
 }
 
+type synPacket struct {
+	ip   []byte
+	addr divert.Address
+}
+
+func (l *Listener) recvService() {
+	defer close(l.synCh)
+	var min, max = itcp.SizeRange(l.addr.Addr().Is4())
+
+	for {
+		var p = synPacket{ip: make([]byte, max)}
+		n, err := l.raw.Recv(p.ip, &p.addr)
+		if err != nil {
+			l.close(err)
+			return
+		} else if n < min {
+			continue
+		}
+		p.ip = p.ip[:n]
+
+		select {
+		case l.synCh <- p:
+		default: // drop, tcp will retransmit SYN
+		}
+	}
+}
+
 // set divert priority, for Listen will use p and p+1
 func Priority(p int16) rawsock.Option {
 	return func(c *rawsock.Config) {
@@ -119,28 +159,36 @@ func (l *Listener) close(cause error) error {
 func (l *Listener) Addr() netip.AddrPort { return l.addr }
 
 func (l *Listener) Accept() (rawsock.RawConn, error) {
-	var min, max = itcp.SizeRange(l.addr.Addr().Is4())
-	var addr divert.Address
+	return l.AcceptCtx(context.Background())
+}
 
-	var b = make([]byte, max)
+func (l *Listener) AcceptCtx(ctx context.Context) (rawsock.RawConn, error) {
 	for {
-		n, err := l.raw.Recv(b[:max], &addr)
-		if err != nil {
-			return nil, l.close(err)
-		} else if n < min {
-			return nil, errors.Errorf("recved invalid ip packet, bytes %d", n)
+		var p synPacket
+		select {
+		case <-ctx.Done():
+			return nil, errors.WithStack(ctx.Err())
+		case e, ok := <-l.synCh:
+			if !ok {
+				return nil, l.close(nil)
+			}
+			p = e
 		}
+		b, n, addr := p.ip, len(p.ip), p.addr
 
-		var id = itcp.ID{Local: l.addr}
+		// if listen on unspecified address, local address is the packet's destination
+		var id itcp.ID
 		switch header.IPVersion(b) {
 		case 4:
 			iphdr := header.IPv4(b[:n])
 			tcphdr := header.TCP(iphdr.Payload())
+			id.Local = netip.AddrPortFrom(netip.AddrFrom4(iphdr.DestinationAddress().As4()), l.addr.Port())
 			id.Remote = netip.AddrPortFrom(netip.AddrFrom4(iphdr.SourceAddress().As4()), tcphdr.SourcePort())
 			id.ISN = tcphdr.SequenceNumber()
 		case 6:
 			iphdr := header.IPv6(b[:n])
 			tcphdr := header.TCP(iphdr.Payload())
+			id.Local = netip.AddrPortFrom(netip.AddrFrom16(iphdr.DestinationAddress().As16()), l.addr.Port())
 			id.Remote = netip.AddrPortFrom(netip.AddrFrom16(iphdr.SourceAddress().As16()), tcphdr.SourcePort())
 			id.ISN = tcphdr.SequenceNumber()
 		default:
@@ -317,50 +365,76 @@ func (c *Conn) init(cfg *rawsock.Config) (err error) {
 }
 
 func (c *Conn) Read(pkt *packet.Packet) (err error) {
+	hdr, err := c.read(pkt)
+	if err != nil {
+		return err
+	}
+	pkt.SetHead(pkt.Head() + hdr)
+	return nil
+}
+
+func (c *Conn) ReadRaw(ip *packet.Packet) (err error) {
+	_, err = c.read(ip)
+	return err
+}
+
+func (c *Conn) read(pkt *packet.Packet) (hdr int, err error) {
 	n, err := c.raw.Recv(pkt.Bytes(), nil)
 	if err != nil {
 		if errors.Is(err, windows.ERROR_INSUFFICIENT_BUFFER) {
-			return errorx.ShortBuff(-1, pkt.Data())
+			return 0, errorx.ShortBuff(-1, pkt.Data())
 		}
-		return err
+		return 0, err
 	} else if n == 0 {
-		return c.Read(pkt)
+		return c.read(pkt)
 	}
 
 	pkt.SetData(n)
-	hdr, err := helper.IPCheck(pkt.Bytes())
+	hdr, err = helper.CheckIP(pkt.Bytes())
 	if err != nil {
-		return err
+		return 0, err
 	}
 	if debug.Debug() {
 		test.ValidIP(test.P(), pkt.Bytes())
 	}
-	pkt.SetHead(pkt.Head() + int(hdr))
-	return nil
+	return hdr, nil
 }
 
 func (c *Conn) Write(pkt *packet.Packet) (err error) {
 	defer pkt.DetachN(c.ipstack.Size())
 	c.ipstack.AttachOutbound(pkt)
+	return c.WriteRaw(pkt)
+}
+
+func (c *Conn) WriteRaw(ip *packet.Packet) (err error) {
 	if debug.Debug() {
-		test.ValidIP(test.P(), pkt.Bytes())
+		test.ValidIP(test.P(), ip.Bytes())
 	}
 
-	_, err = c.raw.Send(pkt.Bytes(), outboundAddr)
+	_, err = c.raw.Send(ip.Bytes(), outboundAddr)
 	return err
 }
 
 func (c *Conn) Inject(pkt *packet.Packet) (err error) {
 	defer pkt.DetachN(c.ipstack.Size())
 	c.ipstack.AttachInbound(pkt)
+	return c.InjectRaw(pkt)
+}
+
+func (c *Conn) InjectRaw(ip *packet.Packet) (err error) {
 	if debug.Debug() {
-		test.ValidIP(test.P(), pkt.Bytes())
+		test.ValidIP(test.P(), ip.Bytes())
 	}
 
-	_, err = c.raw.Send(pkt.Bytes(), c.injectAddr)
+	_, err = c.raw.Send(ip.Bytes(), c.injectAddr)
 	return err
 }
 
 func (c *Conn) LocalAddr() netip.AddrPort  { return c.Local }
 func (c *Conn) RemoteAddr() netip.AddrPort { return c.Remote }
-func (c *Conn) Close() error               { return c.close(nil) }
+
+// todo: divert recv can't be interrupted, not support deadline
+func (c *Conn) SetDeadline(t time.Time) error      { return errors.WithStack(os.ErrNoDeadline) }
+func (c *Conn) SetReadDeadline(t time.Time) error  { return errors.WithStack(os.ErrNoDeadline) }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return errors.WithStack(os.ErrNoDeadline) }
+func (c *Conn) Close() error                       { return c.close(nil) }
@@ -10,11 +10,15 @@ import (
 	"math/rand"
 	"net"
 	"net/netip"
+	"os"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/lysShub/netkit/packet"
+	"github.com/lysShub/rawsock"
 	"github.com/lysShub/rawsock/test"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/sync/errgroup"
 	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
@@ -171,3 +175,169 @@ func Test_Conn(t *testing.T) {
 
 	fmt.Println(gs.Wait())
 }
+
+func Test_PacketMMAP(t *testing.T) {
+	// remote is nic in other network namespace, not listen, will reply RST
+	var (
+		caddr = netip.AddrPortFrom(netip.MustParseAddr("10.99.8.1"), test.RandPort())
+		saddr = netip.AddrPortFrom(netip.MustParseAddr("10.99.8.2"), test.RandPort())
+	)
+	test.CreateVeth(t, "rawsock-mmap",
+		[]netip.Prefix{netip.PrefixFrom(caddr.Addr(), 24)},
+		[]netip.Prefix{netip.PrefixFrom(saddr.Addr(), 24)},
+	)
+
+	conn, err := Connect(caddr, saddr, rawsock.SetGRO(false), rawsock.PacketMMAP(0, 0, 0))
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Nil(t, conn.Raw())
+
+	syn := test.BuildTCPSync(t, caddr, saddr)
+	n, err := conn.WriteBatch([]*packet.Packet{packet.Make(64).Append(syn...)})
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second*3)))
+	var pkts = []*packet.Packet{packet.Make(0, 1536), packet.Make(0, 1536)}
+	n, err = conn.ReadBatch(pkts)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, n, 1)
+
+	tcphdr := header.TCP(pkts[0].Bytes())
+	require.Equal(t, saddr.Port(), tcphdr.SourcePort())
+	require.Equal(t, header.TCPFlagRst|header.TCPFlagAck, tcphdr.Flags())
+	require.Equal(t, header.TCP(syn).SequenceNumber()+1, tcphdr.AckNumber())
+}
+
+func Test_Inject(t *testing.T) {
+	var (
+		caddr = netip.AddrPortFrom(netip.MustParseAddr("10.99.9.1"), test.RandPort())
+		saddr = netip.AddrPortFrom(netip.MustParseAddr("10.99.9.2"), test.RandPort())
+	)
+	test.CreateVeth(t, "rawsock-inject",
+		[]netip.Prefix{netip.PrefixFrom(caddr.Addr(), 24)},
+		[]netip.Prefix{netip.PrefixFrom(saddr.Addr(), 24)},
+	)
+
+	conn, err := Connect(caddr, saddr, rawsock.SetGRO(false))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// local stack recv
+	raw, err := net.ListenIP("ip4:tcp", &net.IPAddr{IP: caddr.Addr().AsSlice()})
+	require.NoError(t, err)
+	defer raw.Close()
+	recv := func() header.IPv4 {
+		var b = make([]byte, 1536)
+		require.NoError(t, raw.SetReadDeadline(time.Now().Add(time.Second*3)))
+		for {
+			n, err := raw.Read(b)
+			require.NoError(t, err)
+			iphdr := header.IPv4(b[:n])
+			if header.TCP(iphdr.Payload()).DestinationPort() == caddr.Port() {
+				return iphdr
+			}
+		}
+	}
+
+	t.Run("Inject", func(t *testing.T) {
+		tcp := test.BuildTCPSync(t, saddr, caddr)
+		err := conn.Inject(packet.Make(64).Append(tcp...))
+		require.NoError(t, err)
+
+		iphdr := recv()
+		require.Equal(t, saddr.Addr().String(), iphdr.SourceAddress().String())
+		require.Equal(t, []byte(tcp), []byte(iphdr.Payload()))
+	})
+
+	t.Run("InjectRaw", func(t *testing.T) {
+		ip := test.BuildRawTCP(t, saddr, caddr, []byte("hello"))
+		ip.SetTTL(34)
+		ip.SetChecksum(0)
+		ip.SetChecksum(^ip.CalculateChecksum())
+		err := conn.InjectRaw(packet.Make().Append(ip...))
+		require.NoError(t, err)
+
+		iphdr := recv()
+		require.Equal(t, uint8(34), iphdr.TTL())
+		require.Equal(t, ip.Payload(), iphdr.Payload())
+	})
+}
+
+func Test_Loopback(t *testing.T) {
+	for _, addr := range []netip.Addr{netip.AddrFrom4([4]byte{127, 0, 0, 1}), test.LocIP(), netip.IPv6Loopback()} {
+		t.Run(addr.String(), func(t *testing.T) {
+			var (
+				caddr = netip.AddrPortFrom(addr, test.RandPort())
+				saddr = netip.AddrPortFrom(addr, test.RandPort())
+			)
+
+			server, err := Connect(saddr, caddr, rawsock.SetGRO(false))
+			require.NoError(t, err)
+			defer server.Close()
+			client, err := Connect(caddr, saddr, rawsock.SetGRO(false))
+			require.NoError(t, err)
+			defer client.Close()
+
+			syn := test.BuildTCPSync(t, caddr, saddr)
+			require.NoError(t, client.Write(packet.Make(64).Append(syn...)))
+
+			require.NoError(t, server.SetReadDeadline(time.Now().Add(time.Second*3)))
+			var pkt = packet.Make(0, 1536)
+			require.NoError(t, server.Read(pkt))
+			require.Equal(t, []byte(syn), pkt.Bytes())
+
+			// not recv outgoing packet
+			require.NoError(t, client.SetReadDeadline(time.Now().Add(time.Millisecond*500)))
+			for {
+				pkt = packet.Make(0, 1536)
+				if err := client.Read(pkt); err != nil {
+					require.True(t, errors.Is(err, os.ErrDeadlineExceeded), err)
+					break
+				}
+				require.Equal(t, saddr.Port(), header.TCP(pkt.Bytes()).SourcePort())
+			}
+		})
+	}
+}
+
+func Test_IPv6_NDP(t *testing.T) {
+	// remote is on-link nic in other network namespace, resolve it's hardware address by NDP
+	var (
+		caddr = netip.AddrPortFrom(netip.MustParseAddr("fd01:9a7::1"), test.RandPort())
+		saddr = netip.AddrPortFrom(netip.MustParseAddr("fd01:9a7::2"), test.RandPort())
+	)
+	test.CreateVeth(t, "rawsock-eth",
+		[]netip.Prefix{netip.PrefixFrom(caddr.Addr(), 64)},
+		[]netip.Prefix{netip.PrefixFrom(saddr.Addr(), 64)},
+	)
+
+	for _, e := range []struct{ mmap, gro bool }{
+		{false, false}, {true, false}, {false, true}, {true, true},
+	} {
+		var opts = []rawsock.Option{rawsock.SetGRO(false), rawsock.ChecksumOffload(true)}
+		if e.mmap {
+			opts = append(opts, rawsock.PacketMMAP(0, 0, 0))
+		}
+		if e.gro {
+			opts = append(opts, rawsock.UserGRO())
+		}
+		conn, err := Connect(netip.AddrPortFrom(caddr.Addr(), test.RandPort()), saddr, opts...)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		// veth support checksum offload
+		require.True(t, conn.Offload())
+
+		// remote not listen, will reply RST
+		syn := test.BuildTCPSync(t, conn.LocalAddr(), saddr)
+		require.NoError(t, conn.Write(packet.Make(64).Append(syn...)))
+
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second*3)))
+		var pkt = packet.Make(0, 1536)
+		require.NoError(t, conn.Read(pkt))
+		tcphdr := header.TCP(pkt.Bytes())
+		require.Equal(t, saddr.Port(), tcphdr.SourcePort())
+		require.Equal(t, header.TCPFlagRst|header.TCPFlagAck, tcphdr.Flags())
+	}
+}
@@ -3,6 +3,8 @@ package tcp
 import (
 	"net/netip"
 
+	"github.com/lysShub/rawsock/helper"
+	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 )
 
@@ -25,3 +27,54 @@ func SizeRange(ipv4 bool) (min, max int) {
 	}
 	return
 }
+
+// TrimSnap fix the tcp packet that truncated by bpf snap length, drop payload
+// and update ip length and checksums. dropped data such as TFO data carried
+// by SYN will be retransmitted by peer after handshake.
+func TrimSnap(ip []byte) []byte {
+	hdrLen, err := helper.IPHeaderLen(ip)
+	if err != nil {
+		return ip
+	}
+
+	var src, dst tcpip.Address
+	switch header.IPVersion(ip) {
+	case 4:
+		iphdr := header.IPv4(ip)
+		if int(iphdr.TotalLength()) <= len(ip) {
+			return ip
+		}
+		src, dst = iphdr.SourceAddress(), iphdr.DestinationAddress()
+	case 6:
+		iphdr := header.IPv6(ip)
+		if int(iphdr.PayloadLength())+header.IPv6MinimumSize <= len(ip) {
+			return ip
+		}
+		src, dst = iphdr.SourceAddress(), iphdr.DestinationAddress()
+	default:
+		return ip
+	}
+
+	tcphdr := header.TCP(ip[hdrLen:])
+	if len(tcphdr) < header.TCPMinimumSize {
+		return ip
+	}
+	n := hdrLen + int(tcphdr.DataOffset())
+	if n > len(ip) {
+		return ip // truncated tcp header, invalid
+	}
+	ip, tcphdr = ip[:n], tcphdr[:tcphdr.DataOffset()]
+
+	if header.IPVersion(ip) == 4 {
+		iphdr := header.IPv4(ip)
+		iphdr.SetTotalLength(uint16(n))
+		iphdr.SetChecksum(0)
+		iphdr.SetChecksum(^iphdr.CalculateChecksum())
+	} else {
+		header.IPv6(ip).SetPayloadLength(uint16(n - header.IPv6MinimumSize))
+	}
+	sum := header.PseudoHeaderChecksum(header.TCPProtocolNumber, src, dst, uint16(len(tcphdr)))
+	tcphdr.SetChecksum(0)
+	tcphdr.SetChecksum(^tcphdr.CalculateChecksum(sum))
+	return ip
+}
@@ -4,6 +4,7 @@
 package raw
 
 import (
+	"context"
 	"net"
 	"net/netip"
 	"sync"
@@ -16,6 +17,7 @@ import (
 	"github.com/lysShub/rawsock/helper/bind"
 	itcp "github.com/lysShub/rawsock/tcp/internal"
 	"github.com/pkg/errors"
+	xbpf "golang.org/x/net/bpf"
 
 	"github.com/lysShub/netkit/debug"
 	"github.com/lysShub/rawsock/helper/bpf"
@@ -30,7 +32,9 @@ type Listener struct {
 
 	tcp *net.TCPListener
 
-	raw *net.IPConn
+	raw *helper.IPConn
+	// serialize AcceptCtx reads
+	reader *helper.CtxReader
 
 	// AddrPort:ISN
 	conns   map[itcp.ID]struct{}
@@ -42,48 +46,117 @@ type Listener struct {
 var _ rawsock.Listener = (*Listener)(nil)
 
 func Listen(laddr netip.AddrPort, opts ...rawsock.Option) (*Listener, error) {
-	var l = &Listener{
-		cfg:   rawsock.Options(opts...),
-		conns: make(map[itcp.ID]struct{}, 16),
-	}
+	var l = newListener(rawsock.Options(opts...))
 	var err error
 
-	// usaully should listen on all nic, but we juse listen on default nic
-	if laddr.Addr().IsUnspecified() {
-		laddr = netip.AddrPortFrom(rawsock.LocalAddr(), laddr.Port())
-	}
-
 	l.tcp, l.addr, err = bind.ListenTCPLocal(laddr, l.cfg.UsedPort)
 	if err != nil {
 		return nil, l.close(err)
 	}
 
-	l.raw, err = net.ListenIP(
-		"ip:tcp",
-		&net.IPAddr{IP: l.addr.Addr().AsSlice(), Zone: laddr.Addr().Zone()},
-	)
-	if err != nil {
+	if err = l.init(
+		bpf.FilterDstPortAndTCPSyn(l.addr.Port(), bpf.SnapTCP),
+	); err != nil {
 		return nil, l.close(err)
 	}
+	return l, nil
+}
 
-	if raw, err := l.raw.SyscallConn(); err != nil {
-		return nil, l.close(err)
-	} else {
-		if err = bpf.SetRawBPF(
-			raw,
-			bpf.FilterDstPortAndTCPSyn(l.addr.Port()),
-		); err != nil {
-			return nil, l.close(err)
+func newListener(cfg *rawsock.Config) *Listener {
+	return &Listener{
+		cfg:   cfg,
+		conns: make(map[itcp.ID]struct{}, 16),
+	}
+}
+
+// init create raw socket that recv packets passed filter
+func (l *Listener) init(filter []xbpf.Instruction) (err error) {
+	l.raw, err = helper.ListenIP(header.TCPProtocolNumber, l.addr.Addr())
+	if err != nil {
+		return err
+	}
+
+	if err = l.raw.SetBPF(filter); err != nil {
+		return err
+	}
+
+	_, max := itcp.SizeRange(l.addr.Addr().Is4())
+	l.reader = helper.NewCtxReader(l.raw.Read, max)
+	return nil
+}
+
+// Shards spread flows to multiple raw sockets by flow hash, every shard
+// Listener can Accept in its own goroutine. ipv4 flow is hashed by addresses
+// and ports, ipv6 flow only by ports, see bpf.FilterShard.
+type Shards struct {
+	addr netip.AddrPort
+
+	tcp *net.TCPListener
+
+	shards []*Listener
+
+	closeErr errorx.CloseErr
+}
+
+// ListenShards listen with n raw sockets, a flow always be accepted by the
+// same shard.
+func ListenShards(laddr netip.AddrPort, n int, opts ...rawsock.Option) (*Shards, error) {
+	if n <= 0 {
+		return nil, errors.Errorf("invalid shards %d", n)
+	}
+	var s = &Shards{}
+	var cfg = rawsock.Options(opts...)
+	var err error
+
+	s.tcp, s.addr, err = bind.ListenTCPLocal(laddr, cfg.UsedPort)
+	if err != nil {
+		return nil, s.close(err)
+	}
+
+	for i := 0; i < n; i++ {
+		var l = newListener(cfg)
+		l.addr = s.addr
+		s.shards = append(s.shards, l)
+
+		filter, err := bpf.FilterShard(
+			bpf.FilterDstPortAndTCPSyn(l.addr.Port(), bpf.SnapTCP),
+			uint32(n), uint32(i),
+		)
+		if err != nil {
+			return nil, s.close(err)
+		}
+		if err = l.init(filter); err != nil {
+			return nil, s.close(err)
 		}
 	}
+	return s, nil
+}
 
-	return l, nil
+func (s *Shards) close(cause error) error {
+	return s.closeErr.Close(func() (errs []error) {
+		errs = append(errs, cause)
+
+		for _, e := range s.shards {
+			errs = append(errs, e.Close())
+		}
+		if s.tcp != nil {
+			errs = append(errs, errors.WithStack(s.tcp.Close()))
+		}
+		return
+	})
 }
 
+func (s *Shards) Listeners() []*Listener { return s.shards }
+func (s *Shards) Addr() netip.AddrPort   { return s.addr }
+func (s *Shards) Close() error           { return s.close(nil) }
+
 func (l *Listener) close(cause error) error {
 	return l.closeErr.Close(func() (errs []error) {
 		errs = append(errs, cause)
 
+		if l.reader != nil {
+			errs = append(errs, l.reader.Close())
+		}
 		if l.raw != nil {
 			errs = append(errs, l.raw.Close())
 		}
@@ -94,30 +167,49 @@ func (l *Listener) close(cause error) error {
 	})
 }
 
-// todo: not support private proto that not start with tcp SYN flag
 func (l *Listener) Accept() (rawsock.RawConn, error) {
-	var min, max = itcp.SizeRange(l.addr.Addr().Is4())
+	return l.AcceptCtx(context.Background())
+}
+
+// todo: not support private proto that not start with tcp SYN flag
+func (l *Listener) AcceptCtx(ctx context.Context) (rawsock.RawConn, error) {
+	var min, _ = itcp.SizeRange(l.addr.Addr().Is4())
 
-	var ip = make([]byte, max)
 	for {
-		n, err := l.raw.Read(ip[:max])
+		// only read headers, SYN carried data (TFO) maybe truncated, see TrimSnap
+		ip, err := l.reader.ReadCtx(ctx)
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
 			return nil, l.close(err)
-		} else if n < min {
+		}
+		n := len(ip)
+		if n < min {
+			return nil, errors.Errorf("recved invalid ip packet, bytes %d", n)
+		}
+
+		hdrLen, err := helper.IPHeaderLen(ip)
+		if err != nil {
+			return nil, err
+		} else if n < hdrLen+header.TCPMinimumSize {
 			return nil, errors.Errorf("recved invalid ip packet, bytes %d", n)
 		}
 
-		var id = itcp.ID{Local: l.addr}
+		// if listen on unspecified address, local address is the packet's destination
+		var id itcp.ID
 		switch header.IPVersion(ip) {
 		case 4:
 			iphdr := header.IPv4(ip[:n])
-			tcphdr := header.TCP(iphdr.Payload())
+			tcphdr := header.TCP(iphdr[hdrLen:])
+			id.Local = netip.AddrPortFrom(netip.AddrFrom4(iphdr.DestinationAddress().As4()), l.addr.Port())
 			id.Remote = netip.AddrPortFrom(netip.AddrFrom4(iphdr.SourceAddress().As4()), tcphdr.SourcePort())
 			id.ISN = tcphdr.SequenceNumber()
 		case 6:
 			iphdr := header.IPv6(ip[:n])
-			tcphdr := header.TCP(iphdr.Payload())
-			id.Remote = netip.AddrPortFrom(netip.AddrFrom4(iphdr.SourceAddress().As4()), tcphdr.SourcePort())
+			tcphdr := header.TCP(iphdr[hdrLen:])
+			id.Local = netip.AddrPortFrom(netip.AddrFrom16(iphdr.DestinationAddress().As16()), l.addr.Port())
+			id.Remote = netip.AddrPortFrom(netip.AddrFrom16(iphdr.SourceAddress().As16()), tcphdr.SourcePort())
 			id.ISN = tcphdr.SequenceNumber()
 		default:
 			continue
@@ -131,8 +223,8 @@ func (l *Listener) Accept() (rawsock.RawConn, error) {
 			l.conns[id] = struct{}{}
 			l.connsMu.Unlock()
 
-			// todo: 应该把这个SYN携带进去
 			c := newConnect(id, l.deleteConn)
+			c.first = append(make([]byte, 0, n), itcp.TrimSnap(ip[:n])...)
 			if err := c.init(l.cfg); err != nil {
 				return nil, errorx.WrapTemp(c.close(err))
 			}
@@ -163,15 +255,25 @@ type Conn struct {
 	itcp.ID
 	tcp *net.TCPListener
 
-	raw *net.IPConn
+	raw     *helper.IPConn
+	offload *bind.OffloadRef
+
+	// IPPROTO_RAW socket, used to write/inject ip packet
+	hdrincl *net.IPConn
+	batch   *helper.Batch
 
 	ipstack *ipstack.IPStack
 
+	// SYN recved by Listener, it's the first read result
+	first   []byte
+	firstMu sync.Mutex
+
 	closeFn  itcp.CloseCallback
 	closeErr errorx.CloseErr
 }
 
 var _ rawsock.RawConn = (*Conn)(nil)
+var _ rawsock.BatchConn = (*Conn)(nil)
 
 func Connect(laddr, raddr netip.AddrPort, opts ...rawsock.Option) (*Conn, error) {
 	cfg := rawsock.Options(opts...)
@@ -206,10 +308,9 @@ func newConnect(id itcp.ID, closeCall itcp.CloseCallback) *Conn {
 }
 
 func (c *Conn) init(cfg *rawsock.Config) (err error) {
-	if c.raw, err = net.DialIP(
-		"ip:tcp",
-		&net.IPAddr{IP: c.Local.Addr().AsSlice(), Zone: c.Local.Addr().Zone()},
-		&net.IPAddr{IP: c.ID.Remote.Addr().AsSlice(), Zone: c.ID.Remote.Addr().Zone()},
+	if c.raw, err = helper.DialIP(
+		header.TCPProtocolNumber,
+		c.Local.Addr(), c.ID.Remote.Addr(),
 	); err != nil {
 		return err
 	}
@@ -219,23 +320,23 @@ func (c *Conn) init(cfg *rawsock.Config) (err error) {
 	//   ethtool -K lo tcp-segmentation-offload off
 	//   ethtool -K lo generic-segmentation-offload off
 	if cfg.SetGRO {
-		if err = bind.SetGRO(c.Local.Addr(), c.Remote.Addr(), false); err != nil {
+		if c.offload, err = bind.SetGRO(c.Local.Addr(), c.Remote.Addr(), false); err != nil {
 			return err
 		}
 	}
 
 	// filter src/dst ports
-	if raw, err := c.raw.SyscallConn(); err != nil {
+	if err = c.raw.SetBPF(
+		bpf.FilterPorts(c.ID.Remote.Port(), c.Local.Port()),
+	); err != nil {
 		return err
-	} else {
-		if err = bpf.SetRawBPF(
-			raw,
-			bpf.FilterPorts(c.ID.Remote.Port(), c.Local.Port()),
-		); err != nil {
-			return err
-		}
 	}
 
+	if c.hdrincl, err = helper.ListenRawIP(c.Local.Addr()); err != nil {
+		return err
+	}
+	c.batch = helper.NewBatch(c.raw)
+
 	if c.ipstack, err = ipstack.New(
 		c.Local.Addr(), c.ID.Remote.Addr(),
 		header.TCPProtocolNumber,
@@ -253,6 +354,10 @@ func (c *Conn) close(cause error) error {
 		if c.raw != nil {
 			errs = append(errs, c.raw.Close())
 		}
+		if c.hdrincl != nil {
+			errs = append(errs, c.hdrincl.Close())
+		}
+		errs = append(errs, c.offload.Close())
 		if c.tcp != nil {
 			errs = append(errs, errors.WithStack(c.tcp.Close()))
 		}
@@ -264,21 +369,66 @@ func (c *Conn) close(cause error) error {
 }
 
 func (c *Conn) Read(pkt *packet.Packet) (err error) {
-	n, err := c.raw.Read(pkt.Bytes())
+	hdrLen, err := c.read(pkt)
 	if err != nil {
-		return errors.WithStack(err)
+		return err
+	}
+	pkt.SetHead(pkt.Head() + hdrLen)
+	return nil
+}
+
+func (c *Conn) ReadRaw(ip *packet.Packet) (err error) {
+	_, err = c.read(ip)
+	return err
+}
+
+func (c *Conn) read(pkt *packet.Packet) (hdrLen int, err error) {
+	n, err := c.readFirst(pkt)
+	if err != nil {
+		return 0, err
+	} else if n == 0 {
+		if n, err = c.raw.Read(pkt.Bytes()); err != nil {
+			return 0, errors.WithStack(err)
+		}
 	}
 	pkt.SetData(n)
 
-	hdrLen, err := helper.IPCheck(pkt.Bytes())
+	hdrLen, err = helper.CheckIP(pkt.Bytes())
 	if err != nil {
-		return err
+		return 0, err
 	}
 	if debug.Debug() {
 		test.ValidIP(test.P(), pkt.Bytes())
 	}
-	pkt.SetHead(pkt.Head() + int(hdrLen))
-	return nil
+	return hdrLen, nil
+}
+
+// readFirst read the SYN recved by Listener, return 0 if it has been read
+func (c *Conn) readFirst(pkt *packet.Packet) (int, error) {
+	c.firstMu.Lock()
+	defer c.firstMu.Unlock()
+	if c.first == nil {
+		return 0, nil
+	} else if pkt.Data() < len(c.first) {
+		return 0, errorx.ShortBuff(len(c.first), pkt.Data())
+	}
+
+	n := copy(pkt.Bytes(), c.first)
+	c.first = nil
+	return n, nil
+}
+
+func (c *Conn) ReadBatch(pkts []*packet.Packet) (n int, err error) {
+	c.firstMu.Lock()
+	has := c.first != nil
+	c.firstMu.Unlock()
+	if has && len(pkts) > 0 {
+		if err = c.Read(pkts[0]); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	}
+	return c.batch.ReadBatch(pkts)
 }
 
 func (c *Conn) Write(pkt *packet.Packet) (err error) {
@@ -286,16 +436,33 @@ func (c *Conn) Write(pkt *packet.Packet) (err error) {
 	return errors.WithStack(err)
 }
 
+func (c *Conn) WriteBatch(pkts []*packet.Packet) (n int, err error) {
+	return c.batch.WriteBatch(pkts)
+}
+
+func (c *Conn) WriteRaw(ip *packet.Packet) (err error) {
+	if debug.Debug() {
+		test.ValidIP(test.P(), ip.Bytes())
+	}
+	return helper.WriteRawIP(c.hdrincl, ip.Bytes())
+}
+
 func (c *Conn) Inject(pkt *packet.Packet) (err error) {
 	defer pkt.DetachN(c.ipstack.Size())
 	c.ipstack.AttachInbound(pkt)
+	return c.InjectRaw(pkt)
+}
+
+func (c *Conn) InjectRaw(ip *packet.Packet) (err error) {
 	if debug.Debug() {
-		test.ValidIP(test.P(), pkt.Bytes())
+		test.ValidIP(test.P(), ip.Bytes())
 	}
-	_, err = c.raw.Write(pkt.Bytes())
-	return errors.WithStack(err)
+	return helper.WriteRawIP(c.hdrincl, ip.Bytes())
 }
 
-func (c *Conn) LocalAddr() netip.AddrPort  { return c.Local }
-func (c *Conn) RemoteAddr() netip.AddrPort { return c.ID.Remote }
-func (c *Conn) Close() error               { return c.close(nil) }
+func (c *Conn) LocalAddr() netip.AddrPort          { return c.Local }
+func (c *Conn) RemoteAddr() netip.AddrPort         { return c.ID.Remote }
+func (c *Conn) SetDeadline(t time.Time) error      { return c.raw.SetDeadline(t) }
+func (c *Conn) SetReadDeadline(t time.Time) error  { return c.raw.SetReadDeadline(t) }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.raw.SetWriteDeadline(t) }
+func (c *Conn) Close() error                       { return c.close(nil) }
@@ -6,7 +6,9 @@ import (
 	"math/rand"
 	"net"
 	"net/netip"
+	"os"
 	"slices"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -18,9 +20,12 @@ import (
 
 	"github.com/lysShub/netkit/debug"
 	"github.com/lysShub/netkit/packet"
+	"github.com/lysShub/rawsock"
 	"github.com/lysShub/rawsock/test"
 	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 )
 
@@ -129,6 +134,199 @@ func Test_AcceptOnce(t *testing.T) {
 	require.Equal(t, uint32(2), cnt.Load())
 }
 
+func Test_Listen_Unspecified(t *testing.T) {
+	l, err := Listen(netip.AddrPortFrom(netip.IPv4Unspecified(), 0), rawsock.SetGRO(false))
+	require.NoError(t, err)
+	defer l.Close()
+
+	for _, addr := range []netip.Addr{netip.AddrFrom4([4]byte{127, 0, 0, 1}), test.LocIP()} {
+		var (
+			saddr = netip.AddrPortFrom(addr, l.Addr().Port())
+			caddr = netip.AddrPortFrom(addr, test.RandPort())
+		)
+		client, err := Connect(caddr, saddr, rawsock.SetGRO(false))
+		require.NoError(t, err)
+		defer client.Close()
+
+		syn := test.BuildTCPSync(t, caddr, saddr)
+		require.NoError(t, client.Write(packet.Make(20).Append(syn...)))
+
+		conn, err := l.Accept()
+		require.NoError(t, err)
+		defer conn.Close()
+		require.Equal(t, saddr, conn.LocalAddr())
+		require.Equal(t, caddr, conn.RemoteAddr())
+
+		// reply from actual local address
+		tcp := test.BuildTCPSync(t, saddr, caddr)
+		require.NoError(t, conn.Write(packet.Make(20).Append(tcp...)))
+
+		var p = packet.Make(0, 1536)
+		require.NoError(t, client.ReadRaw(p))
+		iphdr := header.IPv4(p.Bytes())
+		require.Equal(t, addr.String(), iphdr.SourceAddress().String())
+		require.Equal(t, []byte(tcp), []byte(iphdr.Payload()))
+	}
+}
+
+func Test_Accept_FirstPacket(t *testing.T) {
+	var (
+		saddr = netip.AddrPortFrom(test.LocIP(), test.RandPort())
+		caddr = netip.AddrPortFrom(test.LocIP(), test.RandPort())
+	)
+
+	l, err := Listen(saddr, rawsock.SetGRO(false))
+	require.NoError(t, err)
+	defer l.Close()
+
+	client, err := Connect(caddr, saddr, rawsock.SetGRO(false))
+	require.NoError(t, err)
+	defer client.Close()
+
+	syn := test.BuildTCPSync(t, caddr, saddr)
+	require.NoError(t, client.Write(packet.Make(20).Append(syn...)))
+
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// without wait SYN retransmit
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Millisecond*100)))
+	var p = packet.Make(0, 1536)
+	require.NoError(t, conn.Read(p))
+	require.Equal(t, []byte(syn), p.Bytes())
+
+	err = conn.Read(p.SetHead(0).SetData(1536))
+	require.True(t, errors.Is(err, os.ErrDeadlineExceeded), err)
+}
+
+func Test_Accept_SnapSYN(t *testing.T) {
+	for _, addr := range []netip.Addr{test.LocIP(), netip.IPv6Loopback()} {
+		t.Run(addr.String(), func(t *testing.T) {
+			var (
+				saddr = netip.AddrPortFrom(addr, test.RandPort())
+				caddr = netip.AddrPortFrom(addr, test.RandPort())
+			)
+
+			l, err := Listen(saddr, rawsock.SetGRO(false))
+			require.NoError(t, err)
+			defer l.Close()
+
+			client, err := Connect(caddr, saddr, rawsock.SetGRO(false))
+			require.NoError(t, err)
+			defer client.Close()
+
+			// SYN carry TFO data, longer than bpf snap length
+			syn := test.BuildTCPSync(t, caddr, saddr)
+			pkt := packet.Make(20).Append(syn...).Append(make([]byte, 512)...)
+			tcp := header.TCP(pkt.Bytes())
+			tcp.SetChecksum(0)
+			tcp.SetChecksum(^checksum.Checksum(tcp, header.PseudoHeaderChecksum(
+				header.TCPProtocolNumber, test.Address(caddr.Addr()), test.Address(saddr.Addr()), uint16(len(tcp)),
+			)))
+			require.NoError(t, client.Write(pkt))
+
+			conn, err := l.Accept()
+			require.NoError(t, err)
+			defer conn.Close()
+
+			// first read is the SYN without data
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Millisecond*100)))
+			var p = packet.Make(0, 1536)
+			require.NoError(t, conn.Read(p))
+			require.Equal(t, len(syn), p.Data())
+			tcp = header.TCP(p.Bytes())
+			require.Equal(t, syn.SequenceNumber(), tcp.SequenceNumber())
+			require.Equal(t, header.TCPFlagSyn, tcp.Flags())
+			require.True(t, tcp.IsChecksumValid(test.Address(caddr.Addr()), test.Address(saddr.Addr()), 0, 0))
+		})
+	}
+}
+
+func Test_ListenShards(t *testing.T) {
+	var (
+		saddr = netip.AddrPortFrom(test.LocIP(), test.RandPort())
+		n     = 16
+	)
+
+	s, err := ListenShards(saddr, 4, rawsock.SetGRO(false))
+	require.NoError(t, err)
+	defer s.Close()
+	require.Len(t, s.Listeners(), 4)
+
+	var accepted atomic.Int32
+	var flows sync.Map
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	var eg errgroup.Group
+	for _, l := range s.Listeners() {
+		l := l
+		eg.Go(func() error {
+			for accepted.Load() < int32(n) {
+				conn, err := l.AcceptCtx(ctx)
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
+
+				// every flow only accepted by one shard
+				_, loaded := flows.LoadOrStore(conn.RemoteAddr(), struct{}{})
+				require.False(t, loaded)
+				if accepted.Add(1) == int32(n) {
+					cancel()
+				}
+			}
+			return nil
+		})
+	}
+
+	for i := 0; i < n; i++ {
+		caddr := netip.AddrPortFrom(test.LocIP(), test.RandPort())
+		client, err := Connect(caddr, saddr, rawsock.SetGRO(false))
+		require.NoError(t, err)
+		defer client.Close()
+
+		syn := test.BuildTCPSync(t, caddr, saddr)
+		require.NoError(t, client.Write(packet.Make(20).Append(syn...)))
+	}
+
+	err = eg.Wait()
+	require.True(t, errors.Is(err, context.Canceled), err)
+	require.Equal(t, int32(n), accepted.Load())
+}
+
+func Test_AcceptCtx(t *testing.T) {
+	var addr = netip.AddrPortFrom(test.LocIP(), test.RandPort())
+
+	l, err := Listen(addr, rawsock.SetGRO(false))
+	require.NoError(t, err)
+	defer l.Close()
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+		defer cancel()
+
+		conn, err := l.AcceptCtx(ctx)
+		require.True(t, errors.Is(err, context.DeadlineExceeded))
+		require.Nil(t, conn)
+	})
+
+	t.Run("accept-after-cancel", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		go func() {
+			time.Sleep(time.Millisecond * 500)
+			net.DialTimeout("tcp", addr.String(), time.Second*2)
+		}()
+
+		conn, err := l.AcceptCtx(ctx)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.Equal(t, addr, conn.LocalAddr())
+	})
+}
+
 func Test_Connect(t *testing.T) {
 
 	t.Run("loopback", func(t *testing.T) {
@@ -234,6 +432,249 @@ func Test_Connect(t *testing.T) {
 	})
 }
 
+func Test_Deadline(t *testing.T) {
+	var (
+		caddr = netip.AddrPortFrom(test.LocIP(), test.RandPort())
+		saddr = netip.AddrPortFrom(test.LocIP(), test.RandPort())
+	)
+
+	raw, err := Connect(caddr, saddr, rawsock.SetGRO(false))
+	require.NoError(t, err)
+	defer raw.Close()
+
+	err = raw.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
+	require.NoError(t, err)
+
+	start := time.Now()
+	err = raw.Read(packet.Make(0, 1536))
+	require.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func Test_Raw(t *testing.T) {
+	var (
+		caddr = netip.AddrPortFrom(test.LocIP(), test.RandPort())
+		saddr = netip.AddrPortFrom(test.LocIP(), test.RandPort())
+	)
+
+	client, err := Connect(caddr, saddr, rawsock.SetGRO(false))
+	require.NoError(t, err)
+	defer client.Close()
+	server, err := Connect(saddr, caddr, rawsock.SetGRO(false))
+	require.NoError(t, err)
+	defer server.Close()
+
+	build := func(ttl uint8) header.IPv4 {
+		ip := test.BuildRawTCP(t, caddr, saddr, []byte("hello"))
+		ip.SetTTL(ttl)
+		ip.SetChecksum(0)
+		ip.SetChecksum(^ip.CalculateChecksum())
+		return ip
+	}
+
+	t.Run("WriteRaw", func(t *testing.T) {
+		ip := build(33)
+		err := client.WriteRaw(packet.Make().Append(ip...))
+		require.NoError(t, err)
+
+		var p = packet.Make(0, 1536)
+		err = server.ReadRaw(p)
+		require.NoError(t, err)
+		require.Equal(t, uint8(33), header.IPv4(p.Bytes()).TTL())
+		require.Equal(t, ip.Payload(), header.IPv4(p.Bytes()).Payload())
+	})
+
+	t.Run("InjectRaw", func(t *testing.T) {
+		ip := build(34)
+		err := server.InjectRaw(packet.Make().Append(ip...))
+		require.NoError(t, err)
+
+		var p = packet.Make(0, 1536)
+		err = server.ReadRaw(p)
+		require.NoError(t, err)
+		require.Equal(t, uint8(34), header.IPv4(p.Bytes()).TTL())
+		require.Equal(t, ip.Payload(), header.IPv4(p.Bytes()).Payload())
+	})
+}
+
+func Test_Batch(t *testing.T) {
+	var (
+		caddr = netip.AddrPortFrom(test.LocIP(), test.RandPort())
+		saddr = netip.AddrPortFrom(test.LocIP(), test.RandPort())
+		n     = 3
+	)
+
+	client, err := Connect(caddr, saddr, rawsock.SetGRO(false))
+	require.NoError(t, err)
+	defer client.Close()
+	server, err := Connect(saddr, caddr, rawsock.SetGRO(false))
+	require.NoError(t, err)
+	defer server.Close()
+
+	var tcps, pkts []*packet.Packet
+	for i := 0; i < n; i++ {
+		tcp := test.BuildTCPSync(t, caddr, saddr)
+		tcps = append(tcps, packet.Make().Append(tcp...))
+		pkts = append(pkts, packet.Make(0, 1536))
+	}
+
+	m, err := client.WriteBatch(tcps)
+	require.NoError(t, err)
+	require.Equal(t, n, m)
+
+	var i int
+	for i < n {
+		m, err := server.ReadBatch(pkts[i:])
+		require.NoError(t, err)
+		for _, e := range pkts[i : i+m] {
+			require.Equal(t, tcps[i].Bytes(), e.Bytes())
+			i++
+		}
+	}
+}
+
+func Test_IPv6(t *testing.T) {
+	var loopback = netip.IPv6Loopback()
+
+	t.Run("read-write", func(t *testing.T) {
+		var (
+			caddr = netip.AddrPortFrom(loopback, test.RandPort())
+			saddr = netip.AddrPortFrom(loopback, test.RandPort())
+		)
+
+		client, err := Connect(caddr, saddr, rawsock.SetGRO(false))
+		require.NoError(t, err)
+		defer client.Close()
+		server, err := Connect(saddr, caddr, rawsock.SetGRO(false))
+		require.NoError(t, err)
+		defer server.Close()
+
+		tcp := test.BuildTCPSync(t, caddr, saddr)
+		err = client.Write(packet.Make().Append(tcp...))
+		require.NoError(t, err)
+
+		var p = packet.Make(0, 1536)
+		err = server.ReadRaw(p)
+		require.NoError(t, err)
+		test.ValidIP(t, p.Bytes())
+
+		iphdr := header.IPv6(p.Bytes())
+		require.Equal(t, caddr.Addr().String(), iphdr.SourceAddress().String())
+		require.Equal(t, saddr.Addr().String(), iphdr.DestinationAddress().String())
+		require.Equal(t, []byte(tcp), iphdr.Payload())
+	})
+
+	t.Run("rebuild-header", func(t *testing.T) {
+		var (
+			caddr = netip.AddrPortFrom(loopback, test.RandPort())
+			saddr = netip.AddrPortFrom(loopback, test.RandPort())
+		)
+
+		client, err := Connect(caddr, saddr, rawsock.SetGRO(false))
+		require.NoError(t, err)
+		defer client.Close()
+		server, err := Connect(saddr, caddr, rawsock.SetGRO(false))
+		require.NoError(t, err)
+		defer server.Close()
+
+		tcp := test.BuildTCPSync(t, caddr, saddr)
+		var ip = make([]byte, header.IPv6MinimumSize+len(tcp))
+		header.IPv6(ip).Encode(&header.IPv6Fields{
+			TrafficClass:      0x28,
+			FlowLabel:         0x12345,
+			PayloadLength:     uint16(len(tcp)),
+			TransportProtocol: header.TCPProtocolNumber,
+			HopLimit:          7,
+			SrcAddr:           tcpip.AddrFrom16(caddr.Addr().As16()),
+			DstAddr:           tcpip.AddrFrom16(saddr.Addr().As16()),
+		})
+		copy(ip[header.IPv6MinimumSize:], tcp)
+		err = client.WriteRaw(packet.Make().Append(ip...))
+		require.NoError(t, err)
+
+		var p = packet.Make(0, 1536)
+		err = server.ReadRaw(p)
+		require.NoError(t, err)
+		require.Equal(t, ip, p.Bytes())
+	})
+
+	t.Run("inject", func(t *testing.T) {
+		var (
+			caddr = netip.AddrPortFrom(loopback, test.RandPort())
+			saddr = netip.AddrPortFrom(loopback, test.RandPort())
+		)
+
+		server, err := Connect(saddr, caddr, rawsock.SetGRO(false))
+		require.NoError(t, err)
+		defer server.Close()
+
+		tcp := test.BuildTCPSync(t, caddr, saddr)
+		err = server.Inject(packet.Make(64).Append(tcp...))
+		require.NoError(t, err)
+
+		var p = packet.Make(0, 1536)
+		err = server.Read(p)
+		require.NoError(t, err)
+		require.Equal(t, []byte(tcp), p.Bytes())
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		var (
+			caddr = netip.AddrPortFrom(loopback, test.RandPort())
+			saddr = netip.AddrPortFrom(loopback, test.RandPort())
+		)
+
+		client, err := Connect(caddr, saddr, rawsock.SetGRO(false))
+		require.NoError(t, err)
+		defer client.Close()
+		server, err := Connect(saddr, caddr, rawsock.SetGRO(false))
+		require.NoError(t, err)
+		defer server.Close()
+
+		var tcps, pkts []*packet.Packet
+		for i := 0; i < 3; i++ {
+			tcp := test.BuildTCPSync(t, caddr, saddr)
+			tcps = append(tcps, packet.Make().Append(tcp...))
+			pkts = append(pkts, packet.Make(0, 1536))
+		}
+		n, err := client.WriteBatch(tcps)
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+
+		var i int
+		for i < len(tcps) {
+			n, err := server.ReadBatch(pkts[i:])
+			require.NoError(t, err)
+			for _, e := range pkts[i : i+n] {
+				require.Equal(t, tcps[i].Bytes(), e.Bytes())
+				i++
+			}
+		}
+	})
+
+	t.Run("listen", func(t *testing.T) {
+		var addr = netip.AddrPortFrom(loopback, test.RandPort())
+
+		l, err := Listen(addr, rawsock.SetGRO(false))
+		require.NoError(t, err)
+		defer l.Close()
+
+		go func() {
+			time.Sleep(time.Millisecond * 500)
+			net.DialTimeout("tcp6", addr.String(), time.Second*2)
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		conn, err := l.AcceptCtx(ctx)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		require.Equal(t, addr, conn.LocalAddr())
+		require.Equal(t, loopback, conn.RemoteAddr().Addr())
+	})
+}
+
 func Test_Default_Addr(t *testing.T) {
 	var addr = netip.AddrPortFrom(netip.IPv4Unspecified(), 0)
 	t.Run("listen", func(t *testing.T) {
@@ -242,7 +683,17 @@ func Test_Default_Addr(t *testing.T) {
 		defer l.Close()
 
 		laddr := l.Addr()
-		require.Equal(t, test.LocIP(), laddr.Addr())
+		require.Equal(t, addr.Addr(), laddr.Addr())
+		require.NotZero(t, laddr.Port())
+	})
+
+	t.Run("listen6", func(t *testing.T) {
+		l, err := Listen(netip.AddrPortFrom(netip.IPv6Unspecified(), 0), rawsock.SetGRO(false))
+		require.NoError(t, err)
+		defer l.Close()
+
+		laddr := l.Addr()
+		require.Equal(t, netip.IPv6Unspecified(), laddr.Addr())
 		require.NotZero(t, laddr.Port())
 	})
 
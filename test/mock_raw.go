@@ -1,22 +1,25 @@
 package test
 
 import (
+	"context"
 	"math"
 	"math/rand"
 	"net"
 	"net/netip"
+	"os"
 	"slices"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/lysShub/netkit/errorx"
 	"github.com/lysShub/netkit/packet"
 	"github.com/lysShub/rawsock"
+	"github.com/lysShub/rawsock/helper"
 	"github.com/lysShub/rawsock/helper/ipstack"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 	"gvisor.dev/gvisor/pkg/tcpip"
-	"gvisor.dev/gvisor/pkg/tcpip/header"
 )
 
 type MockRaw struct {
@@ -30,12 +33,15 @@ type MockRaw struct {
 	in     chan pack
 	out    chan<- pack
 	closed chan struct{}
+
+	rDeadline, wDeadline *deadline
 }
 
 var _ rawsock.RawConn = (*MockRaw)(nil)
+var _ rawsock.BatchConn = (*MockRaw)(nil)
 
 type pack struct {
-	ip header.IPv4
+	ip []byte
 	t  time.Time // write time
 }
 
@@ -92,7 +98,7 @@ func NewMockRaw(
 	clientAddr, serverAddr netip.AddrPort,
 	opts ...Option,
 ) (client, server *MockRaw) {
-	require.True(t, clientAddr.Addr().Is4())
+	require.Equal(t, clientAddr.Addr().Is4(), serverAddr.Addr().Is4())
 
 	var a = make(chan pack, 64)
 	var b = make(chan pack, 64)
@@ -108,6 +114,9 @@ func NewMockRaw(
 		out:    a,
 		in:     b,
 		closed: make(chan struct{}),
+
+		rDeadline: newDeadline(),
+		wDeadline: newDeadline(),
 	}
 	for _, opt := range opts {
 		opt(&client.config)
@@ -129,6 +138,9 @@ func NewMockRaw(
 		out:    b,
 		in:     a,
 		closed: make(chan struct{}),
+
+		rDeadline: newDeadline(),
+		wDeadline: newDeadline(),
 	}
 	for _, opt := range opts {
 		opt(&server.config)
@@ -153,6 +165,19 @@ func (r *MockRaw) Close() error {
 }
 
 func (r *MockRaw) Read(pkt *packet.Packet) (err error) {
+	if err = r.ReadRaw(pkt); err != nil {
+		return err
+	}
+
+	hdr, err := helper.CheckIP(pkt.Bytes())
+	if err != nil {
+		return err
+	}
+	pkt.SetHead(pkt.Head() + hdr)
+	return nil
+}
+
+func (r *MockRaw) ReadRaw(ip *packet.Packet) (err error) {
 	var p pack
 	select {
 	case <-r.closed:
@@ -161,6 +186,8 @@ func (r *MockRaw) Read(pkt *packet.Packet) (err error) {
 		default:
 			return errors.WithStack(net.ErrClosed)
 		}
+	case <-r.rDeadline.wait():
+		return errors.WithStack(os.ErrDeadlineExceeded)
 	case p = <-r.in:
 	}
 	if d := time.Since(p.t); d < r.delay {
@@ -168,33 +195,50 @@ func (r *MockRaw) Read(pkt *packet.Packet) (err error) {
 		time.Sleep(max(dur, 0))
 	}
 
-	if pkt.Data() < len(p.ip) {
-		return errorx.ShortBuff(len(p.ip), pkt.Data())
+	if ip.Data() < len(p.ip) {
+		return errorx.ShortBuff(len(p.ip), ip.Data())
 	}
-	pkt.SetData(0).Append(p.ip...)
-
-	switch header.IPVersion(p.ip) {
-	case 4:
-		iphdr := int(header.IPv4(p.ip).HeaderLength())
-		pkt.SetHead(pkt.Head() + iphdr)
-	case 6:
-		pkt.SetHead(pkt.Head() + header.IPv6MinimumSize)
-	default:
-		panic("")
+	ip.SetData(0).Append(p.ip...)
+	return nil
+}
+
+// ReadBatch block until read first packet, then read the buffered packets
+func (r *MockRaw) ReadBatch(pkts []*packet.Packet) (n int, err error) {
+	for i, pkt := range pkts {
+		if i > 0 && len(r.in) == 0 {
+			return i, nil
+		}
+		if err = r.Read(pkt); err != nil {
+			return i, err
+		}
 	}
+	return len(pkts), nil
+}
 
-	return nil
+func (r *MockRaw) WriteBatch(pkts []*packet.Packet) (n int, err error) {
+	for i, pkt := range pkts {
+		if err = r.Write(pkt); err != nil {
+			return i, err
+		}
+	}
+	return len(pkts), nil
 }
 
 func (r *MockRaw) Write(pkt *packet.Packet) (err error) {
+	defer pkt.DetachN(r.ip.Size())
+	r.ip.AttachOutbound(pkt)
+	return r.WriteRaw(pkt)
+}
+
+func (r *MockRaw) WriteRaw(ip *packet.Packet) (err error) {
 	select {
 	case <-r.closed:
 		return errors.WithStack(net.ErrClosed)
+	case <-r.wDeadline.wait():
+		return errors.WithStack(os.ErrDeadlineExceeded)
 	default:
 	}
 
-	defer pkt.DetachN(r.ip.Size())
-	r.ip.AttachOutbound(pkt)
 	if r.loss() {
 		return nil
 	}
@@ -202,7 +246,7 @@ func (r *MockRaw) Write(pkt *packet.Packet) (err error) {
 	select {
 	case <-r.closed:
 		return errors.WithStack(net.ErrClosed)
-	case r.out <- pack{ip: slices.Clone(pkt.Bytes()), t: time.Now()}:
+	case r.out <- pack{ip: slices.Clone(ip.Bytes()), t: time.Now()}:
 	default:
 	}
 	return nil
@@ -211,14 +255,17 @@ func (r *MockRaw) Write(pkt *packet.Packet) (err error) {
 func (r *MockRaw) Inject(pkt *packet.Packet) (err error) {
 	defer pkt.DetachN(r.ip.Size())
 	r.ip.AttachInbound(pkt)
+	return r.InjectRaw(pkt)
+}
 
+func (r *MockRaw) InjectRaw(ip *packet.Packet) (err error) {
 	defer func() {
 		if recover() != nil {
 			err = net.ErrClosed
 		}
 	}()
 	select {
-	case r.in <- pack{ip: slices.Clone(pkt.Bytes()), t: time.Unix(0, 0)}:
+	case r.in <- pack{ip: slices.Clone(ip.Bytes()), t: time.Unix(0, 0)}:
 		return nil
 	default:
 		return nil
@@ -226,11 +273,73 @@ func (r *MockRaw) Inject(pkt *packet.Packet) (err error) {
 }
 func (r *MockRaw) LocalAddr() netip.AddrPort  { return r.local }
 func (r *MockRaw) RemoteAddr() netip.AddrPort { return r.remote }
+func (r *MockRaw) SetDeadline(t time.Time) error {
+	r.rDeadline.set(t)
+	r.wDeadline.set(t)
+	return nil
+}
+func (r *MockRaw) SetReadDeadline(t time.Time) error  { r.rDeadline.set(t); return nil }
+func (r *MockRaw) SetWriteDeadline(t time.Time) error { r.wDeadline.set(t); return nil }
 
 func (r *MockRaw) loss() bool {
 	return rand.Uint32() <= uint32(float32(math.MaxUint32)*r.pl)
 }
 
+// deadline is a resettable deadline, the wait chan will be closed after deadline
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func newDeadline() *deadline {
+	return &deadline{cancel: make(chan struct{})}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // wait for the timer callback to finish and close cancel
+	}
+	d.timer = nil
+
+	closed := false
+	select {
+	case <-d.cancel:
+		closed = true
+	default:
+	}
+
+	// zero time means no deadline
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() { close(cancel) })
+		return
+	}
+
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
 type MockListener struct {
 	addr netip.AddrPort
 	raws chan rawsock.RawConn
@@ -257,11 +366,19 @@ func NewMockListener(t require.TestingT, raws ...rawsock.RawConn) *MockListener
 }
 
 func (l *MockListener) Accept() (rawsock.RawConn, error) {
-	raw, ok := <-l.raws
-	if !ok || l.closed.Load() {
-		return nil, net.ErrClosed
+	return l.AcceptCtx(context.Background())
+}
+
+func (l *MockListener) AcceptCtx(ctx context.Context) (rawsock.RawConn, error) {
+	select {
+	case raw, ok := <-l.raws:
+		if !ok || l.closed.Load() {
+			return nil, net.ErrClosed
+		}
+		return raw, nil
+	case <-ctx.Done():
+		return nil, errors.WithStack(ctx.Err())
 	}
-	return raw, nil
 }
 
 func (l *MockListener) Addr() netip.AddrPort { return l.addr }
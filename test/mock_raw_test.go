@@ -1,11 +1,15 @@
 package test
 
 import (
+	"context"
 	"net/netip"
+	"os"
 	"testing"
 	"time"
 
 	"github.com/lysShub/netkit/packet"
+	"github.com/lysShub/rawsock"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 )
@@ -70,6 +74,81 @@ func Test_Mock_RawConn(t *testing.T) {
 		require.Equal(t, []byte(tcp), b.Bytes())
 	})
 
+	t.Run("read-write-ipv6", func(t *testing.T) {
+		var (
+			caddr = netip.AddrPortFrom(netip.IPv6Loopback(), RandPort())
+			saddr = netip.AddrPortFrom(netip.IPv6Loopback(), RandPort())
+		)
+		c, s := NewMockRaw(t, header.TCPProtocolNumber, caddr, saddr)
+		defer c.Close()
+		defer s.Close()
+		tcp := BuildTCPSync(t, caddr, saddr)
+
+		err := c.Write(packet.Make(40, 0, len(tcp)).Append(tcp...))
+		require.NoError(t, err)
+
+		var b = packet.Make(0, 128)
+		err = s.Read(b)
+		require.NoError(t, err)
+		require.Equal(t, []byte(tcp), b.Bytes())
+
+		ValidIP(t, b.SetHead(0).Bytes())
+	})
+
+	t.Run("read-write-raw", func(t *testing.T) {
+		var (
+			caddr = netip.AddrPortFrom(LocIP(), RandPort())
+			saddr = netip.AddrPortFrom(LocIP(), RandPort())
+		)
+		c, s := NewMockRaw(t, header.TCPProtocolNumber, caddr, saddr)
+		defer c.Close()
+		defer s.Close()
+
+		ip := BuildRawTCP(t, caddr, saddr, []byte("hello"))
+		ip.SetTTL(33)
+
+		err := c.WriteRaw(packet.Make().Append(ip...))
+		require.NoError(t, err)
+
+		var b = packet.Make(0, 128)
+		err = s.ReadRaw(b)
+		require.NoError(t, err)
+		require.Equal(t, []byte(ip), b.Bytes())
+
+		err = s.InjectRaw(packet.Make().Append(ip...))
+		require.NoError(t, err)
+		err = s.ReadRaw(b.SetHead(0).SetData(128))
+		require.NoError(t, err)
+		require.Equal(t, []byte(ip), b.Bytes())
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		c, s := NewMockRaw(
+			t, header.TCPProtocolNumber,
+			netip.AddrPortFrom(LocIP(), RandPort()), netip.AddrPortFrom(LocIP(), RandPort()),
+		)
+		defer c.Close()
+		defer s.Close()
+
+		var tcps, pkts []*packet.Packet
+		for i := 0; i < 3; i++ {
+			tcp := BuildTCPSync(t, c.LocalAddr(), s.LocalAddr())
+			tcps = append(tcps, packet.Make(20).Append(tcp...))
+			pkts = append(pkts, packet.Make(0, 128))
+		}
+		n, err := rawsock.WriteBatch(c, tcps)
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+
+		// only read buffered packets
+		n, err = rawsock.ReadBatch(s, append(pkts, packet.Make(0, 128)))
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+		for i := range pkts {
+			require.Equal(t, tcps[i].Bytes(), pkts[i].Bytes())
+		}
+	})
+
 	t.Run("Write/memcpy1", func(t *testing.T) {
 		c, s := NewMockRaw(
 			t, header.TCPProtocolNumber,
@@ -141,7 +220,55 @@ func Test_Mock_RawConn(t *testing.T) {
 		require.Greater(t, time.Second*3, time.Since(start))
 	})
 
+	t.Run("MockRaw/deadline", func(t *testing.T) {
+		c, s := NewMockRaw(
+			t, header.TCPProtocolNumber,
+			netip.AddrPortFrom(LocIP(), RandPort()), netip.AddrPortFrom(LocIP(), RandPort()),
+		)
+		defer c.Close()
+		defer s.Close()
+
+		err := s.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
+		require.NoError(t, err)
+		start := time.Now()
+		err = s.Read(packet.Make(0, 64))
+		require.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+		require.Less(t, time.Since(start), time.Second)
+
+		// reset deadline
+		require.NoError(t, s.SetReadDeadline(time.Time{}))
+		require.NoError(t, c.Write(packet.Make(20, 20)))
+		require.NoError(t, s.Read(packet.Make(0, 64)))
+
+		require.NoError(t, c.SetWriteDeadline(time.Now().Add(-time.Second)))
+		err = c.Write(packet.Make(20, 20))
+		require.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+	})
+
 	t.Run("stack", func(t *testing.T) {
 		t.Skip("todo")
 	})
 }
+
+func Test_Mock_Listener(t *testing.T) {
+	t.Run("AcceptCtx", func(t *testing.T) {
+		c, s := NewMockRaw(
+			t, header.TCPProtocolNumber,
+			netip.AddrPortFrom(LocIP(), RandPort()), netip.AddrPortFrom(LocIP(), RandPort()),
+		)
+		defer c.Close()
+		defer s.Close()
+		l := NewMockListener(t, s)
+		defer l.Close()
+
+		conn, err := l.AcceptCtx(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, s, conn)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+		conn, err = l.AcceptCtx(ctx)
+		require.True(t, errors.Is(err, context.DeadlineExceeded))
+		require.Nil(t, conn)
+	})
+}
@@ -71,6 +71,16 @@ func (w *PcapWrap) Read(pkt *packet.Packet) (err error) {
 	return err
 }
 
+func (w *PcapWrap) ReadRaw(ip *packet.Packet) (err error) {
+	if err = w.RawConn.ReadRaw(ip); err != nil {
+		return err
+	}
+	if debug.Debug() {
+		ValidIP(T(), ip.Bytes())
+	}
+	return w.pcap.WriteIP(ip.Bytes())
+}
+
 func (w *PcapWrap) Write(pkt *packet.Packet) (err error) {
 	clone := pkt.Clone()
 
@@ -85,6 +95,13 @@ func (w *PcapWrap) Write(pkt *packet.Packet) (err error) {
 	}
 	return w.pcap.WriteIP(clone.Bytes())
 }
+func (w *PcapWrap) WriteRaw(ip *packet.Packet) (err error) {
+	if err = w.RawConn.WriteRaw(ip); err != nil {
+		return err
+	}
+	return w.pcap.WriteIP(ip.Bytes())
+}
+
 func (w *PcapWrap) Inject(pkt *packet.Packet) (err error) {
 	clone := pkt.Clone()
 
@@ -99,3 +116,10 @@ func (w *PcapWrap) Inject(pkt *packet.Packet) (err error) {
 	}
 	return w.pcap.WriteIP(clone.Bytes())
 }
+
+func (w *PcapWrap) InjectRaw(ip *packet.Packet) (err error) {
+	if err = w.RawConn.InjectRaw(ip); err != nil {
+		return err
+	}
+	return w.pcap.WriteIP(ip.Bytes())
+}
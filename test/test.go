@@ -16,6 +16,7 @@ import (
 
 	"github.com/lysShub/netkit/packet"
 	"github.com/lysShub/rawsock"
+	"github.com/lysShub/rawsock/helper"
 	"github.com/lysShub/rawsock/helper/ipstack"
 	"github.com/stretchr/testify/require"
 	"gvisor.dev/gvisor/pkg/buffer"
@@ -24,28 +25,36 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
 )
 
-func calcChecksum() func(ip header.IPv4) header.IPv4 {
+func calcChecksum() func(ip []byte) []byte {
 	var (
 		first     = true
 		calcIP    = true
 		calcTrans = true
 	)
-	return func(ip header.IPv4) header.IPv4 {
+	return func(ip []byte) []byte {
 		if first {
-			calcIP = !ip.IsChecksumValid()
+			var iphdr header.Network
+			if header.IPVersion(ip) == 4 {
+				calcIP = !header.IPv4(ip).IsChecksumValid()
+				iphdr = header.IPv4(ip)
+			} else {
+				calcIP = false
+				iphdr = header.IPv6(ip)
+			}
 
 			psum := header.PseudoHeaderChecksum(
-				ip.TransportProtocol(),
-				ip.SourceAddress(),
-				ip.DestinationAddress(),
-				ip.PayloadLength(),
+				iphdr.TransportProtocol(),
+				iphdr.SourceAddress(),
+				iphdr.DestinationAddress(),
+				uint16(len(iphdr.Payload())),
 			)
-			calcTrans = checksum.Checksum(ip.Payload(), psum) != 0xffff
+			calcTrans = checksum.Checksum(iphdr.Payload(), psum) != 0xffff
 			first = false
 		}
 
@@ -56,23 +65,30 @@ func calcChecksum() func(ip header.IPv4) header.IPv4 {
 	}
 }
 
-func CalcChecksum(ip header.IPv4) {
-	ip.SetChecksum(0)
-	ip.SetChecksum(^ip.CalculateChecksum())
+func CalcChecksum(ip []byte) {
+	var iphdr header.Network
+	if header.IPVersion(ip) == 4 {
+		ip := header.IPv4(ip)
+		ip.SetChecksum(0)
+		ip.SetChecksum(^ip.CalculateChecksum())
+		iphdr = ip
+	} else {
+		iphdr = header.IPv6(ip)
+	}
 
 	psum := header.PseudoHeaderChecksum(
-		ip.TransportProtocol(),
-		ip.SourceAddress(),
-		ip.DestinationAddress(),
-		ip.PayloadLength(),
+		iphdr.TransportProtocol(),
+		iphdr.SourceAddress(),
+		iphdr.DestinationAddress(),
+		uint16(len(iphdr.Payload())),
 	)
-	switch ip.TransportProtocol() {
+	switch iphdr.TransportProtocol() {
 	case header.TCPProtocolNumber:
-		tcp := header.TCP(ip.Payload())
+		tcp := header.TCP(iphdr.Payload())
 		tcp.SetChecksum(0)
 		tcp.SetChecksum(^checksum.Checksum(tcp, psum))
 	case header.UDPProtocolNumber:
-		udp := header.UDP(ip.Payload())
+		udp := header.UDP(iphdr.Payload())
 		udp.SetChecksum(0)
 		udp.SetChecksum(^checksum.Checksum(udp, psum))
 	default:
@@ -116,39 +132,38 @@ func BuildTCPSync(t require.TestingT, laddr, raddr netip.AddrPort) header.TCP {
 
 func ValidIP(t require.TestingT, ip []byte) {
 	var iphdr header.Network
-	var totalLen int
 	switch header.IPVersion(ip) {
 	case 4:
 		ip := header.IPv4(ip)
 		require.True(t, ip.IsChecksumValid())
 		iphdr = ip
-		totalLen = int(ip.TotalLength())
 	case 6:
 		iphdr = header.IPv6(ip)
-		totalLen = int(header.IPv6(ip).PayloadLength()) + header.IPv6MinimumSize
 	default:
 		panic(hex.Dump(ip))
 	}
-	require.Equal(t, totalLen, len(ip))
+	hdr, err := helper.ParseIP(ip)
+	require.NoError(t, err)
+	payload := ip[hdr.Len:]
 
 	pseudoSum1 := header.PseudoHeaderChecksum(
-		iphdr.TransportProtocol(),
+		hdr.Proto,
 		iphdr.SourceAddress(),
 		iphdr.DestinationAddress(),
 		0,
 	)
 
-	switch iphdr.TransportProtocol() {
+	switch hdr.Proto {
 	case header.TCPProtocolNumber:
-		ValidTCP(t, iphdr.Payload(), pseudoSum1)
+		ValidTCP(t, payload, pseudoSum1)
 	case header.UDPProtocolNumber:
-		ValidUDP(t, iphdr.Payload(), pseudoSum1)
+		ValidUDP(t, payload, pseudoSum1)
 	case header.ICMPv4ProtocolNumber:
-		icmp := header.ICMPv4(iphdr.Payload())
+		icmp := header.ICMPv4(payload)
 		sum := checksum.Checksum(icmp, 0)
 		require.Equal(t, uint16(0xffff), sum)
 	default:
-		panic(iphdr.TransportProtocol())
+		panic(hdr.Proto)
 	}
 }
 
@@ -187,8 +202,9 @@ func BuildRawTCP(t require.TestingT, laddr, raddr netip.AddrPort, payload []byte
 
 	s, err := ipstack.New(laddr.Addr(), raddr.Addr(), header.TCPProtocolNumber)
 	require.NoError(t, err)
-	p := packet.Make().Append(b...).SetHead(s.Size())
+	p := packet.Make(s.Size()).Append(b[header.IPv4MinimumSize:]...)
 	s.AttachOutbound(p)
+	b, tcphdr = p.Bytes(), header.TCP(p.Bytes()[header.IPv4MinimumSize:])
 
 	// psoSum := s.AttachHeader(b, header.TCPProtocolNumber)
 
@@ -336,11 +352,9 @@ type ustack struct {
 }
 
 func NewUstack(t require.TestingT, addr netip.Addr, handleLocal bool) *ustack {
-	require.True(t, addr.Is4())
-
 	laddr := tcpip.AddrFromSlice(addr.AsSlice())
 	st := stack.New(stack.Options{
-		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
 		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol},
 		HandleLocal:        handleLocal,
 	})
@@ -349,11 +363,16 @@ func NewUstack(t require.TestingT, addr netip.Addr, handleLocal bool) *ustack {
 	const nicid tcpip.NICID = 1234
 	err := st.CreateNIC(nicid, l)
 	require.Nil(t, err)
+
+	var proto, subnet = header.IPv4ProtocolNumber, header.IPv4EmptySubnet
+	if !addr.Is4() {
+		proto, subnet = header.IPv6ProtocolNumber, header.IPv6EmptySubnet
+	}
 	st.AddProtocolAddress(nicid, tcpip.ProtocolAddress{
-		Protocol:          header.IPv4ProtocolNumber,
+		Protocol:          proto,
 		AddressWithPrefix: laddr.WithPrefix(),
 	}, stack.AddressProperties{})
-	st.SetRouteTable([]tcpip.Route{{Destination: header.IPv4EmptySubnet, NIC: nicid}})
+	st.SetRouteTable([]tcpip.Route{{Destination: subnet, NIC: nicid}})
 
 	var u = &ustack{
 		addr:  laddr,
@@ -479,7 +498,11 @@ func BindRawToUstack(t require.TestingT, ctx context.Context, us *ustack, raw ra
 
 func (u *ustack) Inject(ip []byte) {
 	pkb := stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: buffer.MakeWithData(ip)})
-	u.link.InjectInbound(header.IPv4ProtocolNumber, pkb)
+	if header.IPVersion(ip) == 4 {
+		u.link.InjectInbound(header.IPv4ProtocolNumber, pkb)
+	} else {
+		u.link.InjectInbound(header.IPv6ProtocolNumber, pkb)
+	}
 }
 
 func (u *ustack) Read(ctx context.Context) (ip []byte) {
@@ -2,7 +2,11 @@ package test
 
 import (
 	"fmt"
+	"net"
 	"net/netip"
+	"os/exec"
+	"strings"
+	"testing"
 
 	"github.com/lysShub/netkit/tun"
 
@@ -56,3 +60,65 @@ func CreateTunTuple(t require.TestingT) *NicTuple {
 	}
 	return tt
 }
+
+// Veth veth pair, the peer nic is in a new network namespace
+type Veth struct {
+	NS       string
+	Name     string // local nic name
+	PeerName string
+
+	Ifi    *net.Interface // local nic
+	PeerHW net.HardwareAddr
+}
+
+// CreateVeth create veth pair name0 and name1, name1 is moved to new network
+// namespace name, local/peer are addresses of the nics. the pair is deleted
+// by t.Cleanup, skip the test if can't create.
+func CreateVeth(t *testing.T, name string, local, peer []netip.Prefix) *Veth {
+	var v = &Veth{NS: name, Name: name + "0", PeerName: name + "1"}
+	t.Cleanup(func() {
+		exec.Command("ip", "link", "del", v.Name).Run()
+		exec.Command("ip", "netns", "del", v.NS).Run()
+	})
+
+	var cmds = [][]string{
+		{"netns", "add", v.NS},
+		{"link", "add", v.Name, "type", "veth", "peer", "name", v.PeerName, "netns", v.NS},
+	}
+	for _, e := range peer {
+		cmds = append(cmds, addrCmd([]string{"-n", v.NS, "addr", "add", e.String(), "dev", v.PeerName}, e))
+	}
+	cmds = append(cmds, []string{"-n", v.NS, "link", "set", v.PeerName, "up"})
+	for _, e := range local {
+		cmds = append(cmds, addrCmd([]string{"addr", "add", e.String(), "dev", v.Name}, e))
+	}
+	cmds = append(cmds, []string{"link", "set", v.Name, "up"})
+	for _, e := range cmds {
+		if out, err := exec.Command("ip", e...).CombinedOutput(); err != nil {
+			t.Skipf("ip %v: %s", e, out)
+		}
+	}
+
+	var err error
+	v.Ifi, err = net.InterfaceByName(v.Name)
+	require.NoError(t, err)
+
+	out, err := exec.Command("ip", "-n", v.NS, "-br", "link", "show", v.PeerName).Output()
+	require.NoError(t, err)
+	for _, e := range strings.Fields(string(out)) {
+		if hw, err := net.ParseMAC(e); err == nil {
+			v.PeerHW = hw
+			return v
+		}
+	}
+	t.Fatalf("can't get %s hardware address: %s", v.PeerName, out)
+	return nil
+}
+
+// addrCmd disable ipv6 duplicate address detection, address is usable immediately
+func addrCmd(cmd []string, addr netip.Prefix) []string {
+	if addr.Addr().Is6() {
+		return append(cmd, "nodad")
+	}
+	return cmd
+}
@@ -0,0 +1,250 @@
+//go:build linux
+// +build linux
+
+package eth
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+
+	"github.com/lysShub/netkit/errorx"
+	"github.com/lysShub/rawsock"
+	"github.com/lysShub/rawsock/helper"
+	"github.com/lysShub/rawsock/helper/bind"
+	"github.com/lysShub/rawsock/helper/bpf"
+	"github.com/lysShub/rawsock/helper/ethconn"
+	iudp "github.com/lysShub/rawsock/udp/internal"
+	"github.com/pkg/errors"
+)
+
+type Listener struct {
+	addr netip.AddrPort
+	cfg  *rawsock.Config
+
+	udp int // unix fd
+
+	raw *helper.IPConn
+	// serialize AcceptCtx reads
+	reader *helper.CtxReader
+
+	conns   map[iudp.ID]struct{}
+	connsMu sync.RWMutex
+
+	closeErr errorx.CloseErr
+}
+
+var _ rawsock.Listener = (*Listener)(nil)
+
+func Listen(laddr netip.AddrPort, opts ...rawsock.Option) (*Listener, error) {
+	var l = &Listener{
+		cfg:   rawsock.Options(opts...),
+		conns: make(map[iudp.ID]struct{}, 16),
+	}
+	var err error
+
+	l.udp, l.addr, err = bind.BindLocal(header.UDPProtocolNumber, laddr, l.cfg.UsedPort)
+	if err != nil {
+		return nil, l.close(err)
+	}
+
+	l.raw, err = helper.ListenIP(header.UDPProtocolNumber, l.addr.Addr())
+	if err != nil {
+		return nil, l.close(err)
+	}
+
+	if err = l.raw.SetBPF(
+		// the first datagram of new flow will be delivered to Conn, can't be
+		// truncated by bpf, only copy headers by SnapRead
+		bpf.FilterDstPort(l.addr.Port(), 0xffff),
+	); err != nil {
+		return nil, l.close(err)
+	}
+	l.reader = helper.NewCtxReader(l.raw.SnapRead(bpf.SnapUDP, l.isNew), 0xffff)
+
+	return l, nil
+}
+
+func (l *Listener) close(cause error) error {
+	return l.closeErr.Close(func() (errs []error) {
+		errs = append(errs, cause)
+
+		if l.udp != 0 {
+			errs = append(errs, errors.WithStack(unix.Close(l.udp)))
+		}
+		if l.reader != nil {
+			errs = append(errs, l.reader.Close())
+		}
+		if l.raw != nil {
+			errs = append(errs, errors.WithStack(l.raw.Close()))
+		}
+		return errs
+	})
+}
+
+func (l *Listener) Accept() (rawsock.RawConn, error) {
+	return l.AcceptCtx(context.Background())
+}
+
+func (l *Listener) AcceptCtx(ctx context.Context) (rawsock.RawConn, error) {
+	for {
+		// only read headers, except the first datagram of new flow, see isNew
+		ip, err := l.reader.ReadCtx(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			return nil, l.close(err)
+		}
+		id, err := l.flow(ip)
+		if err != nil {
+			return nil, err
+		}
+
+		l.connsMu.RLock()
+		_, has := l.conns[id]
+		l.connsMu.RUnlock()
+		if !has {
+			l.connsMu.Lock()
+			l.conns[id] = struct{}{}
+			l.connsMu.Unlock()
+
+			c := newConnect(id.Local, id.Remote, l.deleteConn)
+			if err := c.init(ip, l.cfg); err != nil {
+				return nil, errorx.WrapTemp(c.close(err))
+			}
+			return c, nil
+		}
+	}
+}
+
+// flow parse flow id of packet recved by Listener, the packet maybe truncated.
+func (l *Listener) flow(ip []byte) (id iudp.ID, err error) {
+	min, _ := iudp.SizeRange(l.addr.Addr().Is4())
+	if len(ip) < min {
+		return id, errors.Errorf("recved invalid ip packet, bytes %d", len(ip))
+	}
+	hdrLen, err := helper.IPHeaderLen(ip)
+	if err != nil {
+		return id, err
+	} else if len(ip) < hdrLen+header.UDPMinimumSize {
+		return id, errors.Errorf("recved invalid ip packet, bytes %d", len(ip))
+	}
+
+	// if listen on unspecified address, local address is the packet's destination
+	switch header.IPVersion(ip) {
+	case 4:
+		iphdr := header.IPv4(ip)
+		udphdr := header.UDP(iphdr[hdrLen:])
+		id.Local = netip.AddrPortFrom(netip.AddrFrom4(iphdr.DestinationAddress().As4()), l.addr.Port())
+		id.Remote = netip.AddrPortFrom(netip.AddrFrom4(iphdr.SourceAddress().As4()), udphdr.SourcePort())
+	case 6:
+		iphdr := header.IPv6(ip)
+		udphdr := header.UDP(iphdr[hdrLen:])
+		id.Local = netip.AddrPortFrom(netip.AddrFrom16(iphdr.DestinationAddress().As16()), l.addr.Port())
+		id.Remote = netip.AddrPortFrom(netip.AddrFrom16(iphdr.SourceAddress().As16()), udphdr.SourcePort())
+	default:
+		return id, errors.Errorf("recved invalid ip packet, version %d", header.IPVersion(ip))
+	}
+	return id, nil
+}
+
+// isNew report whether the packet is the first datagram of new flow, it will
+// be read entirely and delivered to Conn.
+func (l *Listener) isNew(ip []byte) bool {
+	id, err := l.flow(ip)
+	if err != nil {
+		return false
+	}
+	l.connsMu.RLock()
+	defer l.connsMu.RUnlock()
+	_, has := l.conns[id]
+	return !has
+}
+
+func (l *Listener) deleteConn(id iudp.ID) error {
+	if l == nil {
+		return nil
+	}
+	l.connsMu.Lock()
+	delete(l.conns, id)
+	l.connsMu.Unlock()
+	return nil
+}
+func (l *Listener) Addr() netip.AddrPort { return l.addr }
+func (l *Listener) Close() error         { return l.close(nil) }
+
+func Connect(laddr, raddr netip.AddrPort, opts ...rawsock.Option) (*Conn, error) {
+	cfg := rawsock.Options(opts...)
+
+	if l, err := helper.DefaultLocal(laddr.Addr(), raddr.Addr()); err != nil {
+		return nil, errors.WithStack(err)
+	} else {
+		laddr = netip.AddrPortFrom(l, laddr.Port())
+	}
+
+	fd, laddr, err := bind.BindLocal(header.UDPProtocolNumber, laddr, cfg.UsedPort)
+	if err != nil {
+		return nil, err
+	}
+
+	var c = newConnect(laddr, raddr, nil)
+	c.udp = fd
+
+	if err := c.init(nil, cfg); err != nil {
+		return nil, c.close(err)
+	}
+	return c, nil
+}
+
+type Conn struct {
+	laddr, raddr  netip.AddrPort
+	closeCallback iudp.CloseCallback
+
+	udp int
+
+	*ethconn.Endpoint
+
+	closeErr errorx.CloseErr
+}
+
+var _ rawsock.RawConn = (*Conn)(nil)
+var _ rawsock.BatchConn = (*Conn)(nil)
+
+func newConnect(laddr, raddr netip.AddrPort, close iudp.CloseCallback) *Conn {
+	return &Conn{laddr: laddr, raddr: raddr, closeCallback: close}
+}
+
+// init create Endpoint, first is the datagram recved by Listener, it's the
+// first read result, maybe nil.
+func (c *Conn) init(first []byte, cfg *rawsock.Config) (err error) {
+	c.Endpoint, err = ethconn.NewEndpoint(
+		header.UDPProtocolNumber, c.laddr, c.raddr, first, cfg,
+	)
+	return err
+}
+
+func (c *Conn) close(cause error) error {
+	return c.closeErr.Close(func() (errs []error) {
+		errs = append(errs, cause)
+
+		if c.closeCallback != nil {
+			errs = append(errs, c.closeCallback(iudp.ID{Local: c.laddr, Remote: c.raddr}))
+		}
+		if c.udp != 0 {
+			errs = append(errs, errors.WithStack(syscall.Close(c.udp)))
+		}
+		if c.Endpoint != nil {
+			errs = append(errs, c.Endpoint.Close())
+		}
+		return
+	})
+}
+
+func (c *Conn) LocalAddr() netip.AddrPort  { return c.laddr }
+func (c *Conn) RemoteAddr() netip.AddrPort { return c.raddr }
+func (c *Conn) Close() error               { return c.close(nil) }
@@ -0,0 +1,254 @@
+//go:build linux
+// +build linux
+
+package eth
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lysShub/netkit/packet"
+	"github.com/lysShub/rawsock"
+	"github.com/lysShub/rawsock/helper"
+	"github.com/lysShub/rawsock/helper/bind"
+	"github.com/lysShub/rawsock/test"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func buildUDP(src, dst netip.AddrPort, payload string) *packet.Packet {
+	var p = packet.Make(64, header.UDPMinimumSize).Append([]byte(payload)...)
+	header.UDP(p.Bytes()).Encode(&header.UDPFields{
+		SrcPort: src.Port(), DstPort: dst.Port(), Length: uint16(p.Data()),
+	})
+	return p
+}
+
+func Test_Connect(t *testing.T) {
+	for _, addr := range []netip.Addr{netip.AddrFrom4([4]byte{127, 0, 0, 1}), test.LocIP(), netip.IPv6Loopback()} {
+		t.Run(addr.String(), func(t *testing.T) {
+			var (
+				caddr = netip.AddrPortFrom(addr, test.RandPort())
+				saddr = netip.AddrPortFrom(addr, test.RandPort())
+			)
+
+			udp, err := net.ListenUDP("udp", test.UDPAddr(saddr))
+			require.NoError(t, err)
+			defer udp.Close()
+
+			raw, err := Connect(caddr, saddr, rawsock.SetGRO(false))
+			require.NoError(t, err)
+			defer raw.Close()
+
+			require.NoError(t, raw.Write(buildUDP(caddr, saddr, "hello")))
+
+			var b = make([]byte, 64)
+			require.NoError(t, udp.SetReadDeadline(time.Now().Add(time.Second*3)))
+			n, from, err := udp.ReadFromUDPAddrPort(b)
+			require.NoError(t, err)
+			require.Equal(t, "hello", string(b[:n]))
+			require.Equal(t, caddr, netip.AddrPortFrom(from.Addr().Unmap(), from.Port()))
+
+			_, err = udp.WriteToUDPAddrPort([]byte("world"), from)
+			require.NoError(t, err)
+
+			require.NoError(t, raw.SetReadDeadline(time.Now().Add(time.Second*3)))
+			var p = packet.Make(0, 1536)
+			require.NoError(t, raw.Read(p))
+			require.Equal(t, saddr.Port(), header.UDP(p.Bytes()).SourcePort())
+			require.Equal(t, "world", string(header.UDP(p.Bytes()).Payload()))
+		})
+	}
+}
+
+func Test_Listen(t *testing.T) {
+	var (
+		saddr = netip.AddrPortFrom(test.LocIP(), test.RandPort())
+		caddr = netip.AddrPortFrom(test.LocIP(), test.RandPort())
+	)
+
+	l, err := Listen(saddr, rawsock.SetGRO(false))
+	require.NoError(t, err)
+	defer l.Close()
+
+	conn, err := net.DialUDP("udp", test.UDPAddr(caddr), test.UDPAddr(saddr))
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	raw, err := l.Accept()
+	require.NoError(t, err)
+	defer raw.Close()
+	require.Equal(t, saddr, raw.LocalAddr())
+	require.Equal(t, caddr, raw.RemoteAddr())
+
+	// first read is the datagram recved by Listener
+	var p = packet.Make(0, 1536)
+	require.NoError(t, raw.Read(p))
+	require.Equal(t, "hello", string(header.UDP(p.Bytes()).Payload()))
+
+	require.NoError(t, raw.Write(buildUDP(saddr, caddr, "world")))
+	var b = make([]byte, 64)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second*3)))
+	n, err := conn.Read(b)
+	require.NoError(t, err)
+	require.Equal(t, "world", string(b[:n]))
+}
+
+func Test_PacketMMAP(t *testing.T) {
+	var (
+		caddr = netip.AddrPortFrom(test.LocIP(), test.RandPort())
+		saddr = netip.AddrPortFrom(test.LocIP(), test.RandPort())
+		msgs  = []string{"a", "b", "c"}
+	)
+
+	udp, err := net.ListenUDP("udp", test.UDPAddr(saddr))
+	require.NoError(t, err)
+	defer udp.Close()
+
+	raw, err := Connect(caddr, saddr, rawsock.SetGRO(false), rawsock.PacketMMAP(0, 0, 0))
+	require.NoError(t, err)
+	defer raw.Close()
+	require.Nil(t, raw.Raw())
+
+	var pkts []*packet.Packet
+	for _, msg := range msgs {
+		pkts = append(pkts, buildUDP(caddr, saddr, msg))
+	}
+	n, err := raw.WriteBatch(pkts)
+	require.NoError(t, err)
+	require.Equal(t, len(msgs), n)
+
+	var b = make([]byte, 64)
+	require.NoError(t, udp.SetReadDeadline(time.Now().Add(time.Second*3)))
+	for _, msg := range msgs {
+		n, from, err := udp.ReadFromUDPAddrPort(b)
+		require.NoError(t, err)
+		require.Equal(t, msg, string(b[:n]))
+
+		_, err = udp.WriteToUDPAddrPort(b[:n], from)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, raw.SetReadDeadline(time.Now().Add(time.Second*3)))
+	pkts = []*packet.Packet{packet.Make(0, 1536), packet.Make(0, 1536), packet.Make(0, 1536)}
+	var i int
+	for i < len(pkts) {
+		n, err := raw.ReadBatch(pkts[i:])
+		require.NoError(t, err)
+		i += n
+	}
+	for i, msg := range msgs {
+		require.Equal(t, msg, string(header.UDP(pkts[i].Bytes()).Payload()))
+	}
+}
+
+func Test_ChecksumOffload_Loopback(t *testing.T) {
+	// loopback nic not support checksum offload, always fallback
+	for _, offload := range []bool{true, false} {
+		for _, mmap := range []bool{false, true} {
+			var (
+				caddr = netip.AddrPortFrom(test.LocIP(), test.RandPort())
+				saddr = netip.AddrPortFrom(test.LocIP(), test.RandPort())
+			)
+
+			udp, err := net.ListenUDP("udp", test.UDPAddr(saddr))
+			require.NoError(t, err)
+			defer udp.Close()
+
+			var opts = []rawsock.Option{rawsock.SetGRO(false), rawsock.ChecksumOffload(offload)}
+			if mmap {
+				opts = append(opts, rawsock.PacketMMAP(0, 0, 0))
+			}
+			raw, err := Connect(caddr, saddr, opts...)
+			require.NoError(t, err)
+			defer raw.Close()
+			require.False(t, raw.Offload())
+
+			require.NoError(t, raw.Write(buildUDP(caddr, saddr, "hello")))
+			n, err := raw.WriteBatch([]*packet.Packet{buildUDP(caddr, saddr, "world")})
+			require.NoError(t, err)
+			require.Equal(t, 1, n)
+
+			var b = make([]byte, 64)
+			require.NoError(t, udp.SetReadDeadline(time.Now().Add(time.Second*3)))
+			for _, msg := range []string{"hello", "world"} {
+				n, err := udp.Read(b)
+				require.NoError(t, err)
+				require.Equal(t, msg, string(b[:n]))
+			}
+		}
+	}
+}
+
+func Test_UserGRO(t *testing.T) {
+	// loopback nic not segment UDP_SEGMENT packet if enable gso, recv as GSO_UDP_L4 super-packet
+	lo, err := helper.LoopbackInterface()
+	require.NoError(t, err)
+	ref, err := bind.SetTSO(lo.Name, true)
+	require.NoError(t, err)
+	defer ref.Close()
+
+	for _, mmap := range []bool{false, true} {
+		var (
+			caddr = netip.AddrPortFrom(test.LocIP(), test.RandPort())
+			saddr = netip.AddrPortFrom(test.LocIP(), test.RandPort())
+		)
+
+		var opts = []rawsock.Option{rawsock.UserGRO()}
+		if mmap {
+			opts = append(opts, rawsock.PacketMMAP(0, 0, 0))
+		}
+		raw, err := Connect(caddr, saddr, opts...)
+		require.NoError(t, err)
+		defer raw.Close()
+
+		udp, err := net.DialUDP("udp", test.UDPAddr(saddr), test.UDPAddr(caddr))
+		require.NoError(t, err)
+		defer udp.Close()
+		rc, err := udp.SyscallConn()
+		require.NoError(t, err)
+		require.NoError(t, rc.Control(func(fd uintptr) {
+			require.NoError(t, unix.SetsockoptInt(int(fd), unix.SOL_UDP, unix.UDP_SEGMENT, 100))
+		}))
+
+		var msg = make([]byte, 1050)
+		for i := range msg {
+			msg[i] = byte(i)
+		}
+		_, err = udp.Write(msg)
+		require.NoError(t, err)
+
+		require.NoError(t, raw.SetReadDeadline(time.Now().Add(time.Second*3)))
+		for i := 0; i < len(msg); i += 100 {
+			var pkt = packet.Make(0, 1536)
+			require.NoError(t, raw.ReadRaw(pkt))
+			test.ValidIP(t, pkt.Bytes())
+
+			udphdr := header.UDP(header.IPv4(pkt.Bytes()).Payload())
+			require.Equal(t, caddr.Port(), udphdr.DestinationPort())
+			require.Equal(t, msg[i:min(i+100, len(msg))], []byte(udphdr.Payload()))
+		}
+	}
+}
+
+func Test_Deadline(t *testing.T) {
+	var (
+		caddr = netip.AddrPortFrom(test.LocIP(), test.RandPort())
+		saddr = netip.AddrPortFrom(test.LocIP(), test.RandPort())
+	)
+
+	raw, err := Connect(caddr, saddr, rawsock.SetGRO(false))
+	require.NoError(t, err)
+	defer raw.Close()
+
+	require.NoError(t, raw.SetReadDeadline(time.Now().Add(time.Millisecond*100)))
+	err = raw.Read(packet.Make(0, 1536))
+	require.True(t, errors.Is(err, os.ErrDeadlineExceeded), err)
+}
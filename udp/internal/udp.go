@@ -18,4 +18,9 @@ func SizeRange(ipv4 bool) (min, max int) {
 	return
 }
 
-type CloseCallback func(netip.AddrPort) error
+type ID struct {
+	Local  netip.AddrPort
+	Remote netip.AddrPort
+}
+
+type CloseCallback func(ID) error
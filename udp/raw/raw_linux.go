@@ -4,10 +4,12 @@
 package raw
 
 import (
+	"context"
 	"net"
 	"net/netip"
 	"sync"
 	"syscall"
+	"time"
 
 	"golang.org/x/sys/unix"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
@@ -31,9 +33,11 @@ type Listener struct {
 
 	udp int // unix fd
 
-	raw *net.IPConn
+	raw *helper.IPConn
+	// serialize AcceptCtx reads
+	reader *helper.CtxReader
 
-	conns   map[netip.AddrPort]struct{}
+	conns   map[iudp.ID]struct{}
 	connsMu sync.RWMutex
 
 	closeErr errorx.CloseErr
@@ -44,39 +48,28 @@ var _ rawsock.Listener = (*Listener)(nil)
 func Listen(laddr netip.AddrPort, opts ...rawsock.Option) (*Listener, error) {
 	var l = &Listener{
 		cfg:   rawsock.Options(opts...),
-		conns: make(map[netip.AddrPort]struct{}, 16),
+		conns: make(map[iudp.ID]struct{}, 16),
 	}
 	var err error
 
-	// usaully should listen on all nic, but we juse listen on default nic
-	if laddr.Addr().IsUnspecified() {
-		laddr = netip.AddrPortFrom(rawsock.LocalAddr(), laddr.Port())
-	}
-
 	l.udp, l.addr, err = bind.BindLocal(header.UDPProtocolNumber, laddr, l.cfg.UsedPort)
 	if err != nil {
 		return nil, l.close(err)
 	}
 
-	l.raw, err = net.ListenIP(
-		"ip:udp",
-		&net.IPAddr{IP: l.addr.Addr().AsSlice(), Zone: laddr.Addr().Zone()},
-	)
+	l.raw, err = helper.ListenIP(header.UDPProtocolNumber, l.addr.Addr())
 	if err != nil {
 		return nil, l.close(err)
 	}
 
-	// todo: bpf can return IPv4HeaderSize+8
-	if raw, err := l.raw.SyscallConn(); err != nil {
+	if err = l.raw.SetBPF(
+		// the first datagram of new flow will be delivered to Conn, can't be
+		// truncated by bpf, only copy headers by SnapRead
+		bpf.FilterDstPort(l.addr.Port(), 0xffff),
+	); err != nil {
 		return nil, l.close(err)
-	} else {
-		if err = bpf.SetRawBPF(
-			raw,
-			bpf.FilterDstPort(l.addr.Port()),
-		); err != nil {
-			return nil, l.close(err)
-		}
 	}
+	l.reader = helper.NewCtxReader(l.raw.SnapRead(bpf.SnapUDP, l.isNew), 0xffff)
 
 	return l, nil
 }
@@ -88,6 +81,9 @@ func (l *Listener) close(cause error) error {
 		if l.udp != 0 {
 			errs = append(errs, errors.WithStack(unix.Close(l.udp)))
 		}
+		if l.reader != nil {
+			errs = append(errs, l.reader.Close())
+		}
 		if l.raw != nil {
 			errs = append(errs, errors.WithStack(l.raw.Close()))
 		}
@@ -96,33 +92,22 @@ func (l *Listener) close(cause error) error {
 }
 
 func (l *Listener) Accept() (rawsock.RawConn, error) {
-	min, max := iudp.SizeRange(l.addr.Addr().Is4())
+	return l.AcceptCtx(context.Background())
+}
 
-	var ip = make([]byte, max)
+func (l *Listener) AcceptCtx(ctx context.Context) (rawsock.RawConn, error) {
 	for {
-		n, err := l.raw.Read(ip[:max])
+		// only read headers, except the first datagram of new flow, see isNew
+		ip, err := l.reader.ReadCtx(ctx)
 		if err != nil {
-			return nil, errors.WithStack(err)
-		} else if n < min {
-			return nil, errors.Errorf("recved invalid ip packet, bytes %d", n)
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			return nil, l.close(err)
 		}
-
-		var id netip.AddrPort
-		switch header.IPVersion(ip) {
-		case 4:
-			iphdr := header.IPv4(ip[:n])
-			id = netip.AddrPortFrom(
-				netip.AddrFrom4(iphdr.SourceAddress().As4()),
-				header.UDP(iphdr[iphdr.HeaderLength():]).SourcePort(),
-			)
-		case 6:
-			iphdr := header.IPv6(ip[:n])
-			id = netip.AddrPortFrom(
-				netip.AddrFrom16(iphdr.SourceAddress().As16()),
-				header.UDP(iphdr[header.IPv6FixedHeaderSize:]).SourcePort(),
-			)
-		default:
-			continue
+		id, err := l.flow(ip)
+		if err != nil {
+			return nil, err
 		}
 
 		l.connsMu.RLock()
@@ -133,7 +118,8 @@ func (l *Listener) Accept() (rawsock.RawConn, error) {
 			l.conns[id] = struct{}{}
 			l.connsMu.Unlock()
 
-			c := newConnect(l.addr, id, l.deleteConn)
+			c := newConnect(id.Local, id.Remote, l.deleteConn)
+			c.first = ip
 			if err := c.init(l.cfg); err != nil {
 				return nil, errorx.WrapTemp(c.close(err))
 			}
@@ -142,12 +128,56 @@ func (l *Listener) Accept() (rawsock.RawConn, error) {
 	}
 }
 
-func (l *Listener) deleteConn(raddr netip.AddrPort) error {
+// flow parse flow id of packet recved by Listener, the packet maybe truncated.
+func (l *Listener) flow(ip []byte) (id iudp.ID, err error) {
+	min, _ := iudp.SizeRange(l.addr.Addr().Is4())
+	if len(ip) < min {
+		return id, errors.Errorf("recved invalid ip packet, bytes %d", len(ip))
+	}
+	hdrLen, err := helper.IPHeaderLen(ip)
+	if err != nil {
+		return id, err
+	} else if len(ip) < hdrLen+header.UDPMinimumSize {
+		return id, errors.Errorf("recved invalid ip packet, bytes %d", len(ip))
+	}
+
+	// if listen on unspecified address, local address is the packet's destination
+	switch header.IPVersion(ip) {
+	case 4:
+		iphdr := header.IPv4(ip)
+		udphdr := header.UDP(iphdr[hdrLen:])
+		id.Local = netip.AddrPortFrom(netip.AddrFrom4(iphdr.DestinationAddress().As4()), l.addr.Port())
+		id.Remote = netip.AddrPortFrom(netip.AddrFrom4(iphdr.SourceAddress().As4()), udphdr.SourcePort())
+	case 6:
+		iphdr := header.IPv6(ip)
+		udphdr := header.UDP(iphdr[hdrLen:])
+		id.Local = netip.AddrPortFrom(netip.AddrFrom16(iphdr.DestinationAddress().As16()), l.addr.Port())
+		id.Remote = netip.AddrPortFrom(netip.AddrFrom16(iphdr.SourceAddress().As16()), udphdr.SourcePort())
+	default:
+		return id, errors.Errorf("recved invalid ip packet, version %d", header.IPVersion(ip))
+	}
+	return id, nil
+}
+
+// isNew report whether the packet is the first datagram of new flow, it will
+// be read entirely and delivered to Conn.
+func (l *Listener) isNew(ip []byte) bool {
+	id, err := l.flow(ip)
+	if err != nil {
+		return false
+	}
+	l.connsMu.RLock()
+	defer l.connsMu.RUnlock()
+	_, has := l.conns[id]
+	return !has
+}
+
+func (l *Listener) deleteConn(id iudp.ID) error {
 	if l == nil {
 		return nil
 	}
 	l.connsMu.Lock()
-	delete(l.conns, raddr)
+	delete(l.conns, id)
 	l.connsMu.Unlock()
 	return nil
 }
@@ -184,20 +214,30 @@ type Conn struct {
 	udp int
 
 	// todo: UDPConn set
-	raw     *net.IPConn
+	raw     *helper.IPConn
+	offload *bind.OffloadRef
+
+	// IPPROTO_RAW socket, used to write/inject ip packet
+	hdrincl *net.IPConn
+	batch   *helper.Batch
 	ipstack *ipstack.IPStack
 
+	// datagram recved by Listener, it's the first read result
+	first   []byte
+	firstMu sync.Mutex
+
 	closeErr errorx.CloseErr
 }
 
 var _ rawsock.RawConn = (*Conn)(nil)
+var _ rawsock.BatchConn = (*Conn)(nil)
 
 func (c *Conn) close(cause error) error {
 	return c.closeErr.Close(func() (errs []error) {
 		errs = append(errs, cause)
 
 		if c.closeCallback != nil {
-			errs = append(errs, c.closeCallback(c.RemoteAddr()))
+			errs = append(errs, c.closeCallback(iudp.ID{Local: c.laddr, Remote: c.raddr}))
 		}
 		if c.udp != 0 {
 			errs = append(errs, errors.WithStack(syscall.Close(c.udp)))
@@ -205,6 +245,10 @@ func (c *Conn) close(cause error) error {
 		if c.raw != nil {
 			errs = append(errs, c.raw.Close())
 		}
+		if c.hdrincl != nil {
+			errs = append(errs, c.hdrincl.Close())
+		}
+		errs = append(errs, c.offload.Close())
 		return
 	})
 }
@@ -213,34 +257,33 @@ func newConnect(laddr, raddr netip.AddrPort, close iudp.CloseCallback) *Conn {
 }
 
 func (c *Conn) init(cfg *rawsock.Config) (err error) {
-	if c.raw, err = net.DialIP(
-		"ip:udp",
-		&net.IPAddr{IP: c.laddr.Addr().AsSlice()},
-		&net.IPAddr{IP: c.raddr.Addr().AsSlice()},
+	if c.raw, err = helper.DialIP(
+		header.UDPProtocolNumber,
+		c.laddr.Addr(), c.raddr.Addr(),
 	); err != nil {
-		return errors.WithStack(err)
+		return err
 	}
 
 	if cfg.SetGRO {
-		if err = bind.SetGRO(c.laddr.Addr(), c.raddr.Addr(), false); err != nil {
+		if c.offload, err = bind.SetGRO(c.laddr.Addr(), c.raddr.Addr(), false); err != nil {
 			return err
 		}
 	}
 
-	if raw, err := c.raw.SyscallConn(); err != nil {
-		return errors.WithStack(err)
-	} else {
-		err := bpf.SetRawBPF(raw,
-			bpf.FilterPorts(c.raddr.Port(), c.laddr.Port()),
-		)
-		if err != nil {
-			return err
-		}
+	if err = c.raw.SetBPF(
+		bpf.FilterPorts(c.raddr.Port(), c.laddr.Port()),
+	); err != nil {
+		return err
+	}
+
+	if c.hdrincl, err = helper.ListenRawIP(c.laddr.Addr()); err != nil {
+		return err
 	}
+	c.batch = helper.NewBatch(c.raw)
 
 	if c.ipstack, err = ipstack.New(
 		c.laddr.Addr(), c.raddr.Addr(),
-		header.TCPProtocolNumber,
+		header.UDPProtocolNumber,
 		cfg.IPStack.Unmarshal(),
 	); err != nil {
 		return err
@@ -249,36 +292,92 @@ func (c *Conn) init(cfg *rawsock.Config) (err error) {
 }
 
 func (c *Conn) Read(pkt *packet.Packet) (err error) {
-	n, err := c.raw.Read(pkt.Bytes())
+	hdrLen, err := c.read(pkt)
 	if err != nil {
 		return err
 	}
+	pkt.SetHead(pkt.Head() + hdrLen)
+	return nil
+}
+func (c *Conn) ReadRaw(ip *packet.Packet) (err error) {
+	_, err = c.read(ip)
+	return err
+}
+func (c *Conn) read(pkt *packet.Packet) (hdrLen int, err error) {
+	n, err := c.readFirst(pkt)
+	if err != nil {
+		return 0, err
+	} else if n == 0 {
+		if n, err = c.raw.Read(pkt.Bytes()); err != nil {
+			return 0, err
+		}
+	}
 	pkt.SetData(n)
 
-	hdrLen, err := helper.IPCheck(pkt.Bytes())
+	hdrLen, err = helper.CheckIP(pkt.Bytes())
 	if err != nil {
-		return err
+		return 0, err
 	}
 	if debug.Debug() {
 		test.ValidIP(test.P(), pkt.Bytes())
 	}
-	pkt.SetHead(pkt.Head() + int(hdrLen))
-	return nil
+	return hdrLen, nil
+}
+
+// readFirst read the datagram recved by Listener, return 0 if it has been read
+func (c *Conn) readFirst(pkt *packet.Packet) (int, error) {
+	c.firstMu.Lock()
+	defer c.firstMu.Unlock()
+	if c.first == nil {
+		return 0, nil
+	} else if pkt.Data() < len(c.first) {
+		return 0, errorx.ShortBuff(len(c.first), pkt.Data())
+	}
+
+	n := copy(pkt.Bytes(), c.first)
+	c.first = nil
+	return n, nil
+}
+func (c *Conn) ReadBatch(pkts []*packet.Packet) (n int, err error) {
+	c.firstMu.Lock()
+	has := c.first != nil
+	c.firstMu.Unlock()
+	if has && len(pkts) > 0 {
+		if err = c.Read(pkts[0]); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	}
+	return c.batch.ReadBatch(pkts)
 }
 func (c *Conn) Write(pkt *packet.Packet) (err error) {
 	_, err = c.raw.Write(pkt.Bytes())
 	return err
 }
+func (c *Conn) WriteBatch(pkts []*packet.Packet) (n int, err error) {
+	return c.batch.WriteBatch(pkts)
+}
+func (c *Conn) WriteRaw(ip *packet.Packet) (err error) {
+	if debug.Debug() {
+		test.ValidIP(test.P(), ip.Bytes())
+	}
+	return helper.WriteRawIP(c.hdrincl, ip.Bytes())
+}
 func (c *Conn) Inject(pkt *packet.Packet) (err error) {
 	defer pkt.DetachN(c.ipstack.Size())
 	c.ipstack.AttachInbound(pkt)
+	return c.InjectRaw(pkt)
+}
+func (c *Conn) InjectRaw(ip *packet.Packet) (err error) {
 	if debug.Debug() {
-		test.ValidIP(test.P(), pkt.Bytes())
+		test.ValidIP(test.P(), ip.Bytes())
 	}
-	_, err = c.raw.Write(pkt.Bytes())
-	return err
+	return helper.WriteRawIP(c.hdrincl, ip.Bytes())
 }
 
-func (c *Conn) LocalAddr() netip.AddrPort  { return c.laddr }
-func (c *Conn) RemoteAddr() netip.AddrPort { return c.raddr }
-func (c *Conn) Close() error               { return c.close(nil) }
+func (c *Conn) LocalAddr() netip.AddrPort          { return c.laddr }
+func (c *Conn) RemoteAddr() netip.AddrPort         { return c.raddr }
+func (c *Conn) SetDeadline(t time.Time) error      { return c.raw.SetDeadline(t) }
+func (c *Conn) SetReadDeadline(t time.Time) error  { return c.raw.SetReadDeadline(t) }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.raw.SetWriteDeadline(t) }
+func (c *Conn) Close() error                       { return c.close(nil) }
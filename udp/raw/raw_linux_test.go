@@ -10,12 +10,14 @@ import (
 	"math/rand"
 	"net"
 	"net/netip"
+	"os"
 	"testing"
 	"time"
 
 	"bou.ke/monkey"
 	"github.com/lysShub/netkit/debug"
 	"github.com/lysShub/netkit/packet"
+	"github.com/lysShub/rawsock"
 	"github.com/lysShub/rawsock/test"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
@@ -169,6 +171,144 @@ func Test_Connect(t *testing.T) {
 	})
 }
 
+func Test_Deadline(t *testing.T) {
+	var (
+		caddr = netip.AddrPortFrom(test.LocIP(), test.RandPort())
+		saddr = netip.AddrPortFrom(test.LocIP(), test.RandPort())
+	)
+
+	raw, err := Connect(caddr, saddr, rawsock.SetGRO(false))
+	require.NoError(t, err)
+	defer raw.Close()
+
+	err = raw.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
+	require.NoError(t, err)
+
+	start := time.Now()
+	err = raw.Read(packet.Make(0, 1536))
+	require.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func Test_IPv6(t *testing.T) {
+	var (
+		saddr = netip.AddrPortFrom(netip.IPv6Loopback(), test.RandPort())
+		caddr = netip.AddrPortFrom(netip.IPv6Loopback(), test.RandPort())
+		msg   = []byte("hello")
+	)
+
+	l, err := Listen(saddr, rawsock.SetGRO(false))
+	require.NoError(t, err)
+	defer l.Close()
+
+	conn, err := net.DialUDP("udp6", test.UDPAddr(caddr), test.UDPAddr(saddr))
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write(msg)
+	require.NoError(t, err)
+
+	raw, err := l.Accept()
+	require.NoError(t, err)
+	defer raw.Close()
+	require.Equal(t, caddr, raw.RemoteAddr())
+
+	_, err = conn.Write(msg)
+	require.NoError(t, err)
+
+	var p = packet.Make(0, 1536)
+	err = raw.ReadRaw(p)
+	require.NoError(t, err)
+	iphdr := header.IPv6(p.Bytes())
+	require.Equal(t, caddr.Addr().String(), iphdr.SourceAddress().String())
+	require.Equal(t, saddr.Addr().String(), iphdr.DestinationAddress().String())
+
+	udp := header.UDP(iphdr.Payload())
+	require.Equal(t, caddr.Port(), udp.SourcePort())
+	require.Equal(t, msg, udp.Payload())
+}
+
+func Test_Listen_Unspecified(t *testing.T) {
+	l, err := Listen(netip.AddrPortFrom(netip.IPv4Unspecified(), 0), rawsock.SetGRO(false))
+	require.NoError(t, err)
+	defer l.Close()
+	require.True(t, l.Addr().Addr().IsUnspecified())
+
+	for _, addr := range []netip.Addr{netip.AddrFrom4([4]byte{127, 0, 0, 1}), test.LocIP()} {
+		var (
+			saddr = netip.AddrPortFrom(addr, l.Addr().Port())
+			caddr = netip.AddrPortFrom(addr, test.RandPort())
+		)
+		conn, err := net.DialUDP("udp4", test.UDPAddr(caddr), test.UDPAddr(saddr))
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("hello"))
+		require.NoError(t, err)
+
+		raw, err := l.Accept()
+		require.NoError(t, err)
+		defer raw.Close()
+		require.Equal(t, saddr, raw.LocalAddr())
+		require.Equal(t, caddr, raw.RemoteAddr())
+
+		// first read is the datagram recved by Listener
+		var first = packet.Make(0, 1536)
+		require.NoError(t, raw.Read(first))
+		require.Equal(t, "hello", string(header.UDP(first.Bytes()).Payload()))
+
+		// reply from actual local address
+		var p = packet.Make(64, header.UDPMinimumSize).Append([]byte("world")...)
+		header.UDP(p.Bytes()).Encode(&header.UDPFields{
+			SrcPort: saddr.Port(), DstPort: caddr.Port(), Length: uint16(p.Data()),
+		})
+		require.NoError(t, raw.Write(p))
+
+		var b = make([]byte, 64)
+		n, err := conn.Read(b)
+		require.NoError(t, err)
+		require.Equal(t, "world", string(b[:n]))
+	}
+}
+
+func Test_Accept_FirstDatagram(t *testing.T) {
+	for _, addr := range []netip.Addr{test.LocIP(), netip.IPv6Loopback()} {
+		t.Run(addr.String(), func(t *testing.T) {
+			var (
+				saddr = netip.AddrPortFrom(addr, test.RandPort())
+				caddr = netip.AddrPortFrom(addr, test.RandPort())
+			)
+			l, err := Listen(saddr, rawsock.SetGRO(false))
+			require.NoError(t, err)
+			defer l.Close()
+
+			conn, err := net.DialUDP("udp", test.UDPAddr(caddr), test.UDPAddr(saddr))
+			require.NoError(t, err)
+			defer conn.Close()
+
+			// longer than bpf.SnapUDP, the Listener only copy headers except the first datagram
+			var msg = make([]byte, 1024)
+			rand.New(rand.NewSource(0)).Read(msg)
+			_, err = conn.Write(msg)
+			require.NoError(t, err)
+			_, err = conn.Write(msg[:512])
+			require.NoError(t, err)
+
+			raw, err := l.Accept()
+			require.NoError(t, err)
+			defer raw.Close()
+
+			var p = packet.Make(0, 1536)
+			require.NoError(t, raw.Read(p))
+			require.Equal(t, msg, header.UDP(p.Bytes()).Payload())
+
+			// the second datagram is recved by Listener as known flow, Accept not return it
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+			defer cancel()
+			_, err = l.AcceptCtx(ctx)
+			require.True(t, errors.Is(err, context.DeadlineExceeded), err)
+		})
+	}
+}
+
 func Test_Default_Addr(t *testing.T) {
 	var addr = netip.AddrPortFrom(netip.IPv4Unspecified(), 0)
 